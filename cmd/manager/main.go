@@ -84,8 +84,10 @@ func main() {
 	}
 
 	controllerConfig := controller.RunConfig{
-		DryRun:     os.Getenv("DRY_RUN") != "",
-		NotifierCh: notifier.C,
+		DryRun:      os.Getenv("DRY_RUN") != "",
+		NotifierCh:  notifier.C,
+		CaptureFile: os.Getenv("CAPTURE_FILE"),
+		ReplayFile:  os.Getenv("REPLAY_FILE"),
 	}
 
 	dep, err := controller.GetDependencies(controllerConfig)
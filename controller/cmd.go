@@ -2,19 +2,32 @@ package controller
 
 import (
 	"fmt"
+	"log"
+	"time"
 
 	"github.com/NeilSeligmann/G15Manager/cxx/plugin/aidenoise"
-	"github.com/NeilSeligmann/G15Manager/cxx/plugin/gpu"
+	gpudevice "github.com/NeilSeligmann/G15Manager/cxx/plugin/gpu"
 	"github.com/NeilSeligmann/G15Manager/cxx/plugin/keyboard"
-	"github.com/NeilSeligmann/G15Manager/cxx/plugin/rr"
 	"github.com/NeilSeligmann/G15Manager/cxx/plugin/volume"
+	cxxrr "github.com/NeilSeligmann/G15Manager/cxx/rr"
 
 	"github.com/NeilSeligmann/G15Manager/supervisor/background"
 	"github.com/NeilSeligmann/G15Manager/system/atkacpi"
 	"github.com/NeilSeligmann/G15Manager/system/battery"
+	"github.com/NeilSeligmann/G15Manager/system/battery/telemetry"
+	"github.com/NeilSeligmann/G15Manager/system/capture"
+	"github.com/NeilSeligmann/G15Manager/system/dgpu"
+	"github.com/NeilSeligmann/G15Manager/system/display"
+	"github.com/NeilSeligmann/G15Manager/system/gpu"
+	"github.com/NeilSeligmann/G15Manager/system/gpumode"
+	kb "github.com/NeilSeligmann/G15Manager/system/keyboard"
+	"github.com/NeilSeligmann/G15Manager/system/model"
+	"github.com/NeilSeligmann/G15Manager/system/nvidia"
+	"github.com/NeilSeligmann/G15Manager/system/panel"
 	"github.com/NeilSeligmann/G15Manager/system/persist"
 	"github.com/NeilSeligmann/G15Manager/system/plugin"
 	"github.com/NeilSeligmann/G15Manager/system/power"
+	"github.com/NeilSeligmann/G15Manager/system/rr"
 	"github.com/NeilSeligmann/G15Manager/system/thermal"
 	"github.com/NeilSeligmann/G15Manager/util"
 
@@ -26,10 +39,16 @@ type RunConfig struct {
 	DryRun     bool
 	PreLogin   bool
 	NotifierCh chan util.Notification
+
+	// CaptureFile, if set, is where raw HID reports and ACPI events will be recorded
+	CaptureFile string
+	// ReplayFile, if set, is a previously captured file to be fed into the controller
+	ReplayFile string
 }
 
 type Dependencies struct {
 	WMI              atkacpi.WMI
+	Model            *model.Model
 	Keyboard         *keyboard.Control
 	Battery          *battery.ChargeLimit
 	Volume           *volume.Control
@@ -37,6 +56,7 @@ type Dependencies struct {
 	GPU              *gpu.Control
 	RR               *rr.Control
 	AIDenoise        *aidenoise.Control
+	Panel            *panel.Control
 	ConfigRegistry   persist.ConfigRegistry
 	Version          *background.VersionChecker
 	ClientDownloader *background.ClientDownloader
@@ -45,6 +65,8 @@ type Dependencies struct {
 
 func GetDependencies(conf RunConfig) (*Dependencies, error) {
 
+	model := model.Detect()
+
 	wmi, err := atkacpi.NewWMI(conf.DryRun)
 	if err != nil {
 		return nil, err
@@ -64,10 +86,15 @@ func GetDependencies(conf RunConfig) (*Dependencies, error) {
 		return nil, err
 	}
 
+	// shared so the consumers don't each spawn nvidia-smi
+	smi := nvidia.NewSMI(nvidia.NewRunner(), time.Second, time.Second*10)
+
 	thermalCfg := thermal.Config{
 		WMI:      wmi,
+		Model:    model,
 		PowerCfg: powercfg,
 		Profiles: thermal.GetDefaultThermalProfiles(),
+		GPU:      smi,
 	}
 
 	thermal, err := thermal.NewControl(thermalCfg)
@@ -75,14 +102,29 @@ func GetDependencies(conf RunConfig) (*Dependencies, error) {
 		return nil, err
 	}
 
-	battery, err := battery.NewChargeLimit(wmi)
+	batteryStatus, err := telemetry.NewService(telemetry.NewProvider(), time.Second*5)
+	if err != nil {
+		return nil, err
+	}
+
+	battery, err := battery.NewChargeLimit(wmi, batteryStatus, model)
 	if err != nil {
 		return nil, err
 	}
 
 	kbCtrl, err := keyboard.NewControl(keyboard.Config{
-		DryRun: conf.DryRun,
-		RogKey: []string{"$webclient", "Taskmgr.exe"},
+		DryRun:          conf.DryRun,
+		Model:           model,
+		RogKey:          []string{"$webclient", "Taskmgr.exe"},
+		TouchPadEnabled: true,
+		Bindings: kb.Bindings{
+			kb.KeyFnF4: "$key:playPause",
+		},
+		Fades: kb.Fades{
+			Wake: time.Millisecond * 600,
+			Off:  time.Millisecond * 300,
+			Keys: time.Millisecond * 150,
+		},
 	})
 	if err != nil {
 		return nil, err
@@ -93,12 +135,34 @@ func GetDependencies(conf RunConfig) (*Dependencies, error) {
 		return nil, err
 	}
 
-	gpuCtrl, err := gpu.NewGPUControl(conf.DryRun)
+	gpuMode, err := gpumode.NewControl(wmi, model)
+	if err != nil {
+		return nil, err
+	}
+
+	gpuDevice := gpudevice.NewDevice()
+	if conf.DryRun {
+		gpuDevice = dgpu.NewFake(dgpu.Status{State: dgpu.Enabled})
+	}
+
+	gpuCtrl, err := gpu.NewGPUControl(conf.DryRun, gpuDevice, gpuMode, smi)
+	if err != nil {
+		return nil, err
+	}
+
+	rrCtrl, err := rr.NewRRControl(conf.DryRun, func() (display.RefreshRater, error) {
+		return cxxrr.NewDisplayRR()
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	rrCtrl, err := rr.NewRRControl(conf.DryRun)
+	backlight := panel.NewBacklight()
+	if conf.DryRun {
+		backlight = &panel.FakeBacklight{Level: 100}
+	}
+
+	panelCtrl, err := panel.NewControl(wmi, model, backlight)
 	if err != nil {
 		return nil, err
 	}
@@ -115,7 +179,14 @@ func GetDependencies(conf RunConfig) (*Dependencies, error) {
 	}
 
 	config.Register(kbCtrl)
+	config.Register(gpuCtrl)
+	config.Register(gpuMode)
+	config.Register(rrCtrl)
+	config.Register(panelCtrl)
 	config.Register(battery)
+	config.Register(battery.ScheduleRegistry())
+	config.Register(battery.HealthRegistry())
+	config.Register(battery.LowRulesRegistry())
 	config.Register(thermal)
 
 	// updatable := []announcement.Updatable{
@@ -125,6 +196,7 @@ func GetDependencies(conf RunConfig) (*Dependencies, error) {
 
 	return &Dependencies{
 		WMI:            wmi,
+		Model:          model,
 		Keyboard:       kbCtrl,
 		Battery:        battery,
 		Volume:         volCtrl,
@@ -132,6 +204,7 @@ func GetDependencies(conf RunConfig) (*Dependencies, error) {
 		GPU:            gpuCtrl,
 		RR:             rrCtrl,
 		AIDenoise:      aiDenoiseCtrl,
+		Panel:          panelCtrl,
 		ConfigRegistry: config,
 		// Updatable:      updatable,
 	}, nil
@@ -146,6 +219,9 @@ func New(conf RunConfig, dep *Dependencies) (*Controller, chan error, error) {
 	if dep.WMI == nil {
 		return nil, nil, errors.New("nil WMI is invalid")
 	}
+	if dep.Model == nil {
+		return nil, nil, errors.New("nil Model is invalid")
+	}
 	if dep.ConfigRegistry == nil {
 		return nil, nil, errors.New("nil Registry is invalid")
 	}
@@ -153,32 +229,50 @@ func New(conf RunConfig, dep *Dependencies) (*Controller, chan error, error) {
 		return nil, nil, errors.New("nil NotifierCh is invalid")
 	}
 
+	var recorder *capture.Recorder
+	if conf.CaptureFile != "" {
+		var err error
+		recorder, err = capture.Create(conf.CaptureFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Printf("[controller] capturing events to %s\n", conf.CaptureFile)
+	}
+
 	startErrorCh := make(chan error, 1)
 	control := &Controller{
 		Config: Config{
-			WMI: dep.WMI,
+			WMI:   dep.WMI,
+			Model: dep.Model,
 
 			Plugins: []plugin.Plugin{
 				dep.Keyboard,
+				dep.Battery,
 				dep.Volume,
 				dep.Thermal,
 				dep.GPU,
 				dep.RR,
 				dep.AIDenoise,
+				dep.Panel,
 			},
 			Registry: dep.ConfigRegistry,
 
 			Notifier: conf.NotifierCh,
+
+			Recorder:   recorder,
+			ReplayFile: conf.ReplayFile,
 		},
 
 		workQueueCh:  make(map[uint32]workQueue, 1),
 		errorCh:      make(chan error),
 		startErrorCh: startErrorCh,
 
-		keyCodeCh:  make(chan uint32, 1),
-		acpiCh:     make(chan uint32, 1),
-		powerEvCh:  make(chan uint32, 1),
-		pluginCbCh: make(chan plugin.Callback, 1),
+		keyCodeCh:   make(chan uint32, 1),
+		acpiCh:      make(chan uint32, 1),
+		powerEvCh:   make(chan uint32, 1),
+		displayCh:   make(chan display.Change, 1),
+		hidHealthCh: make(chan kb.Health, 1),
+		pluginCbCh:  make(chan plugin.Callback, 1),
 	}
 
 	return control, startErrorCh, nil
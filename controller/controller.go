@@ -6,7 +6,10 @@ import (
 	"time"
 
 	"github.com/NeilSeligmann/G15Manager/system/atkacpi"
+	"github.com/NeilSeligmann/G15Manager/system/capture"
+	"github.com/NeilSeligmann/G15Manager/system/display"
 	"github.com/NeilSeligmann/G15Manager/system/keyboard"
+	"github.com/NeilSeligmann/G15Manager/system/model"
 	"github.com/NeilSeligmann/G15Manager/system/persist"
 	"github.com/NeilSeligmann/G15Manager/system/plugin"
 	"github.com/NeilSeligmann/G15Manager/system/power"
@@ -48,13 +51,19 @@ func (c chargerStatus) String() string {
 
 // Config contains the configurations for the controller
 type Config struct {
-	WMI atkacpi.WMI
+	WMI   atkacpi.WMI
+	Model *model.Model
 
 	Plugins  []plugin.Plugin
 	Registry persist.ConfigRegistry
 
 	LogoPath string
 	Notifier chan<- util.Notification
+
+	// Recorder, if set, captures raw HID reports and ACPI events
+	Recorder *capture.Recorder
+	// ReplayFile, if set, is a capture to be replayed into the controller
+	ReplayFile string
 }
 
 type workQueue struct {
@@ -70,10 +79,12 @@ type Controller struct {
 	errorCh      chan error
 	startErrorCh chan error
 
-	keyCodeCh  chan uint32
-	acpiCh     chan uint32
-	powerEvCh  chan uint32
-	pluginCbCh chan plugin.Callback
+	keyCodeCh   chan uint32
+	acpiCh      chan uint32
+	powerEvCh   chan uint32
+	displayCh   chan display.Change
+	hidHealthCh chan keyboard.Health
+	pluginCbCh  chan plugin.Callback
 }
 
 func (c *Controller) initialize(haltCtx context.Context) error {
@@ -83,7 +94,15 @@ func (c *Controller) initialize(haltCtx context.Context) error {
 		}
 	}
 
-	_, err := keyboard.NewHidListener(haltCtx, c.keyCodeCh)
+	hidConfig := keyboard.HidListenerConfig{
+		Enumerator: keyboard.NewUSBEnumerator(c.Config.Model.VendorID, c.Config.Model.ProductID),
+		EventCh:    c.keyCodeCh,
+		HealthCh:   c.hidHealthCh,
+	}
+	if c.Config.Recorder != nil {
+		hidConfig.OnReport = c.Config.Recorder.RecordReport
+	}
+	err := keyboard.NewHidListener(haltCtx, hidConfig)
 	if err != nil {
 		return errors.Wrap(err, "[controller] error initializing hid listener")
 	}
@@ -98,6 +117,22 @@ func (c *Controller) initialize(haltCtx context.Context) error {
 		return errors.Wrap(err, "[controller] error initializing power event listener")
 	}
 
+	err = display.NewTopologyListener(haltCtx, c.displayCh)
+	if err != nil {
+		return errors.Wrap(err, "[controller] error initializing display topology listener")
+	}
+
+	if c.Config.ReplayFile != "" {
+		go func() {
+			log.Printf("[controller] replaying events from %s\n", c.Config.ReplayFile)
+			if err := capture.ReplayFile(haltCtx, c.Config.ReplayFile, c.keyCodeCh, c.acpiCh, 1); err != nil {
+				log.Printf("[controller] error replaying events: %v\n", err)
+				return
+			}
+			log.Println("[controller] finished replaying events")
+		}()
+	}
+
 	initBuf := make([]byte, 4)
 	if _, err := c.Config.WMI.Evaluate(atkacpi.INIT, initBuf); err != nil {
 		return errors.Wrap(err, "[controller] cannot initialize ATKD")
@@ -195,8 +230,10 @@ func (c *Controller) Serve(haltCtx context.Context) error {
 	go c.handlePluginCallback(haltCtx)
 	go c.handleWorkQueue(haltCtx)
 	go c.handlePowerEvent(haltCtx)
+	go c.handleDisplayChange(haltCtx)
 	go c.handleACPINotification(haltCtx)
 	go c.handleKeyPress(haltCtx)
+	go c.handleHidHealth(haltCtx)
 
 	for {
 		select {
@@ -204,6 +241,9 @@ func (c *Controller) Serve(haltCtx context.Context) error {
 			if err := c.Registry.Save(); err != nil {
 				log.Printf("[controller] unable to save to config registry: %+v\n", err)
 			}
+			if c.Config.Recorder != nil {
+				c.Config.Recorder.Close()
+			}
 			log.Println("[controller] exiting Run loop")
 			return nil
 		case err := <-c.errorCh:
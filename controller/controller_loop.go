@@ -19,6 +19,9 @@ func (c *Controller) handleACPINotification(haltCtx context.Context) {
 	for {
 		select {
 		case acpi := <-c.acpiCh:
+			if c.Config.Recorder != nil {
+				c.Config.Recorder.RecordACPI(acpi)
+			}
 			switch acpi {
 			case 87, 88, 207: // ignore these events
 				continue
@@ -65,6 +68,19 @@ func (c *Controller) handlePowerEvent(haltCtx context.Context) {
 	}
 }
 
+func (c *Controller) handleDisplayChange(haltCtx context.Context) {
+	for {
+		select {
+		case change := <-c.displayCh:
+			log.Printf("[controller] display topology changed, attached: %v, detached: %v, primary: %s\n", change.Attached, change.Detached, change.Topology.Primary())
+			c.notifyPlugins(plugin.EvtDisplayTopologyChanged, change)
+		case <-haltCtx.Done():
+			log.Println("[controller] exiting handleDisplayChange")
+			return
+		}
+	}
+}
+
 func (c *Controller) handleKeyPress(haltCtx context.Context) {
 	for {
 		select {
@@ -72,6 +88,13 @@ func (c *Controller) handleKeyPress(haltCtx context.Context) {
 			// log.Printf("Key press: ")
 			// log.Println(keyCode)
 
+			if !c.Config.Model.HasKey(keyCode) {
+				log.Printf("hid: Unknown %d\n", keyCode)
+				// unknown keys can still have a binding in the keyboard plugin
+				c.notifyPlugins(plugin.EvtKeyboardFn, keyCode)
+				continue
+			}
+
 			switch keyCode {
 			case kb.KeyROG:
 				log.Println("hid: ROG Key Pressed (debounced)")
@@ -122,6 +145,7 @@ func (c *Controller) handleKeyPress(haltCtx context.Context) {
 
 			default:
 				log.Printf("hid: Unknown %d\n", keyCode)
+				c.notifyPlugins(plugin.EvtKeyboardFn, keyCode)
 			}
 		case <-haltCtx.Done():
 			log.Println("[controller] exiting handleKeyPress")
@@ -130,6 +154,29 @@ func (c *Controller) handleKeyPress(haltCtx context.Context) {
 	}
 }
 
+func (c *Controller) handleHidHealth(haltCtx context.Context) {
+	lost := make(map[string]bool)
+	for {
+		select {
+		case h := <-c.hidHealthCh:
+			if h.Connected {
+				if lost[h.Interface] {
+					log.Printf("hid: %s reconnected\n", h.Interface)
+				}
+				lost[h.Interface] = false
+				continue
+			}
+			if !lost[h.Interface] {
+				log.Printf("hid: %s lost, hotkeys will not work until it is reconnected: %v\n", h.Interface, h.Err)
+			}
+			lost[h.Interface] = true
+		case <-haltCtx.Done():
+			log.Println("[controller] exiting handleHidHealth")
+			return
+		}
+	}
+}
+
 func (c *Controller) handleWorkQueue(haltCtx context.Context) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -153,7 +200,7 @@ func (c *Controller) handleWorkQueue(haltCtx context.Context) {
 
 		case ev := <-c.workQueueCh[fnCheckCharger].clean:
 			function := make([]byte, 4)
-			binary.LittleEndian.PutUint32(function, atkacpi.DstsCheckCharger)
+			binary.LittleEndian.PutUint32(function, c.Config.Model.Devices.CheckCharger)
 			status, err := c.Config.WMI.Evaluate(atkacpi.DSTS, function)
 			if err != nil {
 				c.errorCh <- errors.New("[controller] cannot check charger status")
@@ -211,7 +258,7 @@ func (c *Controller) handleWorkQueue(haltCtx context.Context) {
 			args := make([]byte, 8)
 			log.Printf("hwCtrl: notification from keypress on %d\n", keyCode)
 
-			binary.LittleEndian.PutUint32(args[0:], atkacpi.DevsHardwareCtrl)
+			binary.LittleEndian.PutUint32(args[0:], c.Config.Model.Devices.HardwareCtrl)
 			binary.LittleEndian.PutUint32(args[4:], keyCode)
 
 			_, err := c.Config.WMI.Evaluate(atkacpi.DEVS, args)
@@ -219,6 +266,9 @@ func (c *Controller) handleWorkQueue(haltCtx context.Context) {
 				c.errorCh <- errors.Wrap(err, "hwCtrl: error sending key code to ATKACPI")
 				return
 			}
+			if keyCode == kb.KeyLCDUp || keyCode == kb.KeyLCDDown {
+				c.notifyPlugins(plugin.EvtSentinelLCDBrightness, keyCode)
+			}
 
 		case <-c.workQueueCh[fnBeforeSuspend].clean:
 			c.notifyPlugins(plugin.EvtACPISuspend, nil)
@@ -259,6 +309,14 @@ func (c *Controller) handlePluginCallback(haltCtx context.Context) {
 				if n, ok := t.Value.(util.Notification); ok {
 					c.Config.Notifier <- n
 				}
+			case plugin.CbThermalProfileChanged:
+				c.notifyPlugins(plugin.EvtThermalProfileChanged, t.Value)
+			case plugin.CbBatteryLow:
+				c.notifyPlugins(plugin.EvtBatteryLow, t.Value)
+			case plugin.CbBatteryRestored:
+				c.notifyPlugins(plugin.EvtBatteryRestored, nil)
+			case plugin.CbStepLCDBrightness:
+				c.notifyPlugins(plugin.EvtStepLCDBrightness, t.Value)
 			}
 		case <-haltCtx.Done():
 			log.Println("[controller] exiting handlePluginCallback")
@@ -13,6 +13,7 @@ import (
 	// "syscall"
 	"time"
 
+	"github.com/NeilSeligmann/G15Manager/system/battery/lowbattery"
 	"github.com/NeilSeligmann/G15Manager/system/plugin"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -33,6 +34,8 @@ type Control struct {
 	runningCheck    bool
 	foundExecutable bool
 	shouldRestart   bool
+	// pausedOnBattery stops denoise from being restarted while the battery is low
+	pausedOnBattery bool
 }
 
 type AINoiseConfig struct {
@@ -87,13 +90,27 @@ func (c *Control) loop(haltCtx context.Context, cb chan<- plugin.Callback) {
 
 	for {
 		select {
-		// case evt := <-c.queue:
-		case <-c.queue:
+		case evt := <-c.queue:
 			// log.Println("aiDenoise: queue!")
 			if c.dryRun {
 				log.Println("aiDenoise: dry run, queue ignored")
 				continue
 			}
+			switch evt.Event {
+			case plugin.EvtBatteryLow:
+				alert, ok := evt.Value.(lowbattery.Alert)
+				if !ok || !alert.Has(lowbattery.StopDenoise) || !c.config.Enabled {
+					continue
+				}
+				log.Println("denoise: battery low, stopping denoise")
+				c.pausedOnBattery = true
+				if err := c.stopDenoise(); err != nil {
+					log.Printf("denoise: unable to stop denoise: %s\n", err)
+				}
+			case plugin.EvtBatteryRestored:
+				// the running check will start it again if enabled
+				c.pausedOnBattery = false
+			}
 
 		case <-haltCtx.Done():
 			c.runningCheck = false
@@ -110,7 +127,7 @@ func (c *Control) loopRunningCeck() {
 			break
 		}
 
-		if c.config.Enabled {
+		if c.config.Enabled && !c.pausedOnBattery {
 			if !c.isRunning() {
 				log.Println("aiDenoise: denoise process not found! Executing denoise...")
 				c.executeDenoise()
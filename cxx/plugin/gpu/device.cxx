@@ -193,4 +193,85 @@ GTFO:
     }
 
     return ret;
-}
\ No newline at end of file
+}
+
+/*
+    Return code:
+    0: Unrecoverable error
+    1: Enabled
+    2: Disabled
+    3: Not present
+    4: Present, but Device Manager reports a problem (stored in pProblem)
+*/
+
+int getGPUState(unsigned long *pProblem)
+{
+    int ret = 0;
+    HDEVINFO deviceInfoSet;
+    deviceInfoSet = SetupDiGetClassDevsA(&GUID_DEVINTERFACE_DISPLAY_ADAPTER, NULL, NULL, DIGCF_DEVICEINTERFACE);
+    if (INVALID_HANDLE_VALUE == deviceInfoSet)
+    {
+        std::cerr << "gpu: SetupDiGetClassDevsA error: " << GetLastError() << std::endl;
+        return 0;
+    }
+
+    SP_DEVINFO_DATA deviceInfoData;
+    ZeroMemory(&deviceInfoData, sizeof(SP_DEVINFO_DATA));
+    deviceInfoData.cbSize = sizeof(SP_DEVINFO_DATA);
+
+    unsigned long status = 0;
+    unsigned long problem = 0;
+    char mfgName[MAX_LEN] = {0};
+    int foundDevice = 0;
+
+    *pProblem = 0;
+
+    int deviceMemberIndex = 0;
+    while (SetupDiEnumDeviceInfo(deviceInfoSet, deviceMemberIndex, &deviceInfoData))
+    {
+        deviceMemberIndex++;
+        deviceInfoData.cbSize = sizeof(deviceInfoData);
+
+        if (CR_SUCCESS != CM_Get_DevNode_Status(&status, &problem, deviceInfoData.DevInst, 0))
+        {
+            std::cerr << "gpu: CM_Get_DevNode_Status error: " << GetLastError() << std::endl;
+            goto GTFO;
+        }
+
+        SetupDiGetDeviceRegistryPropertyA(deviceInfoSet, &deviceInfoData, SPDRP_MFG, 0, (PBYTE)mfgName, MAX_LEN, NULL);
+
+        if (strncmp(mfgName, nvidiaMfgName, MAX_LEN) == 0)
+        {
+            foundDevice = 1;
+            break;
+        }
+    }
+
+    if (foundDevice == 0)
+    {
+        ret = 3;
+        goto GTFO;
+    }
+
+    if (status & DN_STARTED)
+    {
+        ret = 1;
+    }
+    else if ((status & DN_HAS_PROBLEM) && problem != CM_PROB_DISABLED)
+    {
+        *pProblem = problem;
+        ret = 4;
+    }
+    else
+    {
+        ret = 2;
+    }
+
+GTFO:
+    if (!SetupDiDestroyDeviceInfoList(deviceInfoSet))
+    {
+        std::cerr << "gpu: SetupDiDestroyDeviceInfoList error: " << GetLastError() << std::endl;
+    }
+
+    return ret;
+}
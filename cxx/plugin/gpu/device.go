@@ -0,0 +1,71 @@
+package gpu
+
+// #cgo CXXFLAGS: -std=c++17
+// #cgo LDFLAGS: -lsetupapi -static-libgcc -static-libstdc++ -Wl,-Bstatic -lstdc++ -lpthread -Wl,-Bdynamic
+// #include "device.h"
+import "C"
+
+import (
+	"github.com/NeilSeligmann/G15Manager/system/dgpu"
+)
+
+type gpuGeneralError struct {
+	msg string
+}
+
+var _ error = &gpuGeneralError{}
+
+func (g gpuGeneralError) Error() string {
+	return g.msg
+}
+
+// setupDevice controls the NVIDIA GPU with SetupAPI
+type setupDevice struct{}
+
+var _ dgpu.Device = &setupDevice{}
+
+// NewDevice returns the dedicated GPU device, to be controlled by gpu.Control
+func NewDevice() dgpu.Device {
+	return &setupDevice{}
+}
+
+func (d *setupDevice) Enable() error {
+	ret := C.enableGPU()
+	switch int(ret) {
+	case 0:
+		return &gpuGeneralError{"cannot enable gpu"}
+	case 2:
+		return dgpu.ErrAlreadyEnabled
+	default:
+		return nil
+	}
+}
+
+func (d *setupDevice) Disable() error {
+	ret := C.disableGPU()
+	switch int(ret) {
+	case 0:
+		return &gpuGeneralError{"cannot disable gpu"}
+	case 2:
+		return dgpu.ErrAlreadyDisabled
+	default:
+		return nil
+	}
+}
+
+func (d *setupDevice) Status() (dgpu.Status, error) {
+	var problem C.ulong
+	ret := C.getGPUState(&problem)
+	switch int(ret) {
+	case 1:
+		return dgpu.Status{State: dgpu.Enabled}, nil
+	case 2:
+		return dgpu.Status{State: dgpu.Disabled}, nil
+	case 3:
+		return dgpu.Status{State: dgpu.NotPresent}, nil
+	case 4:
+		return dgpu.Status{State: dgpu.Error, Problem: uint32(problem)}, nil
+	default:
+		return dgpu.Status{}, &gpuGeneralError{"cannot query gpu state"}
+	}
+}
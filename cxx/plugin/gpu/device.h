@@ -5,6 +5,7 @@ extern "C"
 
     int disableGPU(void);
     int enableGPU(void);
+    int getGPUState(unsigned long *pProblem);
 
 #ifdef __cplusplus
 }
@@ -18,6 +18,7 @@ import "C"
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os/exec"
@@ -29,17 +30,21 @@ import (
 	"time"
 
 	// "github.com/NeilSeligmann/G15Manager/rpc/announcement"
+	"github.com/NeilSeligmann/G15Manager/system/battery/lowbattery"
 	"github.com/NeilSeligmann/G15Manager/system/device"
 	"github.com/NeilSeligmann/G15Manager/system/ioctl"
 	"github.com/NeilSeligmann/G15Manager/system/keyboard"
-	kb "github.com/NeilSeligmann/G15Manager/system/keyboard"
+	"github.com/NeilSeligmann/G15Manager/system/keyboard/aura"
+	"github.com/NeilSeligmann/G15Manager/system/keyboard/macro"
+	"github.com/NeilSeligmann/G15Manager/system/keyboard/protocol"
+	"github.com/NeilSeligmann/G15Manager/system/model"
 	"github.com/NeilSeligmann/G15Manager/system/persist"
 	"github.com/NeilSeligmann/G15Manager/system/plugin"
+	"github.com/NeilSeligmann/G15Manager/system/vkey"
 	"github.com/NeilSeligmann/G15Manager/util"
 	"github.com/asaskevich/govalidator"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"github.com/micmonay/keybd_event"
 
 	"github.com/karalabe/usb"
 )
@@ -48,46 +53,12 @@ const (
 	persistKey = "KeyboardControl"
 )
 
-const (
-	brightnessControlByteIndex = 4
-)
-
-const (
-	brightnessControlBufferLength     = 64
-	touchPadToggleControlBufferLength = 64
-	initBufferLength                  = 64
-)
-
 const (
 	kbControlDevice = "mi_00&col01"
 )
 
-// TODO: reverse engineer this as well
-var (
-	brightnessControlBuffer = []byte{
-		0x5a, 0xba, 0xc5, 0xc4,
-	}
-	touchPadToggleControlBuffer = []byte{
-		0x5a, 0xf4, 0x6b,
-	}
-	// initBufs will initialize the keyboard control interface
-	// for backlight control and disabling/enabling touchpad.
-	// Captured via API Monitor. A lot of HidD_ functions were called
-	// (which becomes DeviceIoControl)
-	// also referencing https://github.com/flukejones/rog-core/blob/master/kernel-patch/0001-HID-asus-add-support-for-ASUS-N-Key-keyboard-v5.8.patch
-	initBufs = [][]byte{
-		{
-			0x5a, 0x89,
-		},
-		{
-			0x5a, 0x41, 0x53, 0x55, 0x53, 0x20, 0x54, 0x65,
-			0x63, 0x68, 0x2e, 0x49, 0x6e, 0x63, 0x2e,
-		},
-		{
-			0x5a, 0x05, 0x20, 0x31, 0x00, 0x08,
-		},
-	}
-)
+// how often the time since the last input is checked against IdleTimeout
+const idleInterval = time.Second
 
 // Level defines the different level of keybroad brightness
 type Level byte
@@ -108,26 +79,77 @@ type Control struct {
 	mu                sync.RWMutex
 	deviceCtrl        *device.Control
 	currentBrightness Level
+	touchPadEnabled   bool
+	thermalProfile    string
+	fadeCancel        context.CancelFunc
+	capped            bool
+	brightnessCap     Level
+	idle              bool
+	player            *macro.Player
+	recorder          *macro.Recorder
+	stopHook          context.CancelFunc
+	emitter           *vkey.Emitter
 
 	queue   chan plugin.Notification
 	errChan chan error
+	fadeCh  chan Level
 }
 
 // Config defines the behavior of Keyboard Control. If DryRun is set to true,
 // no actual IOs will be performed. Remap defines the key remapping behavior or
 // Fn+ArrowLeft/ArrowRight (see system/keyboard) to standard key scancode.
+// Model defines which keyboard to look for and how many brightness levels it has.
+// Effects are the Aura lighting effects keyed by thermal profile name, with
+// aura.DefaultProfile used for profiles without an effect; they are only
+// applied if the Model or Aura declares an RGB keyboard, and nothing is sent
+// until an effect is configured. Fades defines how long brightness
+// transitions take. IdleTimeout fades out the backlight once there was no
+// input for that long, and 0 keeps it on. Macros are played with the ROG key ("$macro:<name>") or their hotkey.
+// Bindings maps N-Key key codes to a binding (see runBinding), an empty binding
+// disables the key. KeyBackend sends virtual keys, and defaults to keybd_event().
 type Config struct {
 	DryRun          bool
+	Model           *model.Model `json:"-"`
+	KeyBackend      vkey.Backend `json:"-"`
 	Remap           map[uint32]uint16
-	RogKey          []string `json:"rogKey"`
-	BrightnessLevel byte     `json:"brightnessLevel"`
+	RogKey          []string               `json:"rogKey"`
+	BrightnessLevel byte                   `json:"brightnessLevel"`
+	TouchPadEnabled bool                   `json:"touchPadEnabled"`
+	Aura            bool                   `json:"aura"`
+	Effects         map[string]aura.Effect `json:"effects"`
+	Fades           keyboard.Fades         `json:"fades"`
+	IdleTimeout     keyboard.IdleTimeout   `json:"idleTimeout"`
+	Macros          []macro.Macro          `json:"macros"`
+	Bindings        keyboard.Bindings      `json:"bindings"`
+}
+
+// BindingInput is the payload of the websocket message to set a key binding
+type BindingInput struct {
+	KeyCode uint32 `json:"keyCode"`
+	Binding string `json:"binding"`
+}
+
+// EffectInput is the payload of the websocket message to set a lighting effect
+type EffectInput struct {
+	Profile string      `json:"profile"`
+	Effect  aura.Effect `json:"effect"`
 }
 
 var _ plugin.Plugin = &Control{}
 
 // NewControl checks if the computer has the hid control interface, and returns a control interface if it does
 func NewControl(config Config) (*Control, error) {
-	devices, err := usb.EnumerateHid(kb.VendorID, kb.ProductID)
+	if config.Model == nil {
+		return nil, fmt.Errorf("kbCtrl: nil Model is invalid")
+	}
+	if config.KeyBackend == nil {
+		config.KeyBackend = vkey.NewKeybdBackend()
+	}
+	emitter, err := vkey.NewEmitter(config.KeyBackend)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := usb.EnumerateHid(config.Model.VendorID, config.Model.ProductID)
 	if err != nil {
 		return nil, err
 	}
@@ -150,13 +172,20 @@ func NewControl(config Config) (*Control, error) {
 		return nil, err
 	}
 
-	return &Control{
+	c := &Control{
 		Config:            config,
 		deviceCtrl:        ctrl,
 		currentBrightness: OFF,
+		touchPadEnabled:   true,
+		recorder:          macro.NewRecorder(),
+		emitter:           emitter,
 		queue:             make(chan plugin.Notification),
 		errChan:           make(chan error),
-	}, nil
+		fadeCh:            make(chan Level, 1),
+	}
+	c.player = macro.NewPlayer(c)
+
+	return c, nil
 }
 
 // Initialize will send initialization buffer to the keyboard control device.
@@ -167,17 +196,21 @@ func (c *Control) Initialize() error {
 	defer c.mu.Unlock()
 
 	log.Println("kbCtrl: initializaing hid interface")
-	for _, buf := range initBufs {
-		initBuf := make([]byte, initBufferLength)
-		copy(initBuf, buf)
-		_, err := c.deviceCtrl.Write(initBuf)
-		if err != nil {
+	for _, msg := range protocol.InitSequence() {
+		if err := c.write(msg); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// write encodes and sends the message to the keyboard control interface.
+// Caller must hold c.mu.
+func (c *Control) write(msg protocol.Message) error {
+	_, err := c.deviceCtrl.Write(msg.Encode())
+	return err
+}
+
 func (c *Control) loop(haltCtx context.Context, cb chan<- plugin.Callback) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -186,8 +219,30 @@ func (c *Control) loop(haltCtx context.Context, cb chan<- plugin.Callback) {
 		}
 	}()
 
+	idleTicker := time.NewTicker(idleInterval)
+	defer idleTicker.Stop()
+
+	// the Fn keys are read from the HID device, so they may not count as input
+	var lastFnKey time.Time
+
 	for {
 		select {
+		case <-idleTicker.C:
+			if err := c.checkIdle(time.Since(lastFnKey)); err != nil {
+				log.Printf("kbCtrl: unable to check for idle: %s\n", err)
+			}
+		case level := <-c.fadeCh:
+			cb <- plugin.Callback{
+				Event: plugin.CbNotifyToast,
+				Value: util.Notification{
+					Message:   fmt.Sprintf("Keyboard Brightness: %s", level),
+					Delay:     time.Millisecond * 500,
+					Immediate: true,
+				},
+			}
+			cb <- plugin.Callback{
+				Event: plugin.CbPersistConfig,
+			}
 		case t := <-c.queue:
 			switch t.Event {
 			case plugin.EvtKeyboardFn:
@@ -195,63 +250,50 @@ func (c *Control) loop(haltCtx context.Context, cb chan<- plugin.Callback) {
 				if !ok {
 					continue
 				}
+				lastFnKey = time.Now()
+				if err := c.checkIdle(0); err != nil {
+					log.Printf("kbCtrl: unable to check for idle: %s\n", err)
+				}
+				if m, ok := c.findMacroByHotkey(keycode); ok {
+					c.playMacro(haltCtx, m)
+					continue
+				}
+				if binding, ok := c.binding(keycode); ok {
+					// an empty binding disables the key
+					if binding != "" {
+						c.runBindingAndReport(haltCtx, cb, binding)
+					}
+					continue
+				}
 				switch keycode {
 				case keyboard.KeyTpadToggle:
 					if err := c.ToggleTouchPad(); err != nil {
 						c.errChan <- err
 					} else {
+						message := "Touchpad Disabled"
+						if c.TouchPadEnabled() {
+							message = "Touchpad Enabled"
+						}
 						cb <- plugin.Callback{
 							Event: plugin.CbNotifyToast,
 							Value: util.Notification{
-								Message: "Toggle Disable/Enable Touchpad",
+								Message: message,
 								Delay:   time.Second,
 							},
 						}
+						cb <- plugin.Callback{
+							Event: plugin.CbPersistConfig,
+						}
 					}
+				// the final level is reported from fadeCh once the fade completes
 				case keyboard.KeyFnDown:
 					if err := c.BrightnessDown(); err != nil {
 						c.errChan <- err
-					} else {
-						cb <- plugin.Callback{
-							Event: plugin.CbNotifyToast,
-							Value: util.Notification{
-								Message:   fmt.Sprintf("Keyboard Brightness Down: %s", c.CurrentBrightness()),
-								Delay:     time.Millisecond * 500,
-								Immediate: true,
-							},
-						}
-						cb <- plugin.Callback{
-							Event: plugin.CbPersistConfig,
-						}
 					}
 				case keyboard.KeyFnUp:
 					if err := c.BrightnessUp(); err != nil {
 						c.errChan <- err
-					} else {
-						cb <- plugin.Callback{
-							Event: plugin.CbNotifyToast,
-							Value: util.Notification{
-								Message:   fmt.Sprintf("Keyboard Brightness Up: %s", c.CurrentBrightness()),
-								Delay:     time.Millisecond * 500,
-								Immediate: true,
-							},
-						}
-						cb <- plugin.Callback{
-							Event: plugin.CbPersistConfig,
-						}
 					}
-				case keyboard.KeyFnF4:
-					kb, err := keybd_event.NewKeyBonding()
-					if err != nil {
-						panic(err)
-					}
-
-					kb.SetKeys(keybd_event.VK_MEDIA_PLAY_PAUSE)
-					err = kb.Launching()
-					if err != nil {
-						panic(err)
-					}
-
 					// case keyboard.KeyFnLeft, keyboard.KeyFnRight:
 					// c.EmulateKeyPress(4274)
 					// if remap, ok := c.Config.Remap[keycode]; ok {
@@ -260,10 +302,43 @@ func (c *Control) loop(haltCtx context.Context, cb chan<- plugin.Callback) {
 				}
 			case plugin.EvtACPIResume:
 				log.Println("kbCtrl: reinitialize kbCtrl")
-				c.errChan <- c.Initialize()
+				if err := c.Initialize(); err != nil {
+					c.errChan <- err
+					continue
+				}
+				if err := c.reapplyTouchPad(); err != nil {
+					c.errChan <- err
+					continue
+				}
+				if err := c.reapplyEffect(); err != nil {
+					c.errChan <- err
+					continue
+				}
+				c.errChan <- c.wake()
+			case plugin.EvtBatteryLow:
+				alert, ok := t.Value.(lowbattery.Alert)
+				if !ok {
+					continue
+				}
+				if alert.Has(lowbattery.KeyboardOff) {
+					c.errChan <- c.LimitBrightness(OFF)
+				} else if alert.Has(lowbattery.DimKeyboard) {
+					c.errChan <- c.LimitBrightness(LOW)
+				}
+			case plugin.EvtBatteryRestored:
+				c.errChan <- c.RestoreBrightness()
+			case plugin.EvtThermalProfileChanged:
+				profile, ok := t.Value.(string)
+				if !ok {
+					continue
+				}
+				if err := c.setThermalProfile(profile); err != nil {
+					c.errChan <- err
+				}
 			case plugin.EvtACPISuspend:
+				c.player.Stop()
 				log.Println("kbCtrl: turning off keyboard backlight")
-				c.errChan <- c.SetBrightness(OFF)
+				c.errChan <- c.TurnOff()
 
 			case plugin.EvtSentinelUtilityKey:
 				counter, ok := t.Value.(int64)
@@ -271,27 +346,15 @@ func (c *Control) loop(haltCtx context.Context, cb chan<- plugin.Callback) {
 					continue
 				}
 				if int(counter) <= len(c.Config.RogKey) {
-					var err error
 					cmd := c.Config.RogKey[counter-1]
 					log.Printf("[controller] Running: %s\n", cmd)
 
-					if cmd == "$webclient" {
-						cmd = "http://127.0.0.1:34453"
-					}
-
-					if govalidator.IsURL(cmd) {
-						err = exec.Command("rundll32", "url.dll,FileProtocolHandler", cmd).Start()
-					} else {
-						err = run("cmd.exe", "/C", cmd)
-					}
-
-					if err != nil {
-						log.Println("Failed to run command: \"" + cmd + "\"")
-						log.Println(err)
-					}
+					c.runBindingAndReport(haltCtx, cb, cmd)
 				}
 			}
 		case <-haltCtx.Done():
+			c.player.Stop()
+			c.StopRecording("")
 			log.Println("kbCtrl: exiting Plugin run loop")
 			return
 		}
@@ -312,7 +375,8 @@ func (c *Control) Notify(t plugin.Notification) {
 	c.queue <- t
 }
 
-// CurrentBrightness returns current brightness Level
+// CurrentBrightness returns current brightness Level. If a fade is in
+// progress, this is the Level being faded to.
 func (c *Control) CurrentBrightness() Level {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -320,58 +384,289 @@ func (c *Control) CurrentBrightness() Level {
 	return Level(c.Config.BrightnessLevel)
 }
 
-// SetBrightness change the keyboard backlight directly
+// SetBrightness change the keyboard backlight directly, cancelling any fade in progress
 func (c *Control) SetBrightness(v Level) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
+	c.capped = false
+	c.Config.BrightnessLevel = byte(v)
+	_, err := c.fade(v, 0, false)
+
+	return err
+}
+
+// FadeBrightness changes the keyboard backlight one level at a time over d.
+// A fade already in progress is cancelled, and the new fade starts from the
+// level it reached. The final level is reported (toast and persist) once.
+func (c *Control) FadeBrightness(v Level, d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.capped = false
+	c.Config.BrightnessLevel = byte(v)
+	_, err := c.fade(v, d, true)
+
+	return err
+}
 
-	inputBuf := make([]byte, brightnessControlBufferLength)
-	copy(inputBuf, brightnessControlBuffer)
-	inputBuf[brightnessControlByteIndex] = byte(v)
+// TurnOff fades out the keyboard backlight without changing the configured
+// brightness, and waits for the fade to complete
+func (c *Control) TurnOff() error {
+	c.mu.Lock()
+	done, err := c.fade(OFF, c.Config.Fades.Off, false)
+	c.mu.Unlock()
 
-	_, err := c.deviceCtrl.Write(inputBuf)
 	if err != nil {
 		return err
 	}
+	<-done
 
-	c.Config.BrightnessLevel = byte(v)
+	return nil
+}
+
+// LimitBrightness dims the keyboard backlight to at most v (e.g. on low
+// battery) without changing the configured brightness, until RestoreBrightness
+// is called or the brightness is changed.
+func (c *Control) LimitBrightness(v Level) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.capped || v < c.brightnessCap {
+		c.brightnessCap = v
+	}
+	c.capped = true
+	_, err := c.fade(c.cappedLevel(), c.Config.Fades.Off, false)
+
+	return err
+}
+
+// RestoreBrightness fades the keyboard backlight back to the configured
+// brightness after LimitBrightness
+func (c *Control) RestoreBrightness() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.capped {
+		return nil
+	}
+	c.capped = false
+	_, err := c.fade(Level(c.Config.BrightnessLevel), c.Config.Fades.Wake, false)
+
+	return err
+}
+
+// cappedLevel returns the configured brightness, limited by LimitBrightness,
+// or OFF while idle. Caller must hold c.mu.
+func (c *Control) cappedLevel() Level {
+	if c.idle {
+		return OFF
+	}
+	level := Level(c.Config.BrightnessLevel)
+	if c.capped && c.brightnessCap < level {
+		return c.brightnessCap
+	}
+	return level
+}
+
+// checkIdle fades out the keyboard backlight once there was no input for
+// IdleTimeout, and fades it back in on the next input. sinceFnKey is the time
+// since the last Fn key, which is not seen by IdleTime.
+func (c *Control) checkIdle(sinceFnKey time.Duration) error {
+	c.mu.RLock()
+	timeout, idle := time.Duration(c.Config.IdleTimeout), c.idle
+	c.mu.RUnlock()
+
+	if timeout <= 0 && !idle {
+		return nil
+	}
+
+	since, err := keyboard.IdleTime()
+	if err != nil {
+		return err
+	}
+	if sinceFnKey < since {
+		since = sinceFnKey
+	}
+	active := timeout <= 0 || since < timeout
+	if active != idle {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := c.Config.Fades.Off
+	if active {
+		d = c.Config.Fades.Wake
+	}
+	c.idle = !active
+	_, err = c.fade(c.cappedLevel(), d, false)
+
+	return err
+}
+
+// wake fades in the keyboard backlight to the configured brightness. This
+// should be called after the keyboard control interface is reinitialized, which
+// leaves the backlight off.
+func (c *Control) wake() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stopFade()
+	c.currentBrightness = OFF
+	_, err := c.fade(c.cappedLevel(), c.Config.Fades.Wake, false)
+
+	return err
+}
+
+// fade moves the backlight towards target over d, replacing any fade in
+// progress. The returned channel is closed once the fade ends. If report is
+// set, the final level is sent to fadeCh when (and only if) target is reached.
+// Caller must hold c.mu.
+func (c *Control) fade(target Level, d time.Duration, report bool) (<-chan struct{}, error) {
+	c.stopFade()
+
+	done := make(chan struct{})
+
+	steps := int(target) - int(c.currentBrightness)
+	if steps < 0 {
+		steps = -steps
+	}
+	if d <= 0 || steps == 0 {
+		defer close(done)
+		if err := c.setLevel(target); err != nil {
+			return done, err
+		}
+		if report {
+			c.reportLevel(target)
+		}
+		return done, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.fadeCancel = cancel
+
+	go c.runFade(ctx, cancel, target, d/time.Duration(steps), report, done)
+
+	return done, nil
+}
+
+func (c *Control) runFade(ctx context.Context, cancel context.CancelFunc, target Level, interval time.Duration, report bool, done chan<- struct{}) {
+	defer close(done)
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		// retargeted while we were waiting for the lock
+		if ctx.Err() != nil {
+			c.mu.Unlock()
+			return
+		}
+
+		next := c.currentBrightness + 1
+		if target < c.currentBrightness {
+			next = c.currentBrightness - 1
+		}
+		err := c.setLevel(next)
+		finished := err != nil || next == target
+		if finished {
+			c.fadeCancel = nil
+		}
+		if err == nil && finished && report {
+			c.reportLevel(target)
+		}
+		c.mu.Unlock()
+
+		if err != nil {
+			log.Printf("kbCtrl: unable to fade keyboard brightness: %s\n", err)
+		}
+		if finished {
+			return
+		}
+	}
+}
+
+// stopFade cancels the fade in progress, if any. The backlight stays at the
+// level reached so far. Caller must hold c.mu.
+func (c *Control) stopFade() {
+	if c.fadeCancel != nil {
+		c.fadeCancel()
+		c.fadeCancel = nil
+	}
+}
+
+// setLevel writes the brightness level to the keyboard. Caller must hold c.mu.
+func (c *Control) setLevel(v Level) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := c.write(protocol.SetBrightness{Level: byte(v)}); err != nil {
+		return err
+	}
+	c.currentBrightness = v
 
 	return nil
 }
 
-// BrightnessUp increases the keyboard backlight by one level
+// reportLevel queues the level to be reported from the run loop, replacing a
+// level that was not reported yet
+func (c *Control) reportLevel(v Level) {
+	for {
+		select {
+		case c.fadeCh <- v:
+			return
+		default:
+		}
+		select {
+		case <-c.fadeCh:
+		default:
+		}
+	}
+}
+
+// MaxBrightness returns the highest brightness Level supported by the model
+func (c *Control) MaxBrightness() Level {
+	return Level(c.Config.Model.BrightnessLevels - 1)
+}
+
+// BrightnessUp fades the keyboard backlight up by one level. The level is
+// relative to the target of a fade in progress, so repeated presses add up.
 func (c *Control) BrightnessUp() error {
-	var targetLevel Level
-	switch Level(c.Config.BrightnessLevel) {
-	case OFF:
-		targetLevel = LOW
-	case LOW:
-		targetLevel = MEDIUM
-	case MEDIUM:
-		targetLevel = HIGH
-	default:
-		return nil
+	nextLevel := c.CurrentBrightness()
+	if nextLevel < c.MaxBrightness() {
+		nextLevel++
 	}
-	return c.SetBrightness(targetLevel)
+	return c.FadeBrightness(nextLevel, c.Config.Fades.Keys)
 }
 
-// BrightnessDown decreases the keyboard backlight by one level
+// BrightnessDown fades the keyboard backlight down by one level. The level is
+// relative to the target of a fade in progress, so repeated presses add up.
 func (c *Control) BrightnessDown() error {
-	var targetLevel Level
-	switch Level(c.Config.BrightnessLevel) {
-	case HIGH:
-		targetLevel = MEDIUM
-	case MEDIUM:
-		targetLevel = LOW
-	case LOW:
-		targetLevel = OFF
-	default:
-		return nil
+	nextLevel := c.CurrentBrightness()
+	if nextLevel > c.MaxBrightness() {
+		nextLevel = c.MaxBrightness()
+	} else if nextLevel > OFF {
+		nextLevel--
 	}
-	return c.SetBrightness(targetLevel)
+	return c.FadeBrightness(nextLevel, c.Config.Fades.Keys)
+}
+
+// TouchPadEnabled returns the tracked touchpad state
+func (c *Control) TouchPadEnabled() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.Config.TouchPadEnabled
 }
 
 // ToggleTouchPad will toggle enabling/disabling the touchpad
@@ -379,22 +674,413 @@ func (c *Control) ToggleTouchPad() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	return c.setTouchPad(!c.Config.TouchPadEnabled)
+}
+
+// EnableTouchPad will enable the touchpad if it is currently disabled
+func (c *Control) EnableTouchPad() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.setTouchPad(true)
+}
+
+// DisableTouchPad will disable the touchpad if it is currently enabled
+func (c *Control) DisableTouchPad() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.setTouchPad(false)
+}
+
+// reapplyTouchPad should be called after the keyboard control interface is
+// reinitialized (e.g. after ACPI resume). The firmware comes back with the
+// touchpad enabled, so the persisted state has to be written again.
+func (c *Control) reapplyTouchPad() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.touchPadEnabled = true
+	return c.setTouchPad(c.Config.TouchPadEnabled)
+}
+
+// setTouchPad records the desired state and sends the toggle command if the
+// hardware is not already in that state. The hardware only understands "toggle",
+// and we have no way of reading the state back, so we have to track it ourselves.
+// Caller must hold c.mu.
+func (c *Control) setTouchPad(enabled bool) error {
+	if c.touchPadEnabled != enabled {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		err := c.write(protocol.ToggleTouchpad{})
+		if err != nil {
+			return err
+		}
+
+		c.touchPadEnabled = enabled
+		log.Printf("kbCtrl: touchpad enabled: %t\n", enabled)
+	}
+
+	// only persisted once the touchpad is actually set
+	c.Config.TouchPadEnabled = enabled
+
+	return nil
+}
+
+// Effect returns the lighting effect of the current thermal profile
+func (c *Control) Effect() aura.Effect {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return aura.ForProfile(c.Config.Effects, c.thermalProfile)
+}
+
+// SetEffect sets the lighting effect of a thermal profile (or aura.DefaultProfile),
+// and shows it if the profile is in use
+func (c *Control) SetEffect(profile string, effect aura.Effect) error {
+	if err := effect.Validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Config.Effects == nil {
+		c.Config.Effects = make(map[string]aura.Effect)
+	}
+	c.Config.Effects[profile] = effect
+
+	return c.applyEffect()
+}
+
+// RemoveEffect removes the lighting effect of a thermal profile, so the profile
+// will use the aura.DefaultProfile effect
+func (c *Control) RemoveEffect(profile string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.Config.Effects, profile)
+
+	return c.applyEffect()
+}
+
+func (c *Control) setThermalProfile(profile string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.thermalProfile = profile
+
+	return c.applyEffect()
+}
+
+func (c *Control) reapplyEffect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.applyEffect()
+}
+
+// SetAura declares that the keyboard has RGB lighting, for SKUs of a model
+// without Aura
+func (c *Control) SetAura(enabled bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Config.Aura = enabled
+
+	return c.applyEffect()
+}
+
+// SetIdleTimeout changes how long there must be no input before the backlight
+// fades out. 0 keeps it on.
+func (c *Control) SetIdleTimeout(d time.Duration) error {
+	if d < 0 {
+		return errors.New("kbCtrl: idle timeout cannot be negative")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Config.IdleTimeout = keyboard.IdleTimeout(d)
+
+	return nil
+}
+
+// hasAura returns true if the keyboard has RGB lighting.
+// Caller must hold c.mu.
+func (c *Control) hasAura() bool {
+	return c.Config.Model.Aura || c.Config.Aura
+}
+
+// applyEffect sends the lighting effect of the current thermal profile to the
+// keyboard. It does nothing if the keyboard does not support Aura, or if no
+// effect was configured.
+// Caller must hold c.mu.
+func (c *Control) applyEffect() error {
+	if !c.hasAura() || len(c.Config.Effects) == 0 {
+		return nil
+	}
+
+	effect := aura.ForProfile(c.Config.Effects, c.thermalProfile)
+	msgs, err := effect.Messages()
+	if err != nil {
+		return err
+	}
+
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
-	inputBuf := make([]byte, touchPadToggleControlBufferLength)
-	copy(inputBuf, touchPadToggleControlBuffer)
+	for _, msg := range msgs {
+		if err := c.write(msg); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("kbCtrl: lighting effect set to %s\n", effect)
+
+	return nil
+}
+
+// Macros returns a copy of the configured macros
+func (c *Control) Macros() []macro.Macro {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]macro.Macro(nil), c.Config.Macros...)
+}
+
+func (c *Control) findMacro(name string) (macro.Macro, bool) {
+	for _, m := range c.Macros() {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return macro.Macro{}, false
+}
+
+func (c *Control) findMacroByHotkey(keyCode uint32) (macro.Macro, bool) {
+	for _, m := range c.Macros() {
+		if m.Hotkey != 0 && m.Hotkey == keyCode {
+			return m, true
+		}
+	}
+	return macro.Macro{}, false
+}
+
+// SetMacro adds the macro, or replaces the macro with the same name
+func (c *Control) SetMacro(m macro.Macro) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	// only the keys of the model are delivered to findMacroByHotkey
+	if m.Hotkey != 0 && !c.Config.Model.HasKey(m.Hotkey) {
+		return fmt.Errorf("kbCtrl: key %d cannot be used as a hotkey on this model", m.Hotkey)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.Config.Macros {
+		if c.Config.Macros[i].Name == m.Name {
+			c.Config.Macros[i] = m
+			return nil
+		}
+	}
+	c.Config.Macros = append(c.Config.Macros, m)
+
+	return nil
+}
+
+// RemoveMacro removes the macro with the given name
+func (c *Control) RemoveMacro(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.Config.Macros {
+		if c.Config.Macros[i].Name == name {
+			c.Config.Macros = append(c.Config.Macros[:i], c.Config.Macros[i+1:]...)
+			return
+		}
+	}
+}
+
+// PlayMacro plays the macro with the given name in the background. Use
+// StopMacro to cancel it.
+func (c *Control) PlayMacro(name string) error {
+	m, ok := c.findMacro(name)
+	if !ok {
+		return fmt.Errorf("kbCtrl: macro %s not found", name)
+	}
+	c.playMacro(context.Background(), m)
+
+	return nil
+}
 
-	_, err := c.deviceCtrl.Write(inputBuf)
+func (c *Control) playMacro(ctx context.Context, m macro.Macro) {
+	go func() {
+		log.Printf("kbCtrl: playing macro %s\n", m.Name)
+		if err := c.player.Play(ctx, m); err != nil {
+			log.Printf("kbCtrl: macro %s: %s\n", m.Name, err)
+		}
+	}()
+}
+
+// StopMacro cancels the macro currently playing
+func (c *Control) StopMacro() {
+	c.player.Stop()
+}
+
+// StartRecording installs a keyboard hook and records key presses until
+// StopRecording is called
+func (c *Control) StartRecording() error {
+	if err := c.recorder.Start(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan macro.KeyEvent, 64)
+	if err := macro.Hook(ctx, events); err != nil {
+		cancel()
+		c.recorder.Stop("")
+		return err
+	}
+
+	c.mu.Lock()
+	c.stopHook = cancel
+	c.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case e := <-events:
+				c.recorder.Record(e)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	log.Println("kbCtrl: recording macro")
+
+	return nil
+}
+
+// StopRecording removes the keyboard hook and saves the recorded key presses
+// as a macro with the given name. An empty name discards the recording.
+func (c *Control) StopRecording(name string) error {
+	c.mu.Lock()
+	if c.stopHook != nil {
+		c.stopHook()
+		c.stopHook = nil
+	}
+	c.mu.Unlock()
+
+	m, err := c.recorder.Stop(name)
+	if name == "" || errors.Is(err, macro.ErrNotRecording) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
-	// I don't think we have a way of checking if the touchpad is disabled/enabled
+	log.Printf("kbCtrl: recorded macro %s with %d steps\n", m.Name, len(m.Steps))
+
+	return c.SetMacro(m)
+}
+
+// binding returns the binding of the N-Key key code, if the key is bound
+func (c *Control) binding(keyCode uint32) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.Config.Bindings.Lookup(keyCode)
+}
+
+// SetBinding sets the binding of the N-Key key code. An empty binding disables the key.
+func (c *Control) SetBinding(keyCode uint32, binding string) error {
+	if combo, ok := vkey.Binding(binding); ok && !isBrightnessKey(combo) {
+		if _, err := vkey.Parse(combo); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Config.Bindings == nil {
+		c.Config.Bindings = make(keyboard.Bindings)
+	}
+	c.Config.Bindings[keyCode] = binding
 
 	return nil
 }
 
+// runBinding runs a ROG key command or a key binding, which can be:
+// a macro ("$macro:<name>"), a key combination ("$key:<combo>", see vkey.Parse),
+// the web client ("$webclient"), an URL, or a command line.
+func (c *Control) runBinding(ctx context.Context, cb chan<- plugin.Callback, cmd string) error {
+	if name, ok := macro.Binding(cmd); ok {
+		m, ok := c.findMacro(name)
+		if !ok {
+			return fmt.Errorf("kbCtrl: macro %s not found", name)
+		}
+		c.playMacro(ctx, m)
+		return nil
+	}
+
+	if combo, ok := vkey.Binding(cmd); ok {
+		// Windows has no virtual key for brightness, so the panel plugin
+		// changes the display brightness, and the keyboard backlight is set here
+		switch strings.ToLower(combo) {
+		case "brightnessup":
+			cb <- plugin.Callback{Event: plugin.CbStepLCDBrightness, Value: 1}
+			return nil
+		case "brightnessdown":
+			cb <- plugin.Callback{Event: plugin.CbStepLCDBrightness, Value: -1}
+			return nil
+		case "kbdbrightnessup":
+			return c.BrightnessUp()
+		case "kbdbrightnessdown":
+			return c.BrightnessDown()
+		}
+		return c.emitter.Emit(combo)
+	}
+
+	if cmd == "$webclient" {
+		cmd = "http://127.0.0.1:34453"
+	}
+
+	if govalidator.IsURL(cmd) {
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", cmd).Start()
+	}
+	return run("cmd.exe", "/C", cmd)
+}
+
+// runBindingAndReport runs the binding, and notifies the user if it fails
+func (c *Control) runBindingAndReport(ctx context.Context, cb chan<- plugin.Callback, cmd string) {
+	err := c.runBinding(ctx, cb, cmd)
+	if err == nil {
+		return
+	}
+
+	log.Printf("kbCtrl: failed to run \"%s\": %s\n", cmd, err)
+	cb <- plugin.Callback{
+		Event: plugin.CbNotifyToast,
+		Value: util.Notification{
+			Message: fmt.Sprintf("Failed to run %s", cmd),
+		},
+	}
+}
+
+func isBrightnessKey(combo string) bool {
+	switch strings.ToLower(combo) {
+	case "brightnessup", "brightnessdown", "kbdbrightnessup", "kbdbrightnessdown":
+		return true
+	}
+	return false
+}
+
 // EmulateKeyPress will emulate a keypress via SendInput() scancode.
 // Note: some applications using DirectInput may not register this.
 func (c *Control) EmulateKeyPress(keyCode uint16) error {
@@ -408,6 +1094,26 @@ func (c *Control) EmulateKeyPress(keyCode uint16) error {
 	return nil
 }
 
+// EmulateKey will press the key via SendInput() scancode, or release it if up
+// is true. extended is set for the keys with an 0xe0 prefix (e.g. the arrows).
+func (c *Control) EmulateKey(keyCode uint16, extended bool, up bool) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	var ext, release C.int
+	if extended {
+		ext = 1
+	}
+	if up {
+		release = 1
+	}
+	if C.SendKeyEvent(C.ushort(keyCode), ext, release) != 0 {
+		return fmt.Errorf("kbCtrl: cannot emulate key event")
+	}
+
+	return nil
+}
+
 var _ persist.Registry = &Control{}
 
 // Name satisfies persist.Registry
@@ -443,7 +1149,18 @@ func (c *Control) Load(v []byte) error {
 // Apply satisfies persist.Registry
 func (c *Control) Apply() error {
 	// mutex already in setBrightness
-	return c.SetBrightness(Level(c.Config.BrightnessLevel))
+	if err := c.SetBrightness(Level(c.Config.BrightnessLevel)); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.setTouchPad(c.Config.TouchPadEnabled); err != nil {
+		return err
+	}
+
+	return c.applyEffect()
 }
 
 // Close satisfied persist.Registry
@@ -451,6 +1168,8 @@ func (c *Control) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.stopFade()
+
 	return c.deviceCtrl.Close()
 }
 
@@ -461,9 +1180,36 @@ func run(commands ...string) error {
 }
 
 func (c *Control) GetWSInfo() gin.H {
+	playing, _ := c.player.Playing()
+	recording := c.recorder.Recording()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// the maps are copied as they are encoded after the lock is released
+	effects := make(map[string]aura.Effect, len(c.Config.Effects))
+	for profile, effect := range c.Config.Effects {
+		effects[profile] = effect
+	}
+	bindings := make(keyboard.Bindings, len(c.Config.Bindings))
+	for keyCode, binding := range c.Config.Bindings {
+		bindings[keyCode] = binding
+	}
+
 	return gin.H{
 		"currentBrightness": Level(c.Config.BrightnessLevel),
-		"rogKey":            c.Config.RogKey,
+		"rogKey":            append([]string(nil), c.Config.RogKey...),
+		"touchPadEnabled":   c.Config.TouchPadEnabled,
+		"aura": gin.H{
+			"supported": c.hasAura(),
+			"profile":   c.thermalProfile,
+			"effects":   effects,
+		},
+		"idleTimeout": c.Config.IdleTimeout,
+		"macros":      append([]macro.Macro(nil), c.Config.Macros...),
+		"bindings":    bindings,
+		"recording":   recording,
+		"playing":     playing,
 	}
 }
 
@@ -477,9 +1223,102 @@ func (c *Control) HandleWSMessage(ws *websocket.Conn, action int, value string)
 	// Rog Key
 	case 1:
 		arr := strings.Split(value, ",")
+		c.mu.Lock()
 		c.Config.RogKey = arr
+		c.mu.Unlock()
 	// Toggle Touchpad
 	case 2:
-		c.ToggleTouchPad()
+		if err := c.ToggleTouchPad(); err != nil {
+			log.Printf("kbCtrl: unable to toggle touchpad: %s\n", err)
+		}
+	// Set Touchpad, value "1" enables it and "0" disables it
+	case 3:
+		var err error
+		switch value {
+		case "1":
+			err = c.EnableTouchPad()
+		case "0":
+			err = c.DisableTouchPad()
+		default:
+			log.Printf("kbCtrl: invalid touchpad value: %s\n", value)
+			return
+		}
+		if err != nil {
+			log.Printf("kbCtrl: unable to set touchpad: %s\n", err)
+		}
+	// Set Lighting Effect
+	case 4:
+		input := EffectInput{}
+		if err := json.Unmarshal([]byte(value), &input); err != nil {
+			log.Printf("kbCtrl: invalid lighting effect: %s\n", err)
+			return
+		}
+		if err := c.SetEffect(input.Profile, input.Effect); err != nil {
+			log.Printf("kbCtrl: unable to set lighting effect: %s\n", err)
+		}
+	// Remove Lighting Effect
+	case 5:
+		c.RemoveEffect(value)
+	// Add/Modify Macro
+	case 6:
+		m := macro.Macro{}
+		if err := json.Unmarshal([]byte(value), &m); err != nil {
+			log.Printf("kbCtrl: invalid macro: %s\n", err)
+			return
+		}
+		if err := c.SetMacro(m); err != nil {
+			log.Printf("kbCtrl: unable to set macro: %s\n", err)
+		}
+	// Remove Macro
+	case 7:
+		c.RemoveMacro(value)
+	// Start Recording Macro
+	case 8:
+		if err := c.StartRecording(); err != nil {
+			log.Printf("kbCtrl: unable to record macro: %s\n", err)
+		}
+	// Stop Recording Macro, value is the name of the new macro
+	case 9:
+		if err := c.StopRecording(value); err != nil {
+			log.Printf("kbCtrl: unable to save macro: %s\n", err)
+		}
+	// Play Macro
+	case 10:
+		if err := c.PlayMacro(value); err != nil {
+			log.Println(err)
+		}
+	// Stop Macro
+	case 11:
+		c.StopMacro()
+	// Set Key Binding
+	case 12:
+		input := BindingInput{}
+		if err := json.Unmarshal([]byte(value), &input); err != nil {
+			log.Printf("kbCtrl: invalid key binding: %s\n", err)
+			return
+		}
+		if err := c.SetBinding(input.KeyCode, input.Binding); err != nil {
+			log.Printf("kbCtrl: unable to set key binding: %s\n", err)
+		}
+	// Enable/Disable Aura
+	case 13:
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Printf("kbCtrl: invalid aura value: %s\n", value)
+			return
+		}
+		if err := c.SetAura(enabled); err != nil {
+			log.Printf("kbCtrl: unable to set aura: %s\n", err)
+		}
+	// Set Idle Timeout, value in seconds, 0 keeps the backlight on
+	case 14:
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			log.Printf("kbCtrl: invalid idle timeout: %s\n", value)
+			return
+		}
+		if err := c.SetIdleTimeout(time.Duration(seconds) * time.Second); err != nil {
+			log.Printf("kbCtrl: unable to set idle timeout: %s\n", err)
+		}
 	}
 }
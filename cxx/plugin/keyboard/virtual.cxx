@@ -10,6 +10,16 @@
 // https://docs.microsoft.com/en-us/windows/win32/api/winuser/ns-winuser-keybdinput
 
 int SendKeyPress(unsigned short key_code)
+{
+    if (SendKeyEvent(key_code, 0, 0) != 0)
+    {
+        return 1;
+    }
+
+    return SendKeyEvent(key_code, 0, 1);
+}
+
+int SendKeyEvent(unsigned short key_code, int extended, int up)
 {
     INPUT input;
     KEYBDINPUT kbInput;
@@ -20,21 +30,17 @@ int SendKeyPress(unsigned short key_code)
 
     kbInput.wScan = key_code;
     kbInput.dwFlags = KEYEVENTF_SCANCODE;
-    input.ki = kbInput;
-
-    int ret;
-
-    ret = SendInput(1, &input, sizeof(INPUT));
-    if (ret == 0)
+    if (extended)
     {
-        return 1;
+        kbInput.dwFlags |= KEYEVENTF_EXTENDEDKEY;
+    }
+    if (up)
+    {
+        kbInput.dwFlags |= KEYEVENTF_KEYUP;
     }
-
-    kbInput.dwFlags = KEYEVENTF_SCANCODE | KEYEVENTF_KEYUP;
     input.ki = kbInput;
 
-    ret = SendInput(1, &input, sizeof(INPUT));
-    if (ret == 0)
+    if (SendInput(1, &input, sizeof(INPUT)) == 0)
     {
         return 1;
     }
@@ -4,6 +4,7 @@ extern "C"
 #endif
 
     int SendKeyPress(unsigned short key_code);
+    int SendKeyEvent(unsigned short key_code, int extended, int up);
 
 #ifdef __cplusplus
 }
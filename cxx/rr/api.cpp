@@ -58,6 +58,47 @@ int fnGetCurrentRefreshRate(void *p)
     return pDisplay->getRefreshRate() & INT_MAX;
 }
 
+int fnGetLowestRefreshRate(void *p)
+{
+    Display *pDisplay = static_cast<Display *>(p);
+    auto rates = pDisplay->getSupportedRefreshRates();
+    if (rates.empty())
+    {
+        return 0;
+    }
+    return *rates.begin() & INT_MAX;
+}
+
+int fnSetRefreshRate(void *p, int rate)
+{
+    Display *pDisplay = static_cast<Display *>(p);
+    auto rates = pDisplay->getSupportedRefreshRates();
+    if (rate <= 0 || rates.find(rate) == rates.end())
+    {
+        return 0;
+    }
+    if (pDisplay->setRefreshRate(rate))
+    {
+        return rate;
+    }
+    return 0;
+}
+
+int fnListRefreshRates(void *p, int *rates, int size)
+{
+    Display *pDisplay = static_cast<Display *>(p);
+    int n = 0;
+    for (auto rate : pDisplay->getSupportedRefreshRates())
+    {
+        if (n >= size)
+        {
+            break;
+        }
+        rates[n++] = rate & INT_MAX;
+    }
+    return n;
+}
+
 int fnCycleRefreshRate(void *p)
 {
     Display *pDisplay = static_cast<Display *>(p);
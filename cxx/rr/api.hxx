@@ -6,6 +6,9 @@ extern "C"
     void *fnGetDisplay(void);
     int fnCycleRefreshRate(void *);
     int fnGetCurrentRefreshRate(void *);
+    int fnGetLowestRefreshRate(void *);
+    int fnSetRefreshRate(void *, int);
+    int fnListRefreshRates(void *, int *, int);
     void fnReleaseDisplay(void *);
 
 #ifdef __cplusplus
@@ -24,6 +24,21 @@ extern "C"
         return fnGetCurrentRefreshRate(pDisplay);
     }
 
+    int GetLowestRefreshRate()
+    {
+        return fnGetLowestRefreshRate(pDisplay);
+    }
+
+    int SetRefreshRate(int rate)
+    {
+        return fnSetRefreshRate(pDisplay, rate);
+    }
+
+    int ListRefreshRates(int *rates, int size)
+    {
+        return fnListRefreshRates(pDisplay, rates, size);
+    }
+
     void ReleaseDisplay()
     {
         fnReleaseDisplay(pDisplay);
@@ -6,11 +6,18 @@ package rr
 import "C"
 import (
 	"fmt"
+
+	"github.com/NeilSeligmann/G15Manager/system/display"
 )
 
+// maxRefreshRates is more than any panel supports at a resolution
+const maxRefreshRates = 32
+
 type Display struct {
 }
 
+var _ display.RefreshRater = &Display{}
+
 func NewDisplayRR() (*Display, error) {
 	ret := C.GetDisplay()
 	if int(ret) != 1 {
@@ -27,6 +34,30 @@ func (d *Display) GetCurrent() int {
 	return int(C.GetCurrentRefreshRate())
 }
 
+// GetLowest returns the lowest refresh rate supported at the current resolution
+func (d *Display) GetLowest() int {
+	return int(C.GetLowestRefreshRate())
+}
+
+// SetRefreshRate changes the refresh rate, and returns it, or 0 if it is not
+// supported or cannot be set
+func (d *Display) SetRefreshRate(rate int) int {
+	return int(C.SetRefreshRate(C.int(rate)))
+}
+
+// ListRefreshRates returns the refresh rates supported at the current
+// resolution, in ascending order
+func (d *Display) ListRefreshRates() []int {
+	var rates [maxRefreshRates]C.int
+	n := int(C.ListRefreshRates(&rates[0], C.int(len(rates))))
+
+	list := make([]int, 0, n)
+	for _, rate := range rates[:n] {
+		list = append(list, int(rate))
+	}
+	return list
+}
+
 func (d *Display) Release() {
 	C.ReleaseDisplay()
 }
@@ -12,6 +12,9 @@ extern "C"
     int GetDisplay();
     int CycleRefreshRate();
     int GetCurrentRefreshRate();
+    int GetLowestRefreshRate();
+    int SetRefreshRate(int);
+    int ListRefreshRates(int *, int);
     void ReleaseDisplay();
 
 #ifdef __cplusplus
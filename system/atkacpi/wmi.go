@@ -35,6 +35,9 @@ const (
 	DstsCurrentCPUFanSpeed uint32 = 0x00110013
 	DstsCurrentGPUFanSpeed uint32 = 0x00110014
 	DstsCheckCharger       uint32 = 0x0012006c
+	DevsGPUEco             uint32 = 0x00090020
+	DevsGPUMux             uint32 = 0x00090016
+	DevsPanelOverdrive     uint32 = 0x00050019
 )
 
 // This is needed since we are calling from userspace
@@ -1,57 +1,325 @@
 package battery
 
 import (
+	"context"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/NeilSeligmann/G15Manager/system/atkacpi"
+	"github.com/NeilSeligmann/G15Manager/system/battery/health"
+	"github.com/NeilSeligmann/G15Manager/system/battery/lowbattery"
+	"github.com/NeilSeligmann/G15Manager/system/battery/schedule"
+	"github.com/NeilSeligmann/G15Manager/system/battery/telemetry"
+	"github.com/NeilSeligmann/G15Manager/system/model"
 	"github.com/NeilSeligmann/G15Manager/system/persist"
+	"github.com/NeilSeligmann/G15Manager/system/plugin"
+	"github.com/NeilSeligmann/G15Manager/util"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
 const (
-	persistKey = "BatteryChargeLimit"
+	persistKey         = "BatteryChargeLimit"
+	schedulePersistKey = "BatteryChargeSchedule"
+	lowRulesPersistKey = "BatteryLowRules"
 )
 
-// ChargeLimit allows you to limit the full charge percentage on your laptop
+const (
+	scheduleInterval = time.Minute
+)
+
+// dstsPresent is set in the DSTS result if the device is supported, and the
+// value is in the lower 16 bits
+const dstsPresent = 0x10000
+
+// ExternalChange defines what to do when another tool (e.g. Armoury Crate)
+// changes the charge limit
+type ExternalChange string
+
+// Defines the external change policies
+const (
+	// KeepExternal adopts the new limit as the configured limit
+	KeepExternal ExternalChange = "keep"
+	// ReapplyLimit writes our limit back
+	ReapplyLimit ExternalChange = "reapply"
+)
+
+// Validate returns an error if the policy is unknown
+func (e ExternalChange) Validate() error {
+	switch e {
+	case KeepExternal, ReapplyLimit:
+		return nil
+	}
+	return fmt.Errorf("unknown external change policy %q", e)
+}
+
+// ChargeLimit allows you to limit the full charge percentage on your laptop.
+// The configured limit can be raised temporarily by a charge schedule, or by a
+// one-shot top-up to 100%.
 type ChargeLimit struct {
 	wmi          atkacpi.WMI
+	telemetry    *telemetry.Service
+	model        *model.Model
 	currentLimit uint8
-	mu           sync.RWMutex
+	onExternal   ExternalChange
+	applied      bool
+	// firmwareLimit is the limit last read from or written to the firmware,
+	// so clients are served without evaluating ACPI methods
+	firmwareLimit uint8
+	mu            sync.RWMutex
+
+	planner    schedule.Planner
+	schedules  []schedule.Schedule
+	plan       schedule.Plan
+	chargeRate float64
+	override   uint8
+	topUp      bool
+	now        func() time.Time
+
+	health *health.Tracker
+
+	lowRules   []lowbattery.Rule
+	lowMonitor lowbattery.Monitor
+
+	queue   chan plugin.Notification
+	notices chan string
+	errChan chan error
 }
 
-// NewChargeLimit initializes the control interface and returns an instance of ChargeLimit
-func NewChargeLimit(wmi atkacpi.WMI) (*ChargeLimit, error) {
+var _ plugin.Plugin = &ChargeLimit{}
+
+// NewChargeLimit initializes the control interface and returns an instance of
+// ChargeLimit. The battery status is read from telemetry, and the limit bounds
+// and device ID come from m.
+func NewChargeLimit(wmi atkacpi.WMI, telemetry *telemetry.Service, m *model.Model) (*ChargeLimit, error) {
+	if telemetry == nil {
+		return nil, errors.New("nil telemetry Service is invalid")
+	}
+	if m == nil {
+		return nil, errors.New("nil Model is invalid")
+	}
+	tracker, err := health.NewTracker(telemetry)
+	if err != nil {
+		return nil, err
+	}
 	return &ChargeLimit{
 		wmi:          wmi,
+		telemetry:    telemetry,
+		model:        m,
 		currentLimit: 60,
+		onExternal:   ReapplyLimit,
+		planner:      schedule.DefaultPlanner,
+		now:          time.Now,
+		health:       tracker,
+		queue:        make(chan plugin.Notification),
+		notices:      make(chan string, 1),
+		errChan:      make(chan error),
 	}, nil
 }
 
-// Set will write to ACPI and set the battery charge limit in percentage. Note that the minimum percentage is 40
+// Set will write to ACPI and set the battery charge limit in percentage. The
+// percentage must be within the bounds of the model (usually 40 to 100).
+// If the firmware reports the limit, it is read back to verify the write; a
+// mismatch is reported to the user rather than returned, as writing again
+// would not help.
 func (c *ChargeLimit) Set(pct uint8) error {
-	if pct < 40 || pct > 100 {
-		return errors.New("charge limit percentage must be between 40 and 100, inclusive")
+	if err := c.validate(pct); err != nil {
+		return err
 	}
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	previous := c.currentLimit
+	c.currentLimit = pct
+	limit := c.effectiveLimit()
+	if err := c.write(limit); err != nil {
+		c.currentLimit = previous
+		return err
+	}
+	c.applied = true
+
+	actual, ok, err := c.readLimit()
+	if err != nil {
+		log.Printf("battery: unable to read the charge limit: %s\n", err)
+		return nil
+	}
+	if ok {
+		c.firmwareLimit = actual
+	}
+	if ok && actual != limit {
+		log.Printf("battery: charge limit was set to %d%%, but the firmware reports %d%%\n", limit, actual)
+		c.notice(fmt.Sprintf("Charge limit was set to %d%%, but the firmware reports %d%%", limit, actual))
+	}
+	return nil
+}
+
+// notice queues a message for the user, which is sent from the plugin loop.
+// The message is dropped if one is already pending.
+func (c *ChargeLimit) notice(message string) {
+	select {
+	case c.notices <- message:
+	default:
+	}
+}
+
+// validate returns an error if pct is out of the bounds of the model
+func (c *ChargeLimit) validate(pct uint8) error {
+	b := c.model.ChargeLimit
+	if pct < b.Min || pct > b.Max {
+		return fmt.Errorf("charge limit percentage must be between %d and %d, inclusive", b.Min, b.Max)
+	}
+	return nil
+}
+
+// write sets the charge limit in ACPI. Caller must hold c.mu.
+func (c *ChargeLimit) write(pct uint8) error {
 	args := make([]byte, 8)
-	binary.LittleEndian.PutUint32(args[0:], atkacpi.DevsBatteryChargeLimit)
+	binary.LittleEndian.PutUint32(args[0:], c.model.Devices.BatteryChargeLimit)
 	binary.LittleEndian.PutUint32(args[4:], uint32(pct))
 
-	_, err := c.wmi.Evaluate(atkacpi.DEVS, args)
+	if _, err := c.wmi.Evaluate(atkacpi.DEVS, args); err != nil {
+		return err
+	}
+	c.firmwareLimit = pct
+	return nil
+}
+
+// readLimit returns the charge limit set in firmware. ok is false if the
+// firmware does not report it, in which case we can only trust our own writes.
+func (c *ChargeLimit) readLimit() (pct uint8, ok bool, err error) {
+	args := make([]byte, 4)
+	binary.LittleEndian.PutUint32(args[0:], c.model.Devices.BatteryChargeLimit)
+
+	status, err := c.wmi.Evaluate(atkacpi.DSTS, args)
 	if err != nil {
+		return 0, false, err
+	}
+	if len(status) < 4 {
+		return 0, false, nil
+	}
+
+	v := binary.LittleEndian.Uint32(status[0:4])
+	if v&dstsPresent == 0 {
+		return 0, false, nil
+	}
+	v &= 0xffff
+	// some firmware report the presence bit without a usable value
+	if v == 0 || v > 100 {
+		return 0, false, nil
+	}
+	return uint8(v), true, nil
+}
+
+// FirmwareLimit returns the charge limit reported by the firmware, or the
+// limit we set if the firmware does not report it
+func (c *ChargeLimit) FirmwareLimit() (uint8, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	actual, ok, err := c.readLimit()
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return c.effectiveLimit(), nil
+	}
+	return actual, nil
+}
+
+// ExternalChange returns the policy for external changes of the charge limit
+func (c *ChargeLimit) ExternalChange() ExternalChange {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.onExternal
+}
+
+// SetExternalChange sets the policy for external changes of the charge limit
+func (c *ChargeLimit) SetExternalChange(e ExternalChange) error {
+	if err := e.Validate(); err != nil {
 		return err
 	}
-	c.currentLimit = pct
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onExternal = e
 	return nil
 }
 
+// checkExternal compares the limit in firmware with ours, and keeps or
+// re-applies ours according to the policy. While a top-up or a schedule raised
+// the limit, ours is always re-applied as the raise is temporary, and so is a
+// limit out of the bounds of the model. Until our limit is applied, the limit
+// in firmware is only the default and is ignored. It returns a message for the
+// user if the limit was changed externally.
+func (c *ChargeLimit) checkExternal() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.applied {
+		return "", nil
+	}
+
+	actual, ok, err := c.readLimit()
+	if err != nil {
+		// not worth restarting over, we will try again later
+		log.Printf("battery: unable to read the charge limit: %s\n", err)
+		return "", nil
+	}
+	if ok {
+		c.firmwareLimit = actual
+	}
+	limit := c.effectiveLimit()
+	if !ok || actual == limit {
+		return "", nil
+	}
+
+	log.Printf("battery: charge limit was changed externally from %d%% to %d%%\n", limit, actual)
+
+	if c.onExternal == KeepExternal && limit == c.currentLimit && c.validate(actual) == nil {
+		c.currentLimit = actual
+		return fmt.Sprintf("Charge limit was changed to %d%%", actual), nil
+	}
+
+	if err := c.write(limit); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Charge limit was changed to %d%%, restored to %d%%", actual, limit), nil
+}
+
+// reapply writes the limit in effect again, since the firmware may have reset
+// it while suspended
+func (c *ChargeLimit) reapply() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.applied {
+		return nil
+	}
+	return c.write(c.effectiveLimit())
+}
+
+// effectiveLimit returns the limit set in ACPI: the configured limit, unless
+// a top-up or a schedule raised it. Caller must hold c.mu.
+func (c *ChargeLimit) effectiveLimit() uint8 {
+	if c.topUp {
+		return 100
+	}
+	if c.override > c.currentLimit {
+		return c.override
+	}
+	return c.currentLimit
+}
+
+// CurrentLimit returns the configured charge limit
 func (c *ChargeLimit) CurrentLimit() uint8 {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -59,6 +327,366 @@ func (c *ChargeLimit) CurrentLimit() uint8 {
 	return c.currentLimit
 }
 
+// EffectiveLimit returns the charge limit currently in effect, which is higher
+// than the configured limit while a schedule is active
+func (c *ChargeLimit) EffectiveLimit() uint8 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.effectiveLimit()
+}
+
+// TopUp raises the charge limit to 100% once. The configured limit is restored
+// when the battery is full, the charger is unplugged, or CancelTopUp is called.
+// The top-up is not persisted, so restarting also restores the configured limit.
+func (c *ChargeLimit) TopUp() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.topUp {
+		return nil
+	}
+
+	c.topUp = true
+	if err := c.write(c.effectiveLimit()); err != nil {
+		c.topUp = false
+		return err
+	}
+
+	log.Println("battery: topping up to 100%")
+
+	return nil
+}
+
+// CancelTopUp restores the configured limit if a top-up is in progress
+func (c *ChargeLimit) CancelTopUp() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.endTopUp()
+}
+
+// TopUpActive returns true while a top-up is in progress
+func (c *ChargeLimit) TopUpActive() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.topUp
+}
+
+// endTopUp restores the limit from before the top-up. Caller must hold c.mu.
+func (c *ChargeLimit) endTopUp() error {
+	if !c.topUp {
+		return nil
+	}
+
+	c.topUp = false
+	if err := c.write(c.effectiveLimit()); err != nil {
+		c.topUp = true
+		return err
+	}
+
+	log.Printf("battery: top-up ended, charge limit restored to %d%%\n", c.effectiveLimit())
+
+	return nil
+}
+
+// checkTopUp ends the top-up once the battery is full, and returns a message
+// for the user if it did
+func (c *ChargeLimit) checkTopUp() (string, error) {
+	if !c.TopUpActive() {
+		return "", nil
+	}
+
+	status, err := c.telemetry.Status()
+	if err != nil {
+		log.Printf("battery: unable to read battery status: %s\n", err)
+		return "", nil
+	}
+	if status.State != telemetry.Full && status.Percent < 100 {
+		return "", nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.endTopUp(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Battery is full, charge limit restored to %d%%", c.effectiveLimit()), nil
+}
+
+// unpluggedTopUp ends the top-up because the charger was unplugged, and returns
+// a message for the user if there was one in progress
+func (c *ChargeLimit) unpluggedTopUp() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.topUp {
+		return "", nil
+	}
+	if err := c.endTopUp(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Charger unplugged, charge limit restored to %d%%", c.effectiveLimit()), nil
+}
+
+// Status returns the battery status (charge, rate, capacity, etc), with the
+// time to full estimated to the effective charge limit
+func (c *ChargeLimit) Status() (telemetry.Status, error) {
+	status, err := c.telemetry.Status()
+	if err != nil {
+		return status, err
+	}
+	return status.WithLimit(c.EffectiveLimit()), nil
+}
+
+// Schedules returns a copy of the charge schedules
+func (c *ChargeLimit) Schedules() []schedule.Schedule {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]schedule.Schedule(nil), c.schedules...)
+}
+
+// SetSchedules replaces the charge schedules, and re-evaluates them
+func (c *ChargeLimit) SetSchedules(schedules []schedule.Schedule) error {
+	for _, s := range schedules {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	c.schedules = schedules
+	// the active plan may not be wanted anymore
+	c.plan = schedule.Plan{}
+	c.mu.Unlock()
+
+	_, err := c.evaluate()
+	return err
+}
+
+// evaluate raises or restores the charge limit according to the schedules,
+// and returns a message for the user if the limit changed
+func (c *ChargeLimit) evaluate() (string, error) {
+	status, err := c.telemetry.Status()
+	if err != nil {
+		// we can still plan with the default rate and capacity
+		log.Printf("battery: unable to read battery status: %s\n", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.chargeRate = schedule.MeasureRate(c.chargeRate, status)
+	c.plan = c.planner.Plan(c.schedules, c.now(), status, c.chargeRate, c.plan)
+
+	var override uint8
+	if c.plan.Active {
+		override = c.plan.Target
+	}
+	if override == c.override {
+		return "", nil
+	}
+
+	previous := c.effectiveLimit()
+	c.override = override
+	limit := c.effectiveLimit()
+	if limit == previous {
+		return "", nil
+	}
+
+	if err := c.write(limit); err != nil {
+		return "", err
+	}
+
+	if c.plan.Active {
+		log.Printf("battery: charging to %d%% by %s\n", limit, c.plan.Deadline.Format("Mon 15:04"))
+		return fmt.Sprintf("Charging to %d%% by %s", limit, c.plan.Deadline.Format("15:04")), nil
+	}
+	log.Printf("battery: charge limit restored to %d%%\n", limit)
+	return fmt.Sprintf("Charge limit restored to %d%%", limit), nil
+}
+
+// Initialize satisfies system/plugin.Plugin
+func (c *ChargeLimit) Initialize() error {
+	return nil
+}
+
+func (c *ChargeLimit) loop(haltCtx context.Context, cb chan<- plugin.Callback) {
+	// schedules are checked against the wall clock, so missing ticks while
+	// suspended does not matter
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			message, err := c.checkExternal()
+			c.report(cb, message, err)
+			c.checkAndReport(cb)
+			c.checkLowBattery(cb)
+			c.trackHealth()
+		case message := <-c.notices:
+			c.report(cb, message, nil)
+		case t := <-c.queue:
+			switch t.Event {
+			case plugin.EvtChargerUnplugged:
+				message, err := c.unpluggedTopUp()
+				c.report(cb, message, err)
+				c.telemetry.Invalidate()
+				c.checkAndReport(cb)
+				c.checkLowBattery(cb)
+			case plugin.EvtACPIResume:
+				// the limit in firmware is not an external change until ours
+				// is written again
+				c.report(cb, "", c.reapply())
+				c.telemetry.Invalidate()
+				c.checkAndReport(cb)
+			case plugin.EvtChargerPluggedIn:
+				c.mu.Lock()
+				restore := c.lowMonitor.Reset()
+				c.mu.Unlock()
+				if restore {
+					c.restoreLowBattery(cb)
+				}
+				c.telemetry.Invalidate()
+				c.checkAndReport(cb)
+			}
+		case <-haltCtx.Done():
+			log.Println("battery: exiting Plugin run loop")
+			return
+		}
+	}
+}
+
+// checkAndReport ends the top-up if the battery is full, and applies the
+// charge schedules
+func (c *ChargeLimit) checkAndReport(cb chan<- plugin.Callback) {
+	message, err := c.checkTopUp()
+	c.report(cb, message, err)
+
+	message, err = c.evaluate()
+	c.report(cb, message, err)
+}
+
+// LowBatteryRules returns a copy of the low battery rules
+func (c *ChargeLimit) LowBatteryRules() []lowbattery.Rule {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]lowbattery.Rule(nil), c.lowRules...)
+}
+
+// SetLowBatteryRules replaces the low battery rules. Rules that already fired
+// in the current discharge cycle are not fired again.
+func (c *ChargeLimit) SetLowBatteryRules(rules []lowbattery.Rule) error {
+	if err := lowbattery.ValidateRules(rules); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lowRules = rules
+	return nil
+}
+
+// checkLowBattery fires the low battery rules that were reached. The actions
+// are carried out by the plugins owning the hardware, and undone once the
+// battery is charging again.
+func (c *ChargeLimit) checkLowBattery(cb chan<- plugin.Callback) {
+	status, err := c.telemetry.Status()
+	if err != nil {
+		log.Printf("battery: unable to read battery status: %s\n", err)
+		return
+	}
+
+	c.mu.Lock()
+	alerts, restore := c.lowMonitor.Check(c.lowRules, status)
+	c.mu.Unlock()
+
+	if restore {
+		c.restoreLowBattery(cb)
+	}
+	for _, alert := range alerts {
+		log.Printf("battery: %d%% threshold reached, running %v\n", alert.Threshold, alert.Actions)
+		if alert.Has(lowbattery.Notify) {
+			cb <- plugin.Callback{
+				Event: plugin.CbNotifyToast,
+				Value: util.Notification{
+					Message: fmt.Sprintf("Battery Low: %.0f%%", alert.Percent),
+				},
+			}
+		}
+		cb <- plugin.Callback{
+			Event: plugin.CbBatteryLow,
+			Value: alert,
+		}
+	}
+}
+
+// restoreLowBattery asks the plugins to undo the low battery actions
+func (c *ChargeLimit) restoreLowBattery(cb chan<- plugin.Callback) {
+	log.Println("battery: charging, undoing low battery actions")
+	cb <- plugin.Callback{
+		Event: plugin.CbBatteryRestored,
+	}
+}
+
+// trackHealth records the limit in effect, and samples the battery capacity
+// when it is due. Errors are only logged since the status may be unavailable.
+func (c *ChargeLimit) trackHealth() {
+	if err := c.health.Tick(c.now(), c.EffectiveLimit()); err != nil {
+		log.Printf("battery: unable to record battery health: %s\n", err)
+	}
+}
+
+// HealthReport returns the battery health report, with the capacity history
+func (c *ChargeLimit) HealthReport() health.Report {
+	return c.health.Report(c.now())
+}
+
+// HealthRegistry returns the persist.Registry of the battery health history
+func (c *ChargeLimit) HealthRegistry() persist.Registry {
+	return c.health
+}
+
+// report sends the message of a limit change to the user, or the error to the controller
+func (c *ChargeLimit) report(cb chan<- plugin.Callback, message string, err error) {
+	if err != nil {
+		c.errChan <- err
+		return
+	}
+	if message == "" {
+		return
+	}
+	cb <- plugin.Callback{
+		Event: plugin.CbNotifyToast,
+		Value: util.Notification{
+			Message: message,
+		},
+	}
+	cb <- plugin.Callback{
+		Event: plugin.CbPersistConfig,
+	}
+}
+
+// Run satisfies system/plugin.Plugin
+func (c *ChargeLimit) Run(haltCtx context.Context, cb chan<- plugin.Callback) <-chan error {
+	log.Println("battery: Starting queue loop")
+
+	go c.loop(haltCtx, cb)
+
+	return c.errChan
+}
+
+// Notify satisfies system/plugin.Plugin
+func (c *ChargeLimit) Notify(t plugin.Notification) {
+	c.queue <- t
+}
+
 var _ persist.Registry = &ChargeLimit{}
 
 // Name satisfies persist.Registry
@@ -66,31 +694,64 @@ func (c *ChargeLimit) Name() string {
 	return persistKey
 }
 
+type chargeLimitPersist struct {
+	Limit      uint8          `json:"limit"`
+	OnExternal ExternalChange `json:"onExternalChange"`
+}
+
 // Value satisfies persist.Registry
 func (c *ChargeLimit) Value() []byte {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	b := make([]byte, 2)
-	binary.LittleEndian.PutUint16(b, uint16(c.currentLimit))
+	b, _ := json.Marshal(chargeLimitPersist{
+		Limit:      c.currentLimit,
+		OnExternal: c.onExternal,
+	})
 	return b
 }
 
-// Load satisfies persist.Registry
+// Load satisfies persist.Registry. Older versions persisted only the limit as
+// a little endian uint16.
 func (c *ChargeLimit) Load(v []byte) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	if len(v) == 0 {
 		return nil
 	}
-	c.currentLimit = uint8(binary.LittleEndian.Uint16(v))
+
+	var p chargeLimitPersist
+	if err := json.Unmarshal(v, &p); err != nil {
+		// older versions persisted the limit as a uint16
+		if len(v) != 2 {
+			return err
+		}
+		limit := binary.LittleEndian.Uint16(v)
+		if limit > 100 {
+			return fmt.Errorf("invalid persisted charge limit %d", limit)
+		}
+		p = chargeLimitPersist{
+			Limit:      uint8(limit),
+			OnExternal: ReapplyLimit,
+		}
+	}
+
+	if err := c.validate(p.Limit); err != nil {
+		return err
+	}
+	if err := p.OnExternal.Validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.currentLimit = p.Limit
+	c.onExternal = p.OnExternal
 	return nil
 }
 
 // Apply satisfies persist.Registry
 func (c *ChargeLimit) Apply() error {
-	return c.Set(c.currentLimit)
+	return c.Set(c.CurrentLimit())
 }
 
 // Close satisfied persist.Registry
@@ -101,10 +762,155 @@ func (c *ChargeLimit) Close() error {
 	return c.wmi.Close()
 }
 
+// ScheduleRegistry returns the persist.Registry of the charge schedules, which
+// are persisted separately from the charge limit
+func (c *ChargeLimit) ScheduleRegistry() persist.Registry {
+	return &scheduleRegistry{
+		c: c,
+	}
+}
+
+type scheduleRegistry struct {
+	c *ChargeLimit
+}
+
+type schedulePersist struct {
+	Schedules  []schedule.Schedule `json:"schedules"`
+	ChargeRate float64             `json:"chargeRate"`
+}
+
+var _ persist.Registry = &scheduleRegistry{}
+
+// Name satisfies persist.Registry
+func (s *scheduleRegistry) Name() string {
+	return schedulePersistKey
+}
+
+// Value satisfies persist.Registry
+func (s *scheduleRegistry) Value() []byte {
+	s.c.mu.RLock()
+	defer s.c.mu.RUnlock()
+
+	b, _ := json.Marshal(schedulePersist{
+		Schedules:  s.c.schedules,
+		ChargeRate: s.c.chargeRate,
+	})
+	return b
+}
+
+// Load satisfies persist.Registry
+func (s *scheduleRegistry) Load(v []byte) error {
+	if len(v) == 0 {
+		return nil
+	}
+
+	var p schedulePersist
+	if err := json.Unmarshal(v, &p); err != nil {
+		return err
+	}
+	for _, sch := range p.Schedules {
+		if err := sch.Validate(); err != nil {
+			return err
+		}
+	}
+
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+
+	s.c.schedules = p.Schedules
+	s.c.chargeRate = p.ChargeRate
+	return nil
+}
+
+// Apply satisfies persist.Registry
+func (s *scheduleRegistry) Apply() error {
+	_, err := s.c.evaluate()
+	return err
+}
+
+// Close satisfied persist.Registry
+func (s *scheduleRegistry) Close() error {
+	return nil
+}
+
+// LowRulesRegistry returns the persist.Registry of the low battery rules
+func (c *ChargeLimit) LowRulesRegistry() persist.Registry {
+	return &lowRulesRegistry{
+		c: c,
+	}
+}
+
+type lowRulesRegistry struct {
+	c *ChargeLimit
+}
+
+var _ persist.Registry = &lowRulesRegistry{}
+
+// Name satisfies persist.Registry
+func (l *lowRulesRegistry) Name() string {
+	return lowRulesPersistKey
+}
+
+// Value satisfies persist.Registry
+func (l *lowRulesRegistry) Value() []byte {
+	b, _ := json.Marshal(l.c.LowBatteryRules())
+	return b
+}
+
+// Load satisfies persist.Registry
+func (l *lowRulesRegistry) Load(v []byte) error {
+	if len(v) == 0 {
+		return nil
+	}
+
+	var rules []lowbattery.Rule
+	if err := json.Unmarshal(v, &rules); err != nil {
+		return err
+	}
+	return l.c.SetLowBatteryRules(rules)
+}
+
+// Apply satisfies persist.Registry
+func (l *lowRulesRegistry) Apply() error {
+	return nil
+}
+
+// Close satisfied persist.Registry
+func (l *lowRulesRegistry) Close() error {
+	return nil
+}
+
 func (c *ChargeLimit) GetWSInfo() gin.H {
-	return gin.H{
-		"currentLimit": c.currentLimit,
+	c.mu.RLock()
+	info := gin.H{
+		"currentLimit":   c.currentLimit,
+		"effectiveLimit": c.effectiveLimit(),
+		"firmwareLimit":  nil,
+		"limitBounds": gin.H{
+			"min": c.model.ChargeLimit.Min,
+			"max": c.model.ChargeLimit.Max,
+		},
+		"onExternalChange": c.onExternal,
+		"schedules":        c.schedules,
+		"schedulePlan":     c.plan,
+		"topUp":            c.topUp,
+		"lowBatteryRules":  c.lowRules,
+		"status":           nil,
+	}
+	// refreshed by the plugin loop
+	if c.firmwareLimit != 0 {
+		info["firmwareLimit"] = c.firmwareLimit
+	}
+	c.mu.RUnlock()
+
+	info["health"] = c.HealthReport().Summary()
+
+	if status, err := c.Status(); err != nil {
+		log.Printf("battery: unable to read battery status: %s\n", err)
+	} else {
+		info["status"] = status
 	}
+	return info
 }
 
 func (c *ChargeLimit) HandleWSMessage(ws *websocket.Conn, action int, value string) {
@@ -113,5 +919,40 @@ func (c *ChargeLimit) HandleWSMessage(ws *websocket.Conn, action int, value stri
 	case 0:
 		i, _ := strconv.ParseUint(value, 10, 64)
 		c.Set(uint8(i))
+	// Set Charge Schedules
+	case 1:
+		var schedules []schedule.Schedule
+		if err := json.Unmarshal([]byte(value), &schedules); err != nil {
+			log.Printf("battery: invalid charge schedules: %s\n", err)
+			return
+		}
+		if err := c.SetSchedules(schedules); err != nil {
+			log.Printf("battery: unable to set charge schedules: %s\n", err)
+		}
+	// Top Up to 100% once
+	case 2:
+		if err := c.TopUp(); err != nil {
+			log.Printf("battery: unable to top up: %s\n", err)
+		}
+	// Cancel Top Up
+	case 3:
+		if err := c.CancelTopUp(); err != nil {
+			log.Printf("battery: unable to cancel top up: %s\n", err)
+		}
+	// Set External Change Policy (keep/reapply)
+	case 4:
+		if err := c.SetExternalChange(ExternalChange(value)); err != nil {
+			log.Printf("battery: unable to set external change policy: %s\n", err)
+		}
+	// Set Low Battery Rules
+	case 5:
+		var rules []lowbattery.Rule
+		if err := json.Unmarshal([]byte(value), &rules); err != nil {
+			log.Printf("battery: invalid low battery rules: %s\n", err)
+			return
+		}
+		if err := c.SetLowBatteryRules(rules); err != nil {
+			log.Printf("battery: unable to set low battery rules: %s\n", err)
+		}
 	}
 }
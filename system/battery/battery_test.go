@@ -1,8 +1,17 @@
 package battery
 
 import (
+	"encoding/binary"
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/NeilSeligmann/G15Manager/system/atkacpi"
+	"github.com/NeilSeligmann/G15Manager/system/battery/lowbattery"
+	"github.com/NeilSeligmann/G15Manager/system/battery/schedule"
+	"github.com/NeilSeligmann/G15Manager/system/battery/telemetry"
+	"github.com/NeilSeligmann/G15Manager/system/model"
+	"github.com/NeilSeligmann/G15Manager/system/plugin"
 	"github.com/stretchr/testify/require"
 )
 
@@ -11,15 +20,417 @@ func TestBatteryPersist(t *testing.T) {
 	expectedLimit := uint8(80)
 
 	limit := &ChargeLimit{
+		model:        testModel,
 		currentLimit: expectedLimit,
+		onExternal:   KeepExternal,
 	}
 	require.NotEmpty(t, limit.Name())
 
 	b := limit.Value()
 	require.NotEmpty(t, b)
 
-	loaded := ChargeLimit{}
+	loaded := ChargeLimit{model: testModel}
 
 	require.NoError(t, loaded.Load(b))
 	require.Equal(t, expectedLimit, loaded.currentLimit)
+	require.Equal(t, KeepExternal, loaded.onExternal)
+
+	// older versions persisted a uint16
+	legacy := make([]byte, 2)
+	binary.LittleEndian.PutUint16(legacy, 70)
+	require.NoError(t, loaded.Load(legacy))
+	require.Equal(t, uint8(70), loaded.currentLimit)
+	require.Equal(t, ReapplyLimit, loaded.onExternal)
+
+	// out of bounds values are rejected
+	binary.LittleEndian.PutUint16(legacy, 300)
+	require.Error(t, loaded.Load(legacy))
+	require.Error(t, loaded.Load([]byte(`{"limit":20,"onExternalChange":"keep"}`)))
+	require.Error(t, loaded.Load([]byte(`{"limit":80,"onExternalChange":"ignore"}`)))
+	require.Equal(t, uint8(70), loaded.currentLimit)
+
+	// two bytes of JSON are not a legacy limit
+	err := loaded.Load([]byte(`{}`))
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "invalid persisted charge limit")
+	require.Equal(t, uint8(70), loaded.currentLimit)
+}
+
+func TestBatteryStatus(t *testing.T) {
+	_, err := NewChargeLimit(&fakeWMI{}, nil, testModel)
+	require.Error(t, err)
+
+	svc, err := telemetry.NewService(telemetry.NewFake(telemetry.Reading{
+		State:              telemetry.Idle,
+		RemainingCapacity:  54,
+		FullChargeCapacity: 90,
+	}), time.Second)
+	require.NoError(t, err)
+
+	limit, err := NewChargeLimit(&fakeWMI{}, svc, testModel)
+	require.NoError(t, err)
+
+	info := limit.GetWSInfo()
+	require.Equal(t, uint8(60), info["currentLimit"])
+	status, ok := info["status"].(telemetry.Status)
+	require.True(t, ok)
+	require.Equal(t, 60.0, status.Percent)
+}
+
+func TestBatteryStatusTimeToFull(t *testing.T) {
+	svc, err := telemetry.NewService(telemetry.NewFake(telemetry.Reading{
+		State:              telemetry.Charging,
+		RemainingCapacity:  54,
+		FullChargeCapacity: 90,
+		Rate:               18,
+	}), 0)
+	require.NoError(t, err)
+
+	limit, err := NewChargeLimit(&fakeWMI{}, svc, testModel)
+	require.NoError(t, err)
+	limit.currentLimit = 80
+
+	// charging to 72 Wh instead of 90 Wh
+	status, err := limit.Status()
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, status.TimeToFull)
+
+	// a top-up charges to 100%
+	limit.topUp = true
+	status, err = limit.Status()
+	require.NoError(t, err)
+	require.Equal(t, time.Hour*2, status.TimeToFull)
+}
+
+func TestBatteryHealth(t *testing.T) {
+	fake := telemetry.NewFake(telemetry.Reading{
+		State:              telemetry.Idle,
+		RemainingCapacity:  54,
+		FullChargeCapacity: 81,
+		DesignCapacity:     90,
+		CycleCount:         120,
+	})
+	svc, err := telemetry.NewService(fake, 0)
+	require.NoError(t, err)
+
+	limit, err := NewChargeLimit(&fakeWMI{}, svc, testModel)
+	require.NoError(t, err)
+
+	now := time.Date(2021, 8, 16, 12, 0, 0, 0, time.UTC)
+	limit.now = func() time.Time { return now }
+
+	limit.trackHealth()
+	now = now.Add(time.Minute)
+	limit.trackHealth()
+
+	report := limit.HealthReport()
+	require.Len(t, report.History, 1)
+	require.Equal(t, 10.0, report.Wear)
+	require.Equal(t, 120, report.CycleCount)
+	require.Equal(t, map[uint8]float64{60: 100}, report.LimitShare)
+
+	loaded, err := NewChargeLimit(&fakeWMI{}, svc, testModel)
+	require.NoError(t, err)
+	loaded.now = limit.now
+	require.NoError(t, loaded.HealthRegistry().Load(limit.HealthRegistry().Value()))
+	require.Equal(t, report, loaded.HealthReport())
+}
+
+var testModel = &model.Models[0]
+
+// fakeWMI records the limits written. If readback is set, DSTS reports the
+// limit in firmware, and if stuck is set the firmware ignores the writes.
+type fakeWMI struct {
+	limits   []uint32
+	readback bool
+	stuck    bool
+	firmware uint32
+	err      error
+}
+
+func (f *fakeWMI) Evaluate(id atkacpi.Method, args []byte) ([]byte, error) {
+	if binary.LittleEndian.Uint32(args[0:]) != atkacpi.DevsBatteryChargeLimit {
+		return nil, nil
+	}
+	switch id {
+	case atkacpi.DEVS:
+		if f.err != nil {
+			return nil, f.err
+		}
+		f.limits = append(f.limits, binary.LittleEndian.Uint32(args[4:]))
+		if !f.stuck {
+			f.firmware = binary.LittleEndian.Uint32(args[4:])
+		}
+	case atkacpi.DSTS:
+		if !f.readback {
+			return nil, nil
+		}
+		status := make([]byte, 4)
+		binary.LittleEndian.PutUint32(status, dstsPresent|f.firmware)
+		return status, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeWMI) Close() error {
+	return nil
+}
+
+func TestBatterySchedule(t *testing.T) {
+	svc, err := telemetry.NewService(telemetry.NewFake(telemetry.Reading{
+		State:              telemetry.Idle,
+		RemainingCapacity:  48,
+		FullChargeCapacity: 80,
+	}), 0)
+	require.NoError(t, err)
+
+	wmi := &fakeWMI{}
+	limit, err := NewChargeLimit(wmi, svc, testModel)
+	require.NoError(t, err)
+
+	// 2021-08-16 is a Monday
+	clock := time.Date(2021, 8, 16, 5, 0, 0, 0, time.Local)
+	limit.now = func() time.Time {
+		return clock
+	}
+
+	require.NoError(t, limit.Set(60))
+	require.NoError(t, limit.SetSchedules([]schedule.Schedule{
+		{Days: []time.Weekday{time.Monday}, At: "07:30", Target: 100},
+	}))
+	require.Equal(t, uint8(60), limit.EffectiveLimit())
+
+	// 32Wh at the default 30W, plus the margin
+	clock = time.Date(2021, 8, 16, 6, 0, 0, 0, time.Local)
+	msg, err := limit.evaluate()
+	require.NoError(t, err)
+	require.NotEmpty(t, msg)
+	require.Equal(t, uint8(100), limit.EffectiveLimit())
+	require.Equal(t, uint8(60), limit.CurrentLimit())
+
+	// the configured limit is persisted, not the override
+	loaded := ChargeLimit{model: testModel}
+	require.NoError(t, loaded.Load(limit.Value()))
+	require.Equal(t, uint8(60), loaded.currentLimit)
+
+	clock = time.Date(2021, 8, 16, 9, 0, 0, 0, time.Local)
+	msg, err = limit.evaluate()
+	require.NoError(t, err)
+	require.NotEmpty(t, msg)
+	require.Equal(t, uint8(60), limit.EffectiveLimit())
+
+	require.Equal(t, []uint32{60, 100, 60}, wmi.limits)
+
+	// schedules survive a restart
+	restored, err := NewChargeLimit(&fakeWMI{}, svc, testModel)
+	require.NoError(t, err)
+	registry := restored.ScheduleRegistry()
+	require.NoError(t, registry.Load(limit.ScheduleRegistry().Value()))
+	require.Equal(t, limit.Schedules(), restored.Schedules())
+}
+
+func TestBatteryTopUp(t *testing.T) {
+	fake := telemetry.NewFake(telemetry.Reading{
+		State:              telemetry.Charging,
+		RemainingCapacity:  48,
+		FullChargeCapacity: 80,
+		Rate:               40,
+	})
+	svc, err := telemetry.NewService(fake, 0)
+	require.NoError(t, err)
+
+	wmi := &fakeWMI{}
+	limit, err := NewChargeLimit(wmi, svc, testModel)
+	require.NoError(t, err)
+	require.NoError(t, limit.Set(60))
+
+	require.NoError(t, limit.TopUp())
+	require.True(t, limit.TopUpActive())
+	require.Equal(t, uint8(100), limit.EffectiveLimit())
+	require.Equal(t, true, limit.GetWSInfo()["topUp"])
+
+	// still charging
+	msg, err := limit.checkTopUp()
+	require.NoError(t, err)
+	require.Empty(t, msg)
+	require.True(t, limit.TopUpActive())
+
+	fake.Set(telemetry.Reading{
+		State:              telemetry.Full,
+		RemainingCapacity:  80,
+		FullChargeCapacity: 80,
+	}, nil)
+	msg, err = limit.checkTopUp()
+	require.NoError(t, err)
+	require.NotEmpty(t, msg)
+	require.False(t, limit.TopUpActive())
+	require.Equal(t, uint8(60), limit.EffectiveLimit())
+
+	// cancelled before the battery is full
+	require.NoError(t, limit.TopUp())
+	require.NoError(t, limit.CancelTopUp())
+	require.False(t, limit.TopUpActive())
+	require.NoError(t, limit.CancelTopUp())
+
+	require.Equal(t, []uint32{60, 100, 60, 100, 60}, wmi.limits)
+}
+
+func TestBatteryExternalChange(t *testing.T) {
+	svc, err := telemetry.NewService(telemetry.NewFake(telemetry.Reading{
+		State:              telemetry.Idle,
+		RemainingCapacity:  48,
+		FullChargeCapacity: 80,
+	}), 0)
+	require.NoError(t, err)
+
+	_, err = NewChargeLimit(&fakeWMI{}, svc, nil)
+	require.Error(t, err)
+
+	// the limit in firmware is ignored until ours is applied
+	wmi := &fakeWMI{readback: true, firmware: 100}
+	limit, err := NewChargeLimit(wmi, svc, testModel)
+	require.NoError(t, err)
+	require.NoError(t, limit.SetExternalChange(KeepExternal))
+	msg, err := limit.checkExternal()
+	require.NoError(t, err)
+	require.Empty(t, msg)
+	require.NoError(t, limit.reapply())
+	require.Empty(t, wmi.limits)
+	require.NoError(t, limit.SetExternalChange(ReapplyLimit))
+
+	require.Error(t, limit.Set(testModel.ChargeLimit.Min-1))
+	require.NoError(t, limit.Set(60))
+
+	firmware, err := limit.FirmwareLimit()
+	require.NoError(t, err)
+	require.Equal(t, uint8(60), firmware)
+
+	msg, err = limit.checkExternal()
+	require.NoError(t, err)
+	require.Empty(t, msg)
+
+	// re-applied by default
+	wmi.firmware = 80
+	msg, err = limit.checkExternal()
+	require.NoError(t, err)
+	require.NotEmpty(t, msg)
+	require.Equal(t, uint32(60), wmi.firmware)
+	require.Equal(t, uint8(60), limit.CurrentLimit())
+
+	// kept
+	require.Error(t, limit.SetExternalChange("ignore"))
+	require.NoError(t, limit.SetExternalChange(KeepExternal))
+	wmi.firmware = 80
+	msg, err = limit.checkExternal()
+	require.NoError(t, err)
+	require.NotEmpty(t, msg)
+	require.Equal(t, uint8(80), limit.CurrentLimit())
+	require.Equal(t, []uint32{60, 60}, wmi.limits)
+
+	// unless it is out of the bounds of the model
+	wmi.firmware = uint32(testModel.ChargeLimit.Min - 1)
+	msg, err = limit.checkExternal()
+	require.NoError(t, err)
+	require.NotEmpty(t, msg)
+	require.Equal(t, uint32(80), wmi.firmware)
+	require.Equal(t, uint8(80), limit.CurrentLimit())
+
+	// the firmware may reset the limit while suspended
+	wmi.firmware = 100
+	require.NoError(t, limit.reapply())
+	require.Equal(t, uint32(80), wmi.firmware)
+
+	// a top-up is temporary, so it is always re-applied
+	require.NoError(t, limit.TopUp())
+	wmi.firmware = 80
+	msg, err = limit.checkExternal()
+	require.NoError(t, err)
+	require.NotEmpty(t, msg)
+	require.Equal(t, uint32(100), wmi.firmware)
+	require.Equal(t, uint8(80), limit.CurrentLimit())
+
+	// without readback we can only trust our writes
+	wmi.readback = false
+	msg, err = limit.checkExternal()
+	require.NoError(t, err)
+	require.Empty(t, msg)
+}
+
+func TestBatterySetFailure(t *testing.T) {
+	svc, err := telemetry.NewService(telemetry.NewFake(telemetry.Reading{
+		State:              telemetry.Idle,
+		RemainingCapacity:  48,
+		FullChargeCapacity: 80,
+	}), 0)
+	require.NoError(t, err)
+
+	wmi := &fakeWMI{readback: true}
+	limit, err := NewChargeLimit(wmi, svc, testModel)
+	require.NoError(t, err)
+	require.NoError(t, limit.Set(70))
+
+	// the limit is only committed once written
+	wmi.err = errors.New("ACPI error")
+	require.Error(t, limit.Set(80))
+	require.Equal(t, uint8(70), limit.CurrentLimit())
+	require.Empty(t, limit.notices)
+
+	// a readback mismatch is reported instead of returned
+	wmi.err = nil
+	wmi.stuck = true
+	require.NoError(t, limit.Set(80))
+	require.Equal(t, uint8(80), limit.CurrentLimit())
+	require.NotEmpty(t, <-limit.notices)
+
+	// clients are served the limit read back
+	wmi.firmware = 90
+	require.Equal(t, uint8(70), limit.GetWSInfo()["firmwareLimit"])
+}
+
+func TestBatteryLowRules(t *testing.T) {
+	fake := telemetry.NewFake(telemetry.Reading{
+		State:              telemetry.Discharging,
+		RemainingCapacity:  16,
+		FullChargeCapacity: 80,
+		Rate:               -20,
+	})
+	svc, err := telemetry.NewService(fake, 0)
+	require.NoError(t, err)
+
+	limit, err := NewChargeLimit(&fakeWMI{}, svc, testModel)
+	require.NoError(t, err)
+
+	require.Error(t, limit.SetLowBatteryRules([]lowbattery.Rule{{Threshold: 20}}))
+	rules := []lowbattery.Rule{
+		{Threshold: 20, Actions: []lowbattery.Action{lowbattery.Notify, lowbattery.DimKeyboard}},
+	}
+	require.NoError(t, limit.SetLowBatteryRules(rules))
+
+	cb := make(chan plugin.Callback, 10)
+	limit.checkLowBattery(cb)
+	require.Len(t, cb, 2)
+	require.Equal(t, plugin.CbNotifyToast, (<-cb).Event)
+	low := <-cb
+	require.Equal(t, plugin.CbBatteryLow, low.Event)
+	require.Equal(t, uint8(20), low.Value.(lowbattery.Alert).Threshold)
+
+	// once per discharge cycle
+	limit.checkLowBattery(cb)
+	require.Empty(t, cb)
+
+	fake.Set(telemetry.Reading{
+		State:              telemetry.Charging,
+		RemainingCapacity:  17,
+		FullChargeCapacity: 80,
+		Rate:               40,
+	}, nil)
+	limit.checkLowBattery(cb)
+	require.Len(t, cb, 1)
+	require.Equal(t, plugin.CbBatteryRestored, (<-cb).Event)
+
+	restored, err := NewChargeLimit(&fakeWMI{}, svc, testModel)
+	require.NoError(t, err)
+	registry := restored.LowRulesRegistry()
+	require.NoError(t, registry.Load(limit.LowRulesRegistry().Value()))
+	require.Equal(t, rules, restored.LowBatteryRules())
 }
@@ -0,0 +1,316 @@
+// Package health records the battery capacity over time, and reports how
+// the battery wears.
+package health
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/NeilSeligmann/G15Manager/system/battery/telemetry"
+)
+
+const (
+	persistKey = "BatteryHealth"
+)
+
+const (
+	defaultSampleInterval = time.Hour * 24
+	// ticks further apart than this (e.g. suspended or shut down) are not
+	// counted in the time spent at a charge limit
+	defaultMaxGap = time.Minute * 5
+	// two years of daily samples
+	defaultMaxSamples = 730
+)
+
+// StatusProvider returns the battery status, e.g. *telemetry.Service
+type StatusProvider interface {
+	Status() (telemetry.Status, error)
+}
+
+// Sample is the battery capacity (in Wh) at a point in time
+type Sample struct {
+	Time               time.Time `json:"time"`
+	FullChargeCapacity float64   `json:"fullChargeCapacity"`
+	DesignCapacity     float64   `json:"designCapacity"`
+	CycleCount         int       `json:"cycleCount"`
+}
+
+// Wear returns how much capacity was lost, in percent of the design capacity
+func (s Sample) Wear() float64 {
+	if s.DesignCapacity <= 0 {
+		return 0
+	}
+	return round(100 - s.FullChargeCapacity/s.DesignCapacity*100)
+}
+
+// Point is a Sample with its wear, as reported
+type Point struct {
+	Sample
+	Wear float64 `json:"wear"`
+}
+
+// Report summarizes the battery health. CapacityTrend is the change of full
+// charge capacity in Wh per 30 days, and LimitShare is the share of time (in
+// percent) spent at each charge limit.
+type Report struct {
+	Generated          time.Time         `json:"generated"`
+	FullChargeCapacity float64           `json:"fullChargeCapacity"`
+	DesignCapacity     float64           `json:"designCapacity"`
+	Wear               float64           `json:"wear"`
+	CycleCount         int               `json:"cycleCount"`
+	CapacityTrend      float64           `json:"capacityTrend"`
+	LimitShare         map[uint8]float64 `json:"limitShare"`
+	History            []Point           `json:"history,omitempty"`
+}
+
+// Summary returns the report without the history
+func (r Report) Summary() Report {
+	r.History = nil
+	return r
+}
+
+// Tracker periodically samples the battery capacity and the charge limit in
+// use. The Tracker can be persisted (it satisfies persist.Registry), and is
+// safe for multiple goroutines.
+type Tracker struct {
+	provider       StatusProvider
+	sampleInterval time.Duration
+	maxGap         time.Duration
+	maxSamples     int
+
+	mu        sync.RWMutex
+	samples   []Sample
+	limitTime map[uint8]time.Duration
+	lastTick  time.Time
+}
+
+// NewTracker returns a Tracker reading the battery status from provider
+func NewTracker(provider StatusProvider) (*Tracker, error) {
+	if provider == nil {
+		return nil, errors.New("health: nil StatusProvider is invalid")
+	}
+	return &Tracker{
+		provider:       provider,
+		sampleInterval: defaultSampleInterval,
+		maxGap:         defaultMaxGap,
+		maxSamples:     defaultMaxSamples,
+		limitTime:      make(map[uint8]time.Duration),
+	}, nil
+}
+
+// Tick should be called regularly (more often than every 5 minutes) with the
+// charge limit in effect. The time since the previous tick is counted towards
+// the limit, and the capacity is sampled once a day.
+func (t *Tracker) Tick(now time.Time, limit uint8) error {
+	t.mu.Lock()
+	if !t.lastTick.IsZero() {
+		if gap := now.Sub(t.lastTick); gap > 0 && gap <= t.maxGap {
+			t.limitTime[limit] += gap
+		}
+	}
+	t.lastTick = now
+
+	due := len(t.samples) == 0 || now.Sub(t.samples[len(t.samples)-1].Time) >= t.sampleInterval
+	t.mu.Unlock()
+
+	if !due {
+		return nil
+	}
+
+	status, err := t.provider.Status()
+	if err != nil {
+		return err
+	}
+	if status.FullChargeCapacity <= 0 {
+		return fmt.Errorf("health: full charge capacity is not reported")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.samples = append(t.samples, Sample{
+		Time:               now,
+		FullChargeCapacity: status.FullChargeCapacity,
+		DesignCapacity:     status.DesignCapacity,
+		CycleCount:         status.CycleCount,
+	})
+	if len(t.samples) > t.maxSamples {
+		t.samples = t.samples[len(t.samples)-t.maxSamples:]
+	}
+	return nil
+}
+
+// Report returns the health report at now
+func (t *Tracker) Report(now time.Time) Report {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	r := Report{
+		Generated:  now,
+		LimitShare: make(map[uint8]float64, len(t.limitTime)),
+		History:    make([]Point, 0, len(t.samples)),
+	}
+
+	for _, s := range t.samples {
+		r.History = append(r.History, Point{
+			Sample: s,
+			Wear:   s.Wear(),
+		})
+	}
+	if len(t.samples) > 0 {
+		last := t.samples[len(t.samples)-1]
+		r.FullChargeCapacity = last.FullChargeCapacity
+		r.DesignCapacity = last.DesignCapacity
+		r.Wear = last.Wear()
+		r.CycleCount = last.CycleCount
+	}
+	r.CapacityTrend = round(trend(t.samples) * 30)
+
+	var total time.Duration
+	for _, d := range t.limitTime {
+		total += d
+	}
+	for limit, d := range t.limitTime {
+		if total > 0 {
+			r.LimitShare[limit] = round(float64(d) / float64(total) * 100)
+		}
+	}
+
+	return r
+}
+
+// trend returns the slope of the full charge capacity, in Wh per day, using
+// least squares
+func trend(samples []Sample) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	origin := samples[0].Time
+	var sumX, sumY, sumXY, sumXX float64
+	for _, s := range samples {
+		x := s.Time.Sub(origin).Hours() / 24
+		sumX += x
+		sumY += s.FullChargeCapacity
+		sumXY += x * s.FullChargeCapacity
+		sumXX += x * x
+	}
+	n := float64(len(samples))
+	d := n*sumXX - sumX*sumX
+	if d == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / d
+}
+
+func round(v float64) float64 {
+	return math.Round(v*10) / 10
+}
+
+// WriteCSV writes the history of the report as CSV
+func WriteCSV(w io.Writer, r Report) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"time", "fullChargeCapacity", "designCapacity", "wear", "cycleCount"}); err != nil {
+		return err
+	}
+	for _, p := range r.History {
+		err := cw.Write([]string{
+			p.Time.Format(time.RFC3339),
+			strconv.FormatFloat(p.FullChargeCapacity, 'f', -1, 64),
+			strconv.FormatFloat(p.DesignCapacity, 'f', -1, 64),
+			strconv.FormatFloat(p.Wear, 'f', -1, 64),
+			strconv.Itoa(p.CycleCount),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// LimitShareCSV writes the share of time at each charge limit as CSV
+func LimitShareCSV(w io.Writer, r Report) error {
+	limits := make([]int, 0, len(r.LimitShare))
+	for l := range r.LimitShare {
+		limits = append(limits, int(l))
+	}
+	sort.Ints(limits)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"limit", "share"}); err != nil {
+		return err
+	}
+	for _, l := range limits {
+		err := cw.Write([]string{
+			strconv.Itoa(l),
+			strconv.FormatFloat(r.LimitShare[uint8(l)], 'f', -1, 64),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type persistValue struct {
+	Samples   []Sample                `json:"samples"`
+	LimitTime map[uint8]time.Duration `json:"limitTime"`
+}
+
+// Name satisfies persist.Registry
+func (t *Tracker) Name() string {
+	return persistKey
+}
+
+// Value satisfies persist.Registry
+func (t *Tracker) Value() []byte {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	b, _ := json.Marshal(persistValue{
+		Samples:   t.samples,
+		LimitTime: t.limitTime,
+	})
+	return b
+}
+
+// Load satisfies persist.Registry
+func (t *Tracker) Load(v []byte) error {
+	if len(v) == 0 {
+		return nil
+	}
+
+	var p persistValue
+	if err := json.Unmarshal(v, &p); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.samples = p.Samples
+	t.limitTime = p.LimitTime
+	if t.limitTime == nil {
+		t.limitTime = make(map[uint8]time.Duration)
+	}
+	return nil
+}
+
+// Apply satisfies persist.Registry
+func (t *Tracker) Apply() error {
+	return nil
+}
+
+// Close satisfied persist.Registry
+func (t *Tracker) Close() error {
+	return nil
+}
@@ -0,0 +1,119 @@
+package health
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NeilSeligmann/G15Manager/system/battery/telemetry"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProvider struct {
+	status telemetry.Status
+	err    error
+}
+
+func (f *fakeProvider) Status() (telemetry.Status, error) {
+	return f.status, f.err
+}
+
+func newTestTracker(t *testing.T, p StatusProvider) *Tracker {
+	tr, err := NewTracker(p)
+	require.NoError(t, err)
+	return tr
+}
+
+func TestTrackerSamples(t *testing.T) {
+	_, err := NewTracker(nil)
+	require.Error(t, err)
+
+	p := &fakeProvider{
+		status: telemetry.Status{FullChargeCapacity: 90, DesignCapacity: 90, CycleCount: 10},
+	}
+	tr := newTestTracker(t, p)
+
+	start := time.Date(2021, 8, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, tr.Tick(start, 60))
+
+	// not due yet, even if the capacity changed
+	p.status.FullChargeCapacity = 89
+	require.NoError(t, tr.Tick(start.Add(time.Hour), 60))
+	require.Len(t, tr.Report(start).History, 1)
+
+	// 0.1 Wh lost per day
+	for day := 1; day <= 30; day++ {
+		p.status.FullChargeCapacity = 90 - float64(day)*0.1
+		p.status.CycleCount = 10 + day
+		require.NoError(t, tr.Tick(start.AddDate(0, 0, day), 60))
+	}
+
+	r := tr.Report(start.AddDate(0, 0, 30))
+	require.Len(t, r.History, 31)
+	require.Equal(t, 87.0, r.FullChargeCapacity)
+	require.Equal(t, 3.3, r.Wear)
+	require.Equal(t, 40, r.CycleCount)
+	require.Equal(t, -3.0, r.CapacityTrend)
+	require.Nil(t, r.Summary().History)
+}
+
+func TestTrackerUnavailable(t *testing.T) {
+	p := &fakeProvider{err: telemetry.ErrNoBattery}
+	tr := newTestTracker(t, p)
+
+	err := tr.Tick(time.Now(), 60)
+	require.True(t, errors.Is(err, telemetry.ErrNoBattery))
+
+	p.err = nil
+	require.Error(t, tr.Tick(time.Now(), 60))
+	require.Empty(t, tr.Report(time.Now()).History)
+}
+
+func TestTrackerLimitShare(t *testing.T) {
+	p := &fakeProvider{
+		status: telemetry.Status{FullChargeCapacity: 90, DesignCapacity: 90},
+	}
+	tr := newTestTracker(t, p)
+
+	now := time.Date(2021, 8, 1, 12, 0, 0, 0, time.UTC)
+	tick := func(d time.Duration, limit uint8) {
+		now = now.Add(d)
+		require.NoError(t, tr.Tick(now, limit))
+	}
+
+	tick(0, 60)
+	for i := 0; i < 3; i++ {
+		tick(time.Minute, 60)
+	}
+	// suspended for a while, not counted
+	tick(time.Hour, 100)
+	tick(time.Minute, 100)
+
+	r := tr.Report(now)
+	require.Equal(t, map[uint8]float64{60: 75, 100: 25}, r.LimitShare)
+
+	var buf bytes.Buffer
+	require.NoError(t, LimitShareCSV(&buf, r))
+	require.Equal(t, "limit,share\n60,75\n100,25\n", buf.String())
+}
+
+func TestTrackerPersist(t *testing.T) {
+	p := &fakeProvider{
+		status: telemetry.Status{FullChargeCapacity: 85.5, DesignCapacity: 90, CycleCount: 42},
+	}
+	tr := newTestTracker(t, p)
+	require.NotEmpty(t, tr.Name())
+
+	now := time.Date(2021, 8, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, tr.Tick(now, 80))
+	require.NoError(t, tr.Tick(now.Add(time.Minute), 80))
+
+	loaded := newTestTracker(t, p)
+	require.NoError(t, loaded.Load(tr.Value()))
+	require.Equal(t, tr.Report(now), loaded.Report(now))
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteCSV(&buf, loaded.Report(now)))
+	require.Equal(t, "time,fullChargeCapacity,designCapacity,wear,cycleCount\n2021-08-01T12:00:00Z,85.5,90,5,42\n", buf.String())
+}
@@ -0,0 +1,141 @@
+// Package lowbattery defines the actions to take when the battery runs low.
+// The battery plugin fires the rules, and the plugins owning the hardware
+// (keyboard, refresh rate, GPU, etc) carry out and undo the actions.
+package lowbattery
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/NeilSeligmann/G15Manager/system/battery/telemetry"
+)
+
+// Action defines what to do at a threshold
+type Action string
+
+// Defines the low battery actions
+const (
+	DimKeyboard       Action = "dimKeyboard"
+	KeyboardOff       Action = "keyboardOff"
+	LowestRefreshRate Action = "lowestRefreshRate"
+	DisableGPU        Action = "disableGPU"
+	StopDenoise       Action = "stopDenoise"
+	Notify            Action = "notify"
+)
+
+// Validate returns an error if the action is unknown
+func (a Action) Validate() error {
+	switch a {
+	case DimKeyboard, KeyboardOff, LowestRefreshRate, DisableGPU, StopDenoise, Notify:
+		return nil
+	}
+	return fmt.Errorf("unknown low battery action %q", a)
+}
+
+// Rule runs Actions once the battery drops to Threshold percent while discharging
+type Rule struct {
+	Threshold uint8    `json:"threshold"`
+	Actions   []Action `json:"actions"`
+}
+
+// Validate returns an error if the rule cannot be used
+func (r Rule) Validate() error {
+	if r.Threshold < 1 || r.Threshold > 99 {
+		return fmt.Errorf("low battery threshold must be between 1 and 99, got %d", r.Threshold)
+	}
+	if len(r.Actions) == 0 {
+		return fmt.Errorf("low battery rule at %d%% has no actions", r.Threshold)
+	}
+	for _, a := range r.Actions {
+		if err := a.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateRules returns an error if a rule is invalid, or if two rules have
+// the same threshold
+func ValidateRules(rules []Rule) error {
+	seen := make(map[uint8]bool, len(rules))
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+		if seen[r.Threshold] {
+			return fmt.Errorf("duplicate low battery rule at %d%%", r.Threshold)
+		}
+		seen[r.Threshold] = true
+	}
+	return nil
+}
+
+// Alert is sent to the plugins when a rule fires
+type Alert struct {
+	Threshold uint8
+	Percent   float64
+	Actions   []Action
+}
+
+// Has returns true if the alert includes action
+func (a Alert) Has(action Action) bool {
+	for _, x := range a.Actions {
+		if x == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Monitor fires each rule once per discharge cycle. The zero value is ready
+// to use, and it is not safe for multiple goroutines.
+type Monitor struct {
+	fired map[uint8]bool
+}
+
+// Check returns the alerts of the rules whose threshold was reached, highest
+// threshold first. Once the battery is charging again, the discharge cycle
+// ends and restore is true if the actions of a rule should be undone.
+func (m *Monitor) Check(rules []Rule, status telemetry.Status) (alerts []Alert, restore bool) {
+	switch status.State {
+	case telemetry.Charging, telemetry.Full:
+		return nil, m.Reset()
+	case telemetry.Discharging:
+	default:
+		return nil, false
+	}
+	if !status.PercentKnown {
+		// without the charge every rule would fire
+		return nil, false
+	}
+
+	if m.fired == nil {
+		m.fired = make(map[uint8]bool)
+	}
+
+	sorted := append([]Rule(nil), rules...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Threshold > sorted[j].Threshold
+	})
+
+	for _, r := range sorted {
+		if status.Percent > float64(r.Threshold) || m.fired[r.Threshold] {
+			continue
+		}
+		m.fired[r.Threshold] = true
+		alerts = append(alerts, Alert{
+			Threshold: r.Threshold,
+			Percent:   status.Percent,
+			Actions:   r.Actions,
+		})
+	}
+	return alerts, false
+}
+
+// Reset starts a new discharge cycle (e.g. the charger was plugged in). It
+// returns true if a rule fired during the previous cycle.
+func (m *Monitor) Reset() bool {
+	fired := len(m.fired) > 0
+	m.fired = nil
+	return fired
+}
@@ -0,0 +1,85 @@
+package lowbattery
+
+import (
+	"testing"
+
+	"github.com/NeilSeligmann/G15Manager/system/battery/telemetry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRules(t *testing.T) {
+	require.NoError(t, ValidateRules(nil))
+	require.NoError(t, ValidateRules([]Rule{
+		{Threshold: 20, Actions: []Action{Notify}},
+		{Threshold: 10, Actions: []Action{KeyboardOff, LowestRefreshRate}},
+	}))
+
+	require.Error(t, ValidateRules([]Rule{{Threshold: 0, Actions: []Action{Notify}}}))
+	require.Error(t, ValidateRules([]Rule{{Threshold: 100, Actions: []Action{Notify}}}))
+	require.Error(t, ValidateRules([]Rule{{Threshold: 20}}))
+	require.Error(t, ValidateRules([]Rule{{Threshold: 20, Actions: []Action{"hibernate"}}}))
+	require.Error(t, ValidateRules([]Rule{
+		{Threshold: 20, Actions: []Action{Notify}},
+		{Threshold: 20, Actions: []Action{DimKeyboard}},
+	}))
+}
+
+func TestMonitor(t *testing.T) {
+	rules := []Rule{
+		{Threshold: 10, Actions: []Action{KeyboardOff, DisableGPU}},
+		{Threshold: 20, Actions: []Action{Notify, DimKeyboard}},
+	}
+	discharging := func(pct float64) telemetry.Status {
+		return telemetry.Status{State: telemetry.Discharging, Percent: pct, PercentKnown: true}
+	}
+
+	m := &Monitor{}
+	alerts, restore := m.Check(rules, discharging(50))
+	require.Empty(t, alerts)
+	require.False(t, restore)
+
+	alerts, _ = m.Check(rules, discharging(19.5))
+	require.Len(t, alerts, 1)
+	require.Equal(t, uint8(20), alerts[0].Threshold)
+	require.True(t, alerts[0].Has(DimKeyboard))
+	require.False(t, alerts[0].Has(KeyboardOff))
+
+	// once per cycle
+	alerts, _ = m.Check(rules, discharging(18))
+	require.Empty(t, alerts)
+
+	// idle on AC does not end the cycle
+	alerts, restore = m.Check(rules, telemetry.Status{State: telemetry.Idle, Percent: 18})
+	require.Empty(t, alerts)
+	require.False(t, restore)
+
+	alerts, _ = m.Check(rules, discharging(10))
+	require.Len(t, alerts, 1)
+	require.Equal(t, uint8(10), alerts[0].Threshold)
+
+	alerts, restore = m.Check(rules, telemetry.Status{State: telemetry.Charging, Percent: 11})
+	require.Empty(t, alerts)
+	require.True(t, restore)
+	require.False(t, m.Reset())
+
+	// unplugged below both thresholds
+	alerts, _ = m.Check(rules, discharging(8))
+	require.Len(t, alerts, 2)
+	require.Equal(t, uint8(20), alerts[0].Threshold)
+	require.Equal(t, uint8(10), alerts[1].Threshold)
+	require.True(t, m.Reset())
+}
+
+func TestMonitorUnknownPercent(t *testing.T) {
+	rules := []Rule{{Threshold: 20, Actions: []Action{Notify}}}
+
+	// the full charge capacity could not be read
+	m := &Monitor{}
+	alerts, restore := m.Check(rules, telemetry.NewStatus(telemetry.Reading{
+		State:             telemetry.Discharging,
+		RemainingCapacity: 60,
+	}))
+	require.Empty(t, alerts)
+	require.False(t, restore)
+	require.False(t, m.Reset())
+}
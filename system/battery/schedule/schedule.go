@@ -0,0 +1,173 @@
+// Package schedule plans when the battery charge limit has to be raised, so
+// the battery is charged to a target by a set time.
+package schedule
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/NeilSeligmann/G15Manager/system/battery/telemetry"
+)
+
+const timeLayout = "15:04"
+
+// Schedule asks for the battery to be charged to Target (in percent) by At
+// (e.g. "07:30", local time) on each of Days
+type Schedule struct {
+	Days   []time.Weekday `json:"days"`
+	At     string         `json:"at"`
+	Target uint8          `json:"target"`
+}
+
+// Validate returns an error if the schedule cannot be planned
+func (s Schedule) Validate() error {
+	if len(s.Days) == 0 {
+		return fmt.Errorf("schedule: no days set")
+	}
+	for _, d := range s.Days {
+		if d < time.Sunday || d > time.Saturday {
+			return fmt.Errorf("schedule: invalid day %d", d)
+		}
+	}
+	if _, err := time.Parse(timeLayout, s.At); err != nil {
+		return fmt.Errorf("schedule: invalid time %q", s.At)
+	}
+	if s.Target == 0 || s.Target > 100 {
+		return fmt.Errorf("schedule: target must be between 1 and 100")
+	}
+	return nil
+}
+
+func (s Schedule) String() string {
+	return fmt.Sprintf("%d%% by %s on %v", s.Target, s.At, s.Days)
+}
+
+func (s Schedule) hasDay(d time.Weekday) bool {
+	for _, day := range s.Days {
+		if day == d {
+			return true
+		}
+	}
+	return false
+}
+
+// deadlines returns the deadlines of the schedule between from and to, in order
+func (s Schedule) deadlines(from, to time.Time) []time.Time {
+	at, err := time.Parse(timeLayout, s.At)
+	if err != nil {
+		return nil
+	}
+
+	var out []time.Time
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	for !day.After(to) {
+		if s.hasDay(day.Weekday()) {
+			d := time.Date(day.Year(), day.Month(), day.Day(), at.Hour(), at.Minute(), 0, 0, day.Location())
+			if !d.Before(from) && !d.After(to) {
+				out = append(out, d)
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return out
+}
+
+// Plan is the outcome of planning: if Active, the charge limit should be
+// raised to Target until Deadline plus the Planner's Hold
+type Plan struct {
+	Active   bool      `json:"active"`
+	Target   uint8     `json:"target"`
+	Start    time.Time `json:"start"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// Planner decides when the charge limit has to be raised
+type Planner struct {
+	// Margin is added to the estimated charging time
+	Margin time.Duration
+	// Hold keeps the limit raised after the deadline, in case we are still plugged in
+	Hold time.Duration
+	// DefaultRate (in W) is used to estimate the charging time until the rate was measured
+	DefaultRate float64
+}
+
+// DefaultPlanner starts charging 30 minutes earlier than estimated, and keeps
+// the limit raised for an hour after the deadline
+var DefaultPlanner = Planner{
+	Margin:      time.Minute * 30,
+	Hold:        time.Hour,
+	DefaultRate: 30,
+}
+
+// ChargeTime estimates how long it takes to charge from the current status to
+// target, at rate (in W)
+func (p Planner) ChargeTime(status telemetry.Status, target uint8, rate float64) time.Duration {
+	if status.Percent >= float64(target) {
+		return 0
+	}
+	if rate <= 0 {
+		rate = p.DefaultRate
+	}
+	capacity := status.FullChargeCapacity
+	if capacity <= 0 {
+		capacity = status.DesignCapacity
+	}
+	if capacity <= 0 || rate <= 0 {
+		// nothing to go by, assume a full charge takes two hours
+		return time.Duration((float64(target)-status.Percent)/100*120) * time.Minute
+	}
+	energy := (float64(target) - status.Percent) / 100 * capacity
+	return time.Duration(energy / rate * float64(time.Hour))
+}
+
+// Plan returns the plan at now. A plan that was active in prev stays active
+// until its deadline plus Hold, even if the battery charged faster than
+// estimated, so the limit does not flip back and forth. If no plan is active,
+// the next one is returned for information.
+func (p Planner) Plan(schedules []Schedule, now time.Time, status telemetry.Status, rate float64, prev Plan) Plan {
+	if prev.Active && now.Before(prev.Deadline.Add(p.Hold)) && !now.Before(prev.Start) {
+		return prev
+	}
+
+	var active, next Plan
+	for _, s := range schedules {
+		if s.Validate() != nil {
+			continue
+		}
+		needed := p.ChargeTime(status, s.Target, rate) + p.Margin
+		for _, d := range s.deadlines(now.Add(-p.Hold), now.AddDate(0, 0, 8)) {
+			plan := Plan{
+				Target:   s.Target,
+				Start:    d.Add(-needed),
+				Deadline: d,
+			}
+			if !now.Before(plan.Start) && now.Before(d.Add(p.Hold)) {
+				plan.Active = true
+				if !active.Active || plan.Target > active.Target {
+					active = plan
+				}
+				continue
+			}
+			if plan.Start.After(now) && (next.Deadline.IsZero() || plan.Start.Before(next.Start)) {
+				next = plan
+			}
+		}
+	}
+
+	if active.Active {
+		return active
+	}
+	return next
+}
+
+// MeasureRate updates the measured charge rate (in W) with the status. Only
+// readings while charging are used, and they are smoothed out.
+func MeasureRate(rate float64, status telemetry.Status) float64 {
+	if status.State != telemetry.Charging || status.Rate <= 0 {
+		return rate
+	}
+	if rate <= 0 {
+		return status.Rate
+	}
+	return rate*0.8 + status.Rate*0.2
+}
@@ -0,0 +1,105 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NeilSeligmann/G15Manager/system/battery/telemetry"
+	"github.com/stretchr/testify/require"
+)
+
+var monday = Schedule{
+	Days:   []time.Weekday{time.Monday},
+	At:     "07:30",
+	Target: 100,
+}
+
+// 2021-08-16 is a Monday
+func at(day int, hour, min int) time.Time {
+	return time.Date(2021, 8, day, hour, min, 0, 0, time.Local)
+}
+
+var halfFull = telemetry.Status{
+	State:              telemetry.Idle,
+	Percent:            60,
+	FullChargeCapacity: 80,
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, monday.Validate())
+	require.Error(t, Schedule{At: "07:30", Target: 100}.Validate())
+	require.Error(t, Schedule{Days: monday.Days, At: "7h30", Target: 100}.Validate())
+	require.Error(t, Schedule{Days: monday.Days, At: "07:30", Target: 101}.Validate())
+	require.Error(t, Schedule{Days: []time.Weekday{7}, At: "07:30", Target: 100}.Validate())
+}
+
+func TestChargeTime(t *testing.T) {
+	p := DefaultPlanner
+
+	// 40% of 80Wh is 32Wh
+	require.Equal(t, time.Hour, p.ChargeTime(halfFull, 100, 32))
+	// default rate
+	require.Equal(t, time.Minute*64, p.ChargeTime(halfFull, 100, 0))
+	// already charged
+	require.Zero(t, p.ChargeTime(halfFull, 60, 32))
+	// unknown capacity
+	require.Equal(t, time.Minute*48, p.ChargeTime(telemetry.Status{Percent: 60}, 100, 32))
+}
+
+func TestPlan(t *testing.T) {
+	p := DefaultPlanner
+	schedules := []Schedule{monday}
+
+	// Sunday evening: the next plan is reported, but not active
+	plan := p.Plan(schedules, at(15, 20, 0), halfFull, 32, Plan{})
+	require.False(t, plan.Active)
+	require.Equal(t, at(16, 7, 30), plan.Deadline)
+	require.Equal(t, at(16, 6, 0), plan.Start)
+
+	// 1 hour to charge plus 30 minutes of margin
+	plan = p.Plan(schedules, at(16, 5, 59), halfFull, 32, Plan{})
+	require.False(t, plan.Active)
+	plan = p.Plan(schedules, at(16, 6, 0), halfFull, 32, Plan{})
+	require.True(t, plan.Active)
+	require.Equal(t, uint8(100), plan.Target)
+
+	// charged faster than expected: the plan stays active
+	full := halfFull
+	full.Percent = 100
+	active := p.Plan(schedules, at(16, 6, 40), full, 32, plan)
+	require.Equal(t, plan, active)
+
+	// and is dropped after the hold
+	active = p.Plan(schedules, at(16, 8, 30), full, 32, plan)
+	require.False(t, active.Active)
+	require.Equal(t, at(23, 7, 30), active.Deadline)
+}
+
+func TestPlanAfterRestart(t *testing.T) {
+	p := DefaultPlanner
+
+	// the laptop was off (or suspended) while we should have started charging
+	plan := p.Plan([]Schedule{monday}, at(16, 7, 45), halfFull, 32, Plan{})
+	require.True(t, plan.Active)
+	require.Equal(t, at(16, 7, 30), plan.Deadline)
+}
+
+func TestPlanHighestTarget(t *testing.T) {
+	p := DefaultPlanner
+	schedules := []Schedule{
+		{Days: []time.Weekday{time.Monday}, At: "08:00", Target: 80},
+		monday,
+	}
+
+	plan := p.Plan(schedules, at(16, 7, 0), halfFull, 32, Plan{})
+	require.True(t, plan.Active)
+	require.Equal(t, uint8(100), plan.Target)
+}
+
+func TestMeasureRate(t *testing.T) {
+	charging := telemetry.Status{State: telemetry.Charging, Rate: 50}
+
+	require.Equal(t, 50.0, MeasureRate(0, charging))
+	require.Equal(t, 42.0, MeasureRate(40, charging))
+	require.Equal(t, 40.0, MeasureRate(40, telemetry.Status{State: telemetry.Discharging, Rate: -20}))
+}
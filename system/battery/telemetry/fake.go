@@ -0,0 +1,47 @@
+package telemetry
+
+import "sync"
+
+// Fake is a Provider returning the Reading (or Err) it was given. It is safe
+// for multiple goroutines.
+type Fake struct {
+	mu      sync.Mutex
+	reading Reading
+	err     error
+	reads   int
+}
+
+var _ Provider = &Fake{}
+
+// NewFake returns a Fake returning r
+func NewFake(r Reading) *Fake {
+	return &Fake{
+		reading: r,
+	}
+}
+
+// Set changes the Reading and error returned by Read
+func (f *Fake) Set(r Reading, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.reading = r
+	f.err = err
+}
+
+// Reads returns how many times Read was called
+func (f *Fake) Reads() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.reads
+}
+
+// Read satisfies Provider
+func (f *Fake) Read() (Reading, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.reads++
+	return f.reading, f.err
+}
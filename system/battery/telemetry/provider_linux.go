@@ -0,0 +1,121 @@
+package telemetry
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const powerSupplyRoot = "/sys/class/power_supply"
+
+type sysfsProvider struct {
+	root string
+}
+
+var _ Provider = &sysfsProvider{}
+
+// NewProvider returns a Provider reading from /sys/class/power_supply
+func NewProvider() Provider {
+	return &sysfsProvider{
+		root: powerSupplyRoot,
+	}
+}
+
+func (p *sysfsProvider) battery() (string, error) {
+	supplies, err := ioutil.ReadDir(p.root)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range supplies {
+		dir := filepath.Join(p.root, s.Name())
+		if t, _ := p.readString(dir, "type"); t == "Battery" {
+			return dir, nil
+		}
+	}
+	return "", ErrNoBattery
+}
+
+func (p *sysfsProvider) readString(dir, name string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// readMicro reads a value reported in micro units (µWh, µW, µV, µAh, µA)
+func (p *sysfsProvider) readMicro(dir, name string) (float64, bool) {
+	s, err := p.readString(dir, name)
+	if err != nil {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v / 1e6, true
+}
+
+func (p *sysfsProvider) Read() (Reading, error) {
+	dir, err := p.battery()
+	if err != nil {
+		return Reading{}, err
+	}
+
+	var r Reading
+	r.Voltage, _ = p.readMicro(dir, "voltage_now")
+
+	if now, ok := p.readMicro(dir, "energy_now"); ok {
+		r.RemainingCapacity = now
+		r.FullChargeCapacity, _ = p.readMicro(dir, "energy_full")
+		r.DesignCapacity, _ = p.readMicro(dir, "energy_full_design")
+		r.Rate, _ = p.readMicro(dir, "power_now")
+	} else if now, ok := p.readMicro(dir, "charge_now"); ok {
+		// charge is reported in Ah instead of Wh, convert with the design voltage
+		voltage, ok := p.readMicro(dir, "voltage_min_design")
+		if !ok {
+			voltage = r.Voltage
+		}
+		full, _ := p.readMicro(dir, "charge_full")
+		design, _ := p.readMicro(dir, "charge_full_design")
+		current, _ := p.readMicro(dir, "current_now")
+		r.RemainingCapacity = now * voltage
+		r.FullChargeCapacity = full * voltage
+		r.DesignCapacity = design * voltage
+		r.Rate = current * r.Voltage
+	} else {
+		return Reading{}, fmt.Errorf("telemetry: %s reports neither energy nor charge", dir)
+	}
+
+	if r.FullChargeCapacity <= 0 {
+		if s, err := p.readString(dir, "capacity"); err == nil {
+			r.EstimatedPercent, _ = strconv.ParseFloat(s, 64)
+		}
+	}
+
+	if s, err := p.readString(dir, "cycle_count"); err == nil {
+		r.CycleCount, _ = strconv.Atoi(s)
+	}
+
+	status, _ := p.readString(dir, "status")
+	switch status {
+	case "Charging":
+		r.State = Charging
+	case "Discharging":
+		r.State = Discharging
+		// the rate is always reported as positive
+		r.Rate = -r.Rate
+	case "Full":
+		r.State = Full
+		r.Rate = 0
+	case "Not charging":
+		r.State = Idle
+		r.Rate = 0
+	default:
+		r.State = Unknown
+	}
+
+	return r, nil
+}
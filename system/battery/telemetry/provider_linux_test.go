@@ -0,0 +1,93 @@
+package telemetry
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeSupply(t *testing.T, root, name string, files map[string]string) {
+	dir := filepath.Join(root, name)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	for f, v := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, f), []byte(v+"\n"), 0644))
+	}
+}
+
+func TestSysfsProviderEnergy(t *testing.T) {
+	root, err := ioutil.TempDir("", "power_supply")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	writeSupply(t, root, "ACAD", map[string]string{
+		"type":   "Mains",
+		"online": "0",
+	})
+	writeSupply(t, root, "BAT0", map[string]string{
+		"type":               "Battery",
+		"status":             "Discharging",
+		"energy_now":         "45000000",
+		"energy_full":        "81000000",
+		"energy_full_design": "90000000",
+		"power_now":          "15000000",
+		"voltage_now":        "15500000",
+		"cycle_count":        "120",
+	})
+
+	p := &sysfsProvider{root: root}
+	r, err := p.Read()
+	require.NoError(t, err)
+	require.Equal(t, Reading{
+		State:              Discharging,
+		RemainingCapacity:  45,
+		FullChargeCapacity: 81,
+		DesignCapacity:     90,
+		Rate:               -15,
+		Voltage:            15.5,
+		CycleCount:         120,
+	}, r)
+}
+
+func TestSysfsProviderCharge(t *testing.T) {
+	root, err := ioutil.TempDir("", "power_supply")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	writeSupply(t, root, "BAT1", map[string]string{
+		"type":               "Battery",
+		"status":             "Charging",
+		"charge_now":         "2000000",
+		"charge_full":        "4000000",
+		"charge_full_design": "5000000",
+		"current_now":        "1000000",
+		"voltage_now":        "16000000",
+		"voltage_min_design": "15000000",
+	})
+
+	p := &sysfsProvider{root: root}
+	r, err := p.Read()
+	require.NoError(t, err)
+	require.Equal(t, Charging, r.State)
+	require.Equal(t, 30.0, r.RemainingCapacity)
+	require.Equal(t, 60.0, r.FullChargeCapacity)
+	require.Equal(t, 75.0, r.DesignCapacity)
+	require.Equal(t, 16.0, r.Rate)
+}
+
+func TestSysfsProviderNoBattery(t *testing.T) {
+	root, err := ioutil.TempDir("", "power_supply")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	writeSupply(t, root, "ACAD", map[string]string{
+		"type": "Mains",
+	})
+
+	p := &sysfsProvider{root: root}
+	_, err = p.Read()
+	require.True(t, errors.Is(err, ErrNoBattery))
+}
@@ -0,0 +1,100 @@
+package telemetry
+
+import (
+	"github.com/bi-zone/wmi"
+)
+
+const wmiNamespace = `root\wmi`
+
+// The battery classes in root\wmi report capacities in mWh, rates in mW, and voltages in mV
+
+type batteryStatus struct {
+	RemainingCapacity uint32
+	ChargeRate        int32
+	DischargeRate     int32
+	Voltage           uint32
+	Charging          bool
+	Discharging       bool
+	PowerOnline       bool
+}
+
+type batteryFullChargedCapacity struct {
+	FullChargedCapacity uint32
+}
+
+type batteryStaticData struct {
+	DesignedCapacity uint32
+}
+
+type batteryCycleCount struct {
+	CycleCount uint32
+}
+
+// Win32_Battery lives in the default namespace (root\cimv2)
+type win32Battery struct {
+	EstimatedChargeRemaining uint16
+}
+
+type wmiProvider struct{}
+
+var _ Provider = wmiProvider{}
+
+// NewProvider returns a Provider reading from the battery WMI classes
+func NewProvider() Provider {
+	return wmiProvider{}
+}
+
+func (wmiProvider) Read() (Reading, error) {
+	var status []batteryStatus
+	if err := wmi.QueryNamespace("SELECT RemainingCapacity, ChargeRate, DischargeRate, Voltage, Charging, Discharging, PowerOnline FROM BatteryStatus", &status, wmiNamespace); err != nil {
+		return Reading{}, err
+	}
+	if len(status) == 0 {
+		return Reading{}, ErrNoBattery
+	}
+	s := status[0]
+
+	r := Reading{
+		RemainingCapacity: float64(s.RemainingCapacity) / 1000,
+		Voltage:           float64(s.Voltage) / 1000,
+	}
+	switch {
+	case s.Charging:
+		r.State = Charging
+		r.Rate = float64(s.ChargeRate) / 1000
+	case s.Discharging:
+		r.State = Discharging
+		r.Rate = -float64(s.DischargeRate) / 1000
+	case s.PowerOnline:
+		r.State = Idle
+	default:
+		r.State = Unknown
+	}
+
+	// the following classes are not implemented by every firmware, so errors are ignored
+	var full []batteryFullChargedCapacity
+	if err := wmi.QueryNamespace("SELECT FullChargedCapacity FROM BatteryFullChargedCapacity", &full, wmiNamespace); err == nil && len(full) > 0 {
+		r.FullChargeCapacity = float64(full[0].FullChargedCapacity) / 1000
+	}
+	var static []batteryStaticData
+	if err := wmi.QueryNamespace("SELECT DesignedCapacity FROM BatteryStaticData", &static, wmiNamespace); err == nil && len(static) > 0 {
+		r.DesignCapacity = float64(static[0].DesignedCapacity) / 1000
+	}
+	var cycles []batteryCycleCount
+	if err := wmi.QueryNamespace("SELECT CycleCount FROM BatteryCycleCount", &cycles, wmiNamespace); err == nil && len(cycles) > 0 {
+		r.CycleCount = int(cycles[0].CycleCount)
+	}
+
+	if r.FullChargeCapacity <= 0 {
+		var estimated []win32Battery
+		if err := wmi.Query("SELECT EstimatedChargeRemaining FROM Win32_Battery", &estimated); err == nil && len(estimated) > 0 {
+			r.EstimatedPercent = float64(estimated[0].EstimatedChargeRemaining)
+		}
+	}
+
+	if r.State == Idle && r.FullChargeCapacity > 0 && r.RemainingCapacity >= r.FullChargeCapacity {
+		r.State = Full
+	}
+
+	return r, nil
+}
@@ -0,0 +1,180 @@
+// Package telemetry reads the battery status (charge, rate, capacity, etc)
+// from the operating system.
+package telemetry
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrNoBattery is returned by a Provider when no battery is present
+var ErrNoBattery = errors.New("telemetry: no battery found")
+
+// State defines what the battery is doing
+type State string
+
+// Defines the battery states
+const (
+	Unknown     State = "unknown"
+	Charging    State = "charging"
+	Discharging State = "discharging"
+	// Idle is reported when the charger is plugged in, but the battery is not
+	// charging (e.g. the charge limit was reached)
+	Idle State = "idle"
+	Full State = "full"
+)
+
+// Reading is the raw battery information from a Provider. Capacities are in
+// Wh, Rate is in W (positive when charging, negative when discharging), and
+// Voltage is in V. EstimatedPercent is the charge estimated by the operating
+// system, used when the full charge capacity is not reported. Zero means the
+// value is not reported.
+type Reading struct {
+	State              State
+	RemainingCapacity  float64
+	FullChargeCapacity float64
+	DesignCapacity     float64
+	Rate               float64
+	Voltage            float64
+	CycleCount         int
+	EstimatedPercent   float64
+}
+
+// Provider reads the battery information from the operating system
+type Provider interface {
+	Read() (Reading, error)
+}
+
+// Status is the battery status reported to clients. Percent is the charge
+// relative to the full charge capacity, and Health is the full charge capacity
+// relative to the design capacity. TimeToFull and TimeToEmpty are estimated
+// from the current rate, and are zero if they do not apply. PercentKnown is
+// false if the charge could not be determined, in which case Percent is zero.
+type Status struct {
+	State              State         `json:"state"`
+	Percent            float64       `json:"percent"`
+	Rate               float64       `json:"rate"`
+	Voltage            float64       `json:"voltage"`
+	RemainingCapacity  float64       `json:"remainingCapacity"`
+	FullChargeCapacity float64       `json:"fullChargeCapacity"`
+	DesignCapacity     float64       `json:"designCapacity"`
+	Health             float64       `json:"health"`
+	CycleCount         int           `json:"cycleCount"`
+	TimeToFull         time.Duration `json:"timeToFull"`
+	TimeToEmpty        time.Duration `json:"timeToEmpty"`
+	PercentKnown       bool          `json:"percentKnown"`
+}
+
+// NewStatus computes the Status from a Reading
+func NewStatus(r Reading) Status {
+	s := Status{
+		State:              r.State,
+		Rate:               round(r.Rate, 2),
+		Voltage:            round(r.Voltage, 2),
+		RemainingCapacity:  round(r.RemainingCapacity, 2),
+		FullChargeCapacity: round(r.FullChargeCapacity, 2),
+		DesignCapacity:     round(r.DesignCapacity, 2),
+		CycleCount:         r.CycleCount,
+	}
+	if s.State == "" {
+		s.State = Unknown
+	}
+	switch {
+	case r.FullChargeCapacity > 0:
+		s.Percent = round(math.Min(r.RemainingCapacity/r.FullChargeCapacity*100, 100), 1)
+		s.PercentKnown = true
+	case r.EstimatedPercent > 0:
+		s.Percent = round(math.Min(r.EstimatedPercent, 100), 1)
+		s.PercentKnown = true
+	}
+	if r.DesignCapacity > 0 && r.FullChargeCapacity > 0 {
+		s.Health = round(r.FullChargeCapacity/r.DesignCapacity*100, 1)
+	}
+	switch {
+	case r.Rate > 0 && r.FullChargeCapacity > r.RemainingCapacity:
+		s.TimeToFull = hours((r.FullChargeCapacity - r.RemainingCapacity) / r.Rate)
+	case r.Rate < 0 && r.RemainingCapacity > 0:
+		s.TimeToEmpty = hours(r.RemainingCapacity / -r.Rate)
+	}
+	return s
+}
+
+// WithLimit returns the status with TimeToFull estimated to the charge limit
+// (in %) instead of a full charge. It is zero once the limit is reached.
+func (s Status) WithLimit(limit uint8) Status {
+	if s.Rate <= 0 || s.FullChargeCapacity <= 0 {
+		return s
+	}
+	target := s.FullChargeCapacity * float64(limit) / 100
+	s.TimeToFull = 0
+	if target > s.RemainingCapacity {
+		s.TimeToFull = hours((target - s.RemainingCapacity) / s.Rate)
+	}
+	return s
+}
+
+func hours(h float64) time.Duration {
+	return (time.Duration(h*3600) * time.Second).Round(time.Minute)
+}
+
+func round(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
+
+// Service caches the battery status, so it can be requested by every client
+// without hitting the Provider each time. The Service is safe for multiple goroutines.
+type Service struct {
+	provider Provider
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	status  Status
+	err     error
+	updated time.Time
+}
+
+// NewService returns a Service reading from provider at most once per ttl
+func NewService(provider Provider, ttl time.Duration) (*Service, error) {
+	if provider == nil {
+		return nil, errors.New("telemetry: nil Provider is invalid")
+	}
+	return &Service{
+		provider: provider,
+		ttl:      ttl,
+		now:      time.Now,
+	}, nil
+}
+
+// Status returns the battery status, reading it again if the cached status is
+// older than the ttl
+func (s *Service) Status() (Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if !s.updated.IsZero() && now.Sub(s.updated) < s.ttl {
+		return s.status, s.err
+	}
+
+	r, err := s.provider.Read()
+	s.updated = now
+	s.err = err
+	if err != nil {
+		s.status = Status{}
+	} else {
+		s.status = NewStatus(r)
+	}
+	return s.status, s.err
+}
+
+// Invalidate discards the cached status, e.g. after the charger was plugged in
+func (s *Service) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.updated = time.Time{}
+}
@@ -0,0 +1,108 @@
+package telemetry
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStatus(t *testing.T) {
+	s := NewStatus(Reading{
+		State:              Discharging,
+		RemainingCapacity:  45,
+		FullChargeCapacity: 81,
+		DesignCapacity:     90,
+		Rate:               -15,
+		Voltage:            15.512,
+		CycleCount:         120,
+	})
+	require.Equal(t, Status{
+		State:              Discharging,
+		Percent:            55.6,
+		Rate:               -15,
+		Voltage:            15.51,
+		RemainingCapacity:  45,
+		FullChargeCapacity: 81,
+		DesignCapacity:     90,
+		Health:             90,
+		CycleCount:         120,
+		TimeToEmpty:        time.Hour * 3,
+		PercentKnown:       true,
+	}, s)
+
+	s = NewStatus(Reading{
+		State:              Charging,
+		RemainingCapacity:  40,
+		FullChargeCapacity: 80,
+		Rate:               60,
+	})
+	require.Equal(t, time.Minute*40, s.TimeToFull)
+	require.Zero(t, s.TimeToEmpty)
+	require.Zero(t, s.Health)
+
+	// estimated to the charge limit
+	require.Equal(t, time.Minute*20, s.WithLimit(75).TimeToFull)
+	require.Equal(t, time.Minute*40, s.WithLimit(100).TimeToFull)
+	require.Zero(t, s.WithLimit(50).TimeToFull)
+	require.Zero(t, s.WithLimit(40).TimeToFull)
+	require.Equal(t, 50.0, s.WithLimit(75).Percent)
+
+	s = NewStatus(Reading{})
+	require.Equal(t, Unknown, s.State)
+	require.Zero(t, s.Percent)
+	require.False(t, s.PercentKnown)
+
+	// the full charge capacity is not reported, fall back to the estimate
+	s = NewStatus(Reading{State: Discharging, RemainingCapacity: 30, EstimatedPercent: 42})
+	require.Equal(t, 42.0, s.Percent)
+	require.True(t, s.PercentKnown)
+	require.Zero(t, s.Health)
+}
+
+func TestService(t *testing.T) {
+	_, err := NewService(nil, time.Second)
+	require.Error(t, err)
+
+	f := NewFake(Reading{
+		State:              Idle,
+		RemainingCapacity:  48,
+		FullChargeCapacity: 80,
+	})
+	s, err := NewService(f, time.Second*5)
+	require.NoError(t, err)
+
+	clock := time.Unix(0, 0)
+	s.now = func() time.Time {
+		return clock
+	}
+
+	status, err := s.Status()
+	require.NoError(t, err)
+	require.Equal(t, 60.0, status.Percent)
+
+	// cached
+	f.Set(Reading{State: Idle, RemainingCapacity: 80, FullChargeCapacity: 80}, nil)
+	clock = clock.Add(time.Second * 4)
+	status, err = s.Status()
+	require.NoError(t, err)
+	require.Equal(t, 60.0, status.Percent)
+	require.Equal(t, 1, f.Reads())
+
+	// expired
+	clock = clock.Add(time.Second)
+	status, err = s.Status()
+	require.NoError(t, err)
+	require.Equal(t, 100.0, status.Percent)
+	require.Equal(t, 2, f.Reads())
+
+	// errors are cached as well
+	f.Set(Reading{}, ErrNoBattery)
+	s.Invalidate()
+	_, err = s.Status()
+	require.True(t, errors.Is(err, ErrNoBattery))
+	_, err = s.Status()
+	require.True(t, errors.Is(err, ErrNoBattery))
+	require.Equal(t, 3, f.Reads())
+}
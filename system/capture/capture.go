@@ -0,0 +1,184 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/NeilSeligmann/G15Manager/system/keyboard"
+)
+
+// Source defines where a captured event came from
+type Source string
+
+// Defines the sources that can be captured
+const (
+	SourceHID  Source = "hid"
+	SourceACPI Source = "acpi"
+)
+
+// Event is a single captured HID report or ACPI event. Captures are stored
+// as one JSON encoded Event per line.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Source  Source    `json:"source"`
+	Report  string    `json:"report,omitempty"`  // hex encoded raw HID report
+	EventID uint32    `json:"eventId,omitempty"` // ACPI event ID
+}
+
+// Recorder writes captured events to a file. The Recorder is safe for multiple goroutines.
+type Recorder struct {
+	mu  sync.Mutex
+	w   io.WriteCloser
+	enc *json.Encoder
+	now func() time.Time
+}
+
+// NewRecorder returns a Recorder writing to w
+func NewRecorder(w io.WriteCloser) *Recorder {
+	return &Recorder{
+		w:   w,
+		enc: json.NewEncoder(w),
+		now: time.Now,
+	}
+}
+
+// Create will create (or truncate) the file at path and return a Recorder writing to it
+func Create(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("capture: cannot create capture file: %w", err)
+	}
+	return NewRecorder(f), nil
+}
+
+// RecordReport records a raw HID report
+func (r *Recorder) RecordReport(report []byte) {
+	r.record(Event{
+		Source: SourceHID,
+		Report: hex.EncodeToString(report),
+	})
+}
+
+// RecordACPI records an ACPI event ID
+func (r *Recorder) RecordACPI(eventID uint32) {
+	r.record(Event{
+		Source:  SourceACPI,
+		EventID: eventID,
+	})
+}
+
+func (r *Recorder) record(ev Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.enc == nil {
+		return
+	}
+	ev.Time = r.now()
+	// capturing is best effort, we should not interrupt the controller because of it
+	r.enc.Encode(ev)
+}
+
+// Close will stop recording and close the underlying file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.enc == nil {
+		return nil
+	}
+	r.enc = nil
+	return r.w.Close()
+}
+
+// Read returns all the events from a capture
+func Read(rd io.Reader) ([]Event, error) {
+	events := make([]Event, 0)
+	scanner := bufio.NewScanner(rd)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return nil, fmt.Errorf("capture: invalid event on line %d: %w", line, err)
+		}
+		events = append(events, ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Replay feeds the events from a capture into the key code and ACPI channels,
+// the same way the HID and ACPI listeners would. The recorded timing is kept,
+// scaled by speed (2 replays twice as fast). If speed is 0, events are sent
+// without delay.
+func Replay(haltCtx context.Context, rd io.Reader, keyCodeCh, acpiCh chan<- uint32, speed float64) error {
+	events, err := Read(rd)
+	if err != nil {
+		return err
+	}
+
+	var last time.Time
+	for i, ev := range events {
+		if speed > 0 && i > 0 {
+			wait := time.Duration(float64(ev.Time.Sub(last)) / speed)
+			if wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-haltCtx.Done():
+					return nil
+				}
+			}
+		}
+		last = ev.Time
+
+		var ch chan<- uint32
+		var value uint32
+		switch ev.Source {
+		case SourceHID:
+			report, err := hex.DecodeString(ev.Report)
+			if err != nil {
+				return fmt.Errorf("capture: invalid report in event %d: %w", i, err)
+			}
+			keyCode, ok := keyboard.ParseReport(report)
+			if !ok {
+				continue
+			}
+			ch, value = keyCodeCh, keyCode
+		case SourceACPI:
+			ch, value = acpiCh, ev.EventID
+		default:
+			return fmt.Errorf("capture: unknown source \"%s\" in event %d", ev.Source, i)
+		}
+
+		select {
+		case ch <- value:
+		case <-haltCtx.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// ReplayFile is like Replay, but reads the capture from the file at path
+func ReplayFile(haltCtx context.Context, path string, keyCodeCh, acpiCh chan<- uint32, speed float64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("capture: cannot open capture file: %w", err)
+	}
+	defer f.Close()
+
+	return Replay(haltCtx, f, keyCodeCh, acpiCh, speed)
+}
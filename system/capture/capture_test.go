@@ -0,0 +1,84 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NeilSeligmann/G15Manager/system/keyboard"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	buf := &bytes.Buffer{}
+	r := NewRecorder(nopCloser{buf})
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time {
+		now = now.Add(time.Millisecond * 10)
+		return now
+	}
+
+	r.RecordReport([]byte{0x5a, byte(keyboard.KeyROG), 0, 0, 0, 0})
+	r.RecordReport([]byte{0x5a, 0x00, 0, 0, 0, 0}) // not a key press
+	r.RecordACPI(123)
+	r.RecordReport([]byte{0x5a, 0x99, 0, 0, 0, 0}) // unknown key
+	require.NoError(t, r.Close())
+
+	events, err := Read(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+	require.Equal(t, SourceHID, events[0].Source)
+	require.Equal(t, "5a3800000000", events[0].Report)
+	require.Equal(t, uint32(123), events[2].EventID)
+	require.Equal(t, time.Millisecond*10, events[1].Time.Sub(events[0].Time))
+
+	keyCodeCh := make(chan uint32, 10)
+	acpiCh := make(chan uint32, 10)
+	require.NoError(t, Replay(context.Background(), bytes.NewReader(buf.Bytes()), keyCodeCh, acpiCh, 0))
+
+	require.Len(t, keyCodeCh, 2)
+	require.Equal(t, keyboard.KeyROG, <-keyCodeCh)
+	require.Equal(t, uint32(0x99), <-keyCodeCh)
+	require.Len(t, acpiCh, 1)
+	require.Equal(t, uint32(123), <-acpiCh)
+}
+
+func TestReplayTiming(t *testing.T) {
+	capture := `{"time":"2021-06-01T12:00:00Z","source":"acpi","eventId":123}
+{"time":"2021-06-01T12:00:01Z","source":"acpi","eventId":233}
+`
+	acpiCh := make(chan uint32, 2)
+	start := time.Now()
+	require.NoError(t, Replay(context.Background(), bytes.NewBufferString(capture), nil, acpiCh, 20))
+	require.True(t, time.Since(start) >= time.Millisecond*50)
+	require.Equal(t, uint32(123), <-acpiCh)
+	require.Equal(t, uint32(233), <-acpiCh)
+}
+
+func TestReplayHalt(t *testing.T) {
+	capture := `{"time":"2021-06-01T12:00:00Z","source":"acpi","eventId":123}
+{"time":"2021-06-01T13:00:00Z","source":"acpi","eventId":233}
+`
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	acpiCh := make(chan uint32, 2)
+	require.NoError(t, Replay(ctx, bytes.NewBufferString(capture), nil, acpiCh, 1))
+	require.Len(t, acpiCh, 1)
+}
+
+func TestReadInvalid(t *testing.T) {
+	_, err := Read(bytes.NewBufferString("{\"source\":\"hid\"}\nnot json\n"))
+	require.Error(t, err)
+
+	err = Replay(context.Background(), bytes.NewBufferString(`{"source":"usb"}`), nil, nil, 0)
+	require.Error(t, err)
+}
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error { return nil }
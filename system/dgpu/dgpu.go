@@ -0,0 +1,160 @@
+// Package dgpu defines how the dedicated GPU device is queried and switched,
+// so the plugin logic does not depend on SetupAPI.
+package dgpu
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Errors returned by a Device when it is already in the requested state. They
+// are not failures, and only need to be reported to the user.
+var (
+	ErrAlreadyEnabled  = errors.New("GPU is already enabled")
+	ErrAlreadyDisabled = errors.New("GPU is already disabled")
+)
+
+// State defines the state of the dedicated GPU device
+type State int
+
+// Defines the device states
+const (
+	Unknown State = iota
+	Enabled
+	Disabled
+	NotPresent
+	// Error means the device is present, but Device Manager reports a problem
+	// (e.g. the driver failed to start)
+	Error
+)
+
+func (s State) String() string {
+	switch s {
+	case Enabled:
+		return "enabled"
+	case Disabled:
+		return "disabled"
+	case NotPresent:
+		return "notPresent"
+	case Error:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText satisfies encoding.TextMarshaler
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Status is the state of the device. Problem is the Device Manager problem
+// code (CM_PROB_*) if the device is in the Error state.
+type Status struct {
+	State   State  `json:"state"`
+	Problem uint32 `json:"problem,omitempty"`
+}
+
+func (s Status) String() string {
+	if s.State == Error {
+		return fmt.Sprintf("%s (problem %d)", s.State, s.Problem)
+	}
+	return s.State.String()
+}
+
+// Device is the dedicated GPU
+type Device interface {
+	// Enable starts the device, or returns ErrAlreadyEnabled
+	Enable() error
+	// Disable stops the device, or returns ErrAlreadyDisabled
+	Disable() error
+	// Status returns the current state of the device
+	Status() (Status, error)
+}
+
+// IsInvalidState returns true if err means the device was already in the
+// requested state
+func IsInvalidState(err error) bool {
+	return errors.Is(err, ErrAlreadyEnabled) || errors.Is(err, ErrAlreadyDisabled)
+}
+
+// Restart disables and enables the device. A device that was already disabled
+// is only enabled.
+func Restart(d Device) error {
+	if err := d.Disable(); err != nil && !errors.Is(err, ErrAlreadyDisabled) {
+		return err
+	}
+	return d.Enable()
+}
+
+// Fake is a Device for tests and dry runs, which only keeps the state in memory.
+// The Fake is safe for multiple goroutines.
+type Fake struct {
+	mu     sync.Mutex
+	status Status
+	err    error
+	calls  []string
+}
+
+var _ Device = &Fake{}
+
+// NewFake returns a Fake in the given state
+func NewFake(s Status) *Fake {
+	return &Fake{
+		status: s,
+	}
+}
+
+// Set replaces the state, and the error returned by all the calls
+func (f *Fake) Set(s Status, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.status = s
+	f.err = err
+}
+
+// Calls returns the Enable and Disable calls made so far
+func (f *Fake) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.calls...)
+}
+
+// Enable satisfies Device
+func (f *Fake) Enable() error {
+	return f.set(Enabled, ErrAlreadyEnabled)
+}
+
+// Disable satisfies Device
+func (f *Fake) Disable() error {
+	return f.set(Disabled, ErrAlreadyDisabled)
+}
+
+func (f *Fake) set(target State, already error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, target.String())
+	if f.err != nil {
+		return f.err
+	}
+	switch f.status.State {
+	case target:
+		return already
+	case NotPresent:
+		return errors.New("cannot find the GPU")
+	}
+	f.status = Status{State: target}
+	return nil
+}
+
+// Status satisfies Device
+func (f *Fake) Status() (Status, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.status, f.err
+}
@@ -0,0 +1,58 @@
+package dgpu
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFake(t *testing.T) {
+	f := NewFake(Status{State: Enabled})
+
+	err := f.Enable()
+	require.True(t, IsInvalidState(err))
+	require.NoError(t, f.Disable())
+	require.True(t, errors.Is(f.Disable(), ErrAlreadyDisabled))
+
+	s, err := f.Status()
+	require.NoError(t, err)
+	require.Equal(t, Disabled, s.State)
+
+	f.Set(Status{State: NotPresent}, nil)
+	err = f.Enable()
+	require.Error(t, err)
+	require.False(t, IsInvalidState(err))
+
+	require.Equal(t, []string{"enabled", "disabled", "disabled", "enabled"}, f.Calls())
+}
+
+func TestRestart(t *testing.T) {
+	f := NewFake(Status{State: Enabled})
+	require.NoError(t, Restart(f))
+	require.Equal(t, []string{"disabled", "enabled"}, f.Calls())
+
+	// already disabled, only enabled
+	f = NewFake(Status{State: Disabled})
+	require.NoError(t, Restart(f))
+	require.Equal(t, []string{"disabled", "enabled"}, f.Calls())
+
+	s, _ := f.Status()
+	require.Equal(t, Enabled, s.State)
+
+	f.Set(Status{State: Enabled}, errors.New("setupapi failure"))
+	require.Error(t, Restart(f))
+}
+
+func TestStatusJSON(t *testing.T) {
+	b, err := json.Marshal(Status{State: Error, Problem: 43})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"state":"error","problem":43}`, string(b))
+
+	b, err = json.Marshal(Status{State: NotPresent})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"state":"notPresent"}`, string(b))
+
+	require.Equal(t, "error (problem 43)", Status{State: Error, Problem: 43}.String())
+}
@@ -0,0 +1,54 @@
+package dgpu
+
+import (
+	"strings"
+	"unsafe"
+
+	"github.com/NeilSeligmann/G15Manager/system/display"
+	"golang.org/x/sys/windows"
+)
+
+type systemEnvironment struct{}
+
+var _ Environment = &systemEnvironment{}
+
+// NewEnvironment returns the Environment of the running system. Only NVIDIA
+// adapters are considered as the GPU.
+func NewEnvironment() Environment {
+	return &systemEnvironment{}
+}
+
+func (e *systemEnvironment) Processes() ([]string, error) {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(snapshot)
+
+	var entry windows.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+
+	var processes []string
+	for err = windows.Process32First(snapshot, &entry); err == nil; err = windows.Process32Next(snapshot, &entry) {
+		processes = append(processes, windows.UTF16ToString(entry.ExeFile[:]))
+	}
+	if err != windows.ERROR_NO_MORE_FILES {
+		return nil, err
+	}
+	return processes, nil
+}
+
+// ExternalDisplay satisfies Environment. On Optimus laptops the NVIDIA adapter
+// is only attached to the desktop when a display is wired to it.
+func (e *systemEnvironment) ExternalDisplay() (bool, error) {
+	t, err := display.CurrentTopology()
+	if err != nil {
+		return false, err
+	}
+	for _, o := range t.Outputs {
+		if strings.Contains(strings.ToUpper(o.Adapter), "NVIDIA") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,135 @@
+package dgpu
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const maxGrace = time.Hour
+
+// Policy defines when the GPU is disabled automatically on battery. The GPU is
+// disabled once Grace has passed since the charger was unplugged, unless an
+// app in Allowlist is running or an external display is attached to the GPU,
+// in which case it is deferred. It is enabled again when the charger is plugged in.
+// Grace is encoded in JSON in seconds.
+type Policy struct {
+	Enabled   bool
+	Grace     time.Duration
+	Allowlist []string
+}
+
+type policyJSON struct {
+	Enabled   bool     `json:"enabled"`
+	Grace     int64    `json:"grace"`
+	Allowlist []string `json:"allowlist"`
+}
+
+// MarshalJSON encodes the grace period in seconds
+func (p Policy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(policyJSON{
+		Enabled:   p.Enabled,
+		Grace:     int64(p.Grace / time.Second),
+		Allowlist: p.Allowlist,
+	})
+}
+
+// UnmarshalJSON decodes the grace period in seconds
+func (p *Policy) UnmarshalJSON(b []byte) error {
+	v := policyJSON{
+		Enabled:   p.Enabled,
+		Grace:     int64(p.Grace / time.Second),
+		Allowlist: p.Allowlist,
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	p.Enabled = v.Enabled
+	p.Grace = time.Duration(v.Grace) * time.Second
+	p.Allowlist = v.Allowlist
+	return nil
+}
+
+// DefaultPolicy is disabled, with a grace period of a minute
+var DefaultPolicy = Policy{
+	Grace: time.Minute,
+}
+
+// Validate returns an error if the policy cannot be used
+func (p Policy) Validate() error {
+	if p.Grace < 0 || p.Grace > maxGrace {
+		return fmt.Errorf("grace period must be between 0 and %s", maxGrace)
+	}
+	for _, app := range p.Allowlist {
+		if strings.TrimSpace(app) == "" {
+			return errors.New("allowlist cannot contain an empty app")
+		}
+	}
+	return nil
+}
+
+// Environment reports what is using the GPU
+type Environment interface {
+	// Processes returns the executable names of the running processes
+	Processes() ([]string, error)
+	// ExternalDisplay returns true if a display is attached to the GPU
+	ExternalDisplay() (bool, error)
+}
+
+// Check returns why the GPU should not be disabled now, or an empty string if
+// it can be
+func (p Policy) Check(env Environment) (string, error) {
+	external, err := env.ExternalDisplay()
+	if err != nil {
+		return "", err
+	}
+	if external {
+		return "an external display is attached", nil
+	}
+
+	if len(p.Allowlist) == 0 {
+		return "", nil
+	}
+	processes, err := env.Processes()
+	if err != nil {
+		return "", err
+	}
+	for _, proc := range processes {
+		for _, app := range p.Allowlist {
+			if appName(proc) == appName(app) {
+				return fmt.Sprintf("%s is running", proc), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// appName normalizes "C:\Apps\OBS64.exe" and "obs64" to "obs64"
+func appName(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if i := strings.LastIndexAny(s, `\/`); i >= 0 {
+		s = s[i+1:]
+	}
+	return strings.TrimSuffix(s, ".exe")
+}
+
+// Action is what the policy did
+type Action string
+
+// Defines the policy actions
+const (
+	ActionDisabled Action = "disabled"
+	ActionEnabled  Action = "enabled"
+	ActionDeferred Action = "deferred"
+	ActionSkipped  Action = "skipped"
+	ActionFailed   Action = "failed"
+)
+
+// Decision records what the policy did and why
+type Decision struct {
+	Time   time.Time `json:"time"`
+	Action Action    `json:"action"`
+	Reason string    `json:"reason,omitempty"`
+}
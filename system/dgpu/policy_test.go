@@ -0,0 +1,83 @@
+package dgpu
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeEnvironment struct {
+	processes []string
+	external  bool
+	err       error
+}
+
+func (f *fakeEnvironment) Processes() ([]string, error) {
+	return f.processes, f.err
+}
+
+func (f *fakeEnvironment) ExternalDisplay() (bool, error) {
+	return f.external, f.err
+}
+
+func TestPolicyValidate(t *testing.T) {
+	require.NoError(t, DefaultPolicy.Validate())
+	require.Error(t, Policy{Grace: -time.Second}.Validate())
+	require.Error(t, Policy{Grace: time.Hour * 2}.Validate())
+	require.Error(t, Policy{Allowlist: []string{" "}}.Validate())
+}
+
+func TestPolicyJSON(t *testing.T) {
+	p := Policy{
+		Enabled:   true,
+		Grace:     time.Minute * 5,
+		Allowlist: []string{"obs64"},
+	}
+
+	b, err := json.Marshal(p)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"enabled":true,"grace":300,"allowlist":["obs64"]}`, string(b))
+
+	var loaded Policy
+	require.NoError(t, json.Unmarshal(b, &loaded))
+	require.Equal(t, p, loaded)
+
+	require.Error(t, json.Unmarshal([]byte(`{"grace":"5m"}`), &loaded))
+}
+
+func TestPolicyCheck(t *testing.T) {
+	p := Policy{
+		Enabled:   true,
+		Allowlist: []string{"OBS64", `C:\Games\game.exe`},
+	}
+	env := &fakeEnvironment{
+		processes: []string{"explorer.exe", "chrome.exe"},
+	}
+
+	reason, err := p.Check(env)
+	require.NoError(t, err)
+	require.Empty(t, reason)
+
+	env.processes = append(env.processes, "obs64.exe")
+	reason, err = p.Check(env)
+	require.NoError(t, err)
+	require.Equal(t, "obs64.exe is running", reason)
+
+	env.processes = []string{"Game.exe"}
+	reason, err = p.Check(env)
+	require.NoError(t, err)
+	require.NotEmpty(t, reason)
+
+	env.processes = nil
+	env.external = true
+	reason, err = p.Check(env)
+	require.NoError(t, err)
+	require.Equal(t, "an external display is attached", reason)
+
+	env.err = errors.New("access denied")
+	_, err = p.Check(env)
+	require.Error(t, err)
+}
@@ -0,0 +1,118 @@
+// Package display defines the refresh rate control of the internal panel, so
+// the plugins can be tested with a fake panel.
+package display
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrUnsupportedRate is returned if the panel does not support the refresh rate
+// at the current resolution
+var ErrUnsupportedRate = errors.New("display: unsupported refresh rate")
+
+// RefreshRater reads and changes the refresh rate of the internal panel. Rates
+// are in Hz, and 0 is returned if the rate cannot be read or changed.
+type RefreshRater interface {
+	// GetCurrent returns the current refresh rate
+	GetCurrent() int
+	// GetLowest returns the lowest supported refresh rate
+	GetLowest() int
+	// ListRefreshRates returns the supported refresh rates at the current
+	// resolution, in ascending order
+	ListRefreshRates() []int
+	// SetRefreshRate changes the refresh rate, and returns it
+	SetRefreshRate(rate int) int
+	// CycleRefreshRate changes to the next supported refresh rate, and returns it
+	CycleRefreshRate() int
+	// Release frees the resources held for the panel
+	Release()
+}
+
+// SetRefreshRate validates the refresh rate against the supported rates
+// before changing it
+func SetRefreshRate(d RefreshRater, rate int) error {
+	if !Supports(d, rate) {
+		return fmt.Errorf("%w: %d Hz (supported: %v)", ErrUnsupportedRate, rate, d.ListRefreshRates())
+	}
+	if d.SetRefreshRate(rate) == 0 {
+		return fmt.Errorf("display: unable to change the refresh rate to %d Hz", rate)
+	}
+	return nil
+}
+
+// Supports returns true if the refresh rate is supported
+func Supports(d RefreshRater, rate int) bool {
+	for _, r := range d.ListRefreshRates() {
+		if r == rate {
+			return true
+		}
+	}
+	return false
+}
+
+// Fake is a RefreshRater for tests
+type Fake struct {
+	Current int
+	Rates   []int
+	// Sets counts the refresh rate changes
+	Sets     int
+	Released bool
+}
+
+var _ RefreshRater = &Fake{}
+
+// NewFake returns a Fake at the current refresh rate
+func NewFake(current int, rates ...int) *Fake {
+	sorted := append([]int(nil), rates...)
+	sort.Ints(sorted)
+	return &Fake{
+		Current: current,
+		Rates:   sorted,
+	}
+}
+
+func (f *Fake) GetCurrent() int {
+	return f.Current
+}
+
+func (f *Fake) GetLowest() int {
+	if len(f.Rates) == 0 {
+		return 0
+	}
+	return f.Rates[0]
+}
+
+func (f *Fake) ListRefreshRates() []int {
+	return append([]int(nil), f.Rates...)
+}
+
+func (f *Fake) SetRefreshRate(rate int) int {
+	for _, r := range f.Rates {
+		if r == rate {
+			f.Current = rate
+			f.Sets++
+			return rate
+		}
+	}
+	return 0
+}
+
+func (f *Fake) CycleRefreshRate() int {
+	if len(f.Rates) == 0 {
+		return 0
+	}
+	next := f.Rates[0]
+	for _, r := range f.Rates {
+		if r > f.Current {
+			next = r
+			break
+		}
+	}
+	return f.SetRefreshRate(next)
+}
+
+func (f *Fake) Release() {
+	f.Released = true
+}
@@ -0,0 +1,131 @@
+package display
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetRefreshRate(t *testing.T) {
+	d := NewFake(165, 165, 60)
+	require.Equal(t, []int{60, 165}, d.ListRefreshRates())
+	require.Equal(t, 60, d.GetLowest())
+
+	err := SetRefreshRate(d, 120)
+	require.True(t, errors.Is(err, ErrUnsupportedRate))
+	require.Equal(t, 0, d.Sets)
+
+	require.NoError(t, SetRefreshRate(d, 60))
+	require.Equal(t, 60, d.GetCurrent())
+
+	require.Equal(t, 165, d.CycleRefreshRate())
+	require.Equal(t, 60, d.CycleRefreshRate())
+}
+
+func TestPowerRates(t *testing.T) {
+	p := PowerRates{PluggedIn: 165, Unplugged: 60}
+	require.NoError(t, p.Validate())
+	require.Equal(t, 0, p.Target(true))
+
+	p.Enabled = true
+	require.Equal(t, 165, p.Target(true))
+	require.Equal(t, 60, p.Target(false))
+
+	p.Unplugged = 0
+	require.Equal(t, 0, p.Target(false))
+
+	p.PluggedIn = -1
+	require.Error(t, p.Validate())
+}
+
+func TestTopologyDiff(t *testing.T) {
+	internal := Output{Name: `\\.\DISPLAY1`, Adapter: "AMD Radeon(TM) Graphics", Primary: true}
+	external := Output{Name: `\\.\DISPLAY2`, Adapter: "NVIDIA GeForce RTX 3070 Laptop GPU"}
+	before := Topology{Outputs: []Output{internal}}
+
+	_, changed := Diff(before, before)
+	require.False(t, changed)
+
+	after := Topology{Outputs: []Output{internal, external}}
+	change, changed := Diff(before, after)
+	require.True(t, changed)
+	require.Equal(t, []string{`\\.\DISPLAY2`}, change.Attached)
+	require.Empty(t, change.Detached)
+	require.False(t, change.PrimaryChanged)
+
+	internal.Primary, external.Primary = false, true
+	swapped := Topology{Outputs: []Output{internal, external}}
+	change, changed = Diff(after, swapped)
+	require.True(t, changed)
+	require.True(t, change.PrimaryChanged)
+	require.Equal(t, `\\.\DISPLAY2`, change.Topology.Primary())
+
+	change, changed = Diff(swapped, Topology{})
+	require.True(t, changed)
+	require.Len(t, change.Detached, 2)
+	require.Equal(t, "", change.Topology.Primary())
+}
+
+func TestTopologyWatch(t *testing.T) {
+	topologies := make(chan Topology, 3)
+	topologies <- Topology{}
+	topologies <- Topology{}
+	topologies <- Topology{Outputs: []Output{{Name: `\\.\DISPLAY1`, Primary: true}}}
+	read := func() (Topology, error) {
+		select {
+		case t := <-topologies:
+			return t, nil
+		default:
+			return Topology{}, errors.New("no more topologies")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := make(chan Change)
+	go Watch(ctx, read, time.Millisecond, ch)
+
+	select {
+	case change := <-ch:
+		require.Equal(t, []string{`\\.\DISPLAY1`}, change.Attached)
+		require.True(t, change.PrimaryChanged)
+	case <-time.After(time.Second):
+		t.Fatal("no change received")
+	}
+}
+
+func TestTopologyWatchInitialError(t *testing.T) {
+	internal := Output{Name: `\\.\DISPLAY1`, Primary: true}
+	external := Output{Name: `\\.\DISPLAY2`}
+
+	reads := make(chan func() (Topology, error), 3)
+	reads <- func() (Topology, error) { return Topology{}, errors.New("not ready") }
+	reads <- func() (Topology, error) { return Topology{Outputs: []Output{internal}}, nil }
+	reads <- func() (Topology, error) { return Topology{Outputs: []Output{internal, external}}, nil }
+	read := func() (Topology, error) {
+		select {
+		case r := <-reads:
+			return r()
+		default:
+			return Topology{}, errors.New("no more topologies")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := make(chan Change)
+	go Watch(ctx, read, time.Millisecond, ch)
+
+	select {
+	case change := <-ch:
+		require.Equal(t, []string{`\\.\DISPLAY2`}, change.Attached)
+		require.False(t, change.PrimaryChanged)
+	case <-time.After(time.Second):
+		t.Fatal("no change received")
+	}
+}
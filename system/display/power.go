@@ -0,0 +1,32 @@
+package display
+
+import "fmt"
+
+// PowerRates are the refresh rates to switch to when the charger is plugged
+// in or unplugged. A rate of 0 leaves the refresh rate alone.
+type PowerRates struct {
+	Enabled   bool `json:"enabled"`
+	PluggedIn int  `json:"pluggedIn"`
+	Unplugged int  `json:"unplugged"`
+}
+
+// Validate returns an error if a rate is negative. Whether the panel supports
+// the rates is only known once it is active.
+func (p PowerRates) Validate() error {
+	if p.PluggedIn < 0 || p.Unplugged < 0 {
+		return fmt.Errorf("display: invalid refresh rates %d/%d Hz", p.PluggedIn, p.Unplugged)
+	}
+	return nil
+}
+
+// Target returns the refresh rate for the power source, or 0 if it should be
+// left alone
+func (p PowerRates) Target(pluggedIn bool) int {
+	if !p.Enabled {
+		return 0
+	}
+	if pluggedIn {
+		return p.PluggedIn
+	}
+	return p.Unplugged
+}
@@ -0,0 +1,113 @@
+package display
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Output is a display output attached to the desktop
+type Output struct {
+	// Name is the GDI device name, e.g. \\.\DISPLAY1
+	Name string `json:"name"`
+	// Adapter is the name of the graphics adapter driving the output
+	Adapter string `json:"adapter"`
+	Primary bool   `json:"primary"`
+}
+
+// Topology is the set of outputs attached to the desktop
+type Topology struct {
+	Outputs []Output `json:"outputs"`
+}
+
+// Primary returns the name of the primary output, or "" if there is none
+func (t Topology) Primary() string {
+	for _, o := range t.Outputs {
+		if o.Primary {
+			return o.Name
+		}
+	}
+	return ""
+}
+
+func (t Topology) names() map[string]bool {
+	names := make(map[string]bool, len(t.Outputs))
+	for _, o := range t.Outputs {
+		names[o.Name] = true
+	}
+	return names
+}
+
+// Change describes how the topology changed
+type Change struct {
+	Topology       Topology `json:"topology"`
+	Attached       []string `json:"attached"`
+	Detached       []string `json:"detached"`
+	PrimaryChanged bool     `json:"primaryChanged"`
+}
+
+// Diff returns the change from previous to current, and false if nothing
+// changed
+func Diff(previous, current Topology) (Change, bool) {
+	c := Change{
+		Topology:       current,
+		PrimaryChanged: previous.Primary() != current.Primary(),
+	}
+
+	before, after := previous.names(), current.names()
+	for _, o := range current.Outputs {
+		if !before[o.Name] {
+			c.Attached = append(c.Attached, o.Name)
+		}
+	}
+	for _, o := range previous.Outputs {
+		if !after[o.Name] {
+			c.Detached = append(c.Detached, o.Name)
+		}
+	}
+
+	changed := c.PrimaryChanged || len(c.Attached) > 0 || len(c.Detached) > 0
+	return c, changed
+}
+
+// Watch polls the topology every interval until ctx is done, and sends the
+// changes to ch. Errors are logged, and the topology is read again on the next
+// poll. Nothing is sent until the first successful read, so the displays
+// attached at startup are not reported as changes.
+func Watch(ctx context.Context, read func() (Topology, error), interval time.Duration, ch chan<- Change) {
+	previous, err := read()
+	known := err == nil
+	if err != nil {
+		log.Printf("display: unable to read display topology: %s\n", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			current, err := read()
+			if err != nil {
+				log.Printf("display: unable to read display topology: %s\n", err)
+				continue
+			}
+			if !known {
+				previous, known = current, true
+				continue
+			}
+			change, ok := Diff(previous, current)
+			previous = current
+			if !ok {
+				continue
+			}
+			select {
+			case ch <- change:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
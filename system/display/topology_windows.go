@@ -0,0 +1,66 @@
+package display
+
+import (
+	"context"
+	"log"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	displayDeviceAttachedToDesktop = 0x1
+	displayDevicePrimaryDevice     = 0x4
+
+	// displays are rarely plugged, so polling is cheap enough
+	topologyInterval = time.Second * 2
+)
+
+var (
+	user32                  = windows.NewLazySystemDLL("user32.dll")
+	procEnumDisplayDevicesW = user32.NewProc("EnumDisplayDevicesW")
+)
+
+// displayDevice is DISPLAY_DEVICEW
+type displayDevice struct {
+	Cb           uint32
+	DeviceName   [32]uint16
+	DeviceString [128]uint16
+	StateFlags   uint32
+	DeviceID     [128]uint16
+	DeviceKey    [128]uint16
+}
+
+// CurrentTopology returns the outputs attached to the desktop
+func CurrentTopology() (Topology, error) {
+	var t Topology
+	for i := uint32(0); ; i++ {
+		var dev displayDevice
+		dev.Cb = uint32(unsafe.Sizeof(dev))
+		ret, _, _ := procEnumDisplayDevicesW.Call(0, uintptr(i), uintptr(unsafe.Pointer(&dev)), 0)
+		if ret == 0 {
+			return t, nil
+		}
+		if dev.StateFlags&displayDeviceAttachedToDesktop == 0 {
+			continue
+		}
+		t.Outputs = append(t.Outputs, Output{
+			Name:    windows.UTF16ToString(dev.DeviceName[:]),
+			Adapter: windows.UTF16ToString(dev.DeviceString[:]),
+			Primary: dev.StateFlags&displayDevicePrimaryDevice != 0,
+		})
+	}
+}
+
+// NewTopologyListener will watch for displays being attached, detached or
+// made primary, and send the changes to the channel
+func NewTopologyListener(haltCtx context.Context, changeCh chan Change) error {
+	go func() {
+		log.Println("display: watching display topology")
+		Watch(haltCtx, CurrentTopology, topologyInterval, changeCh)
+		log.Println("display: stopped watching display topology")
+	}()
+
+	return nil
+}
@@ -0,0 +1,582 @@
+// Package gpu enables and disables the dedicated GPU as a plugin. The device
+// itself is driven with SetupAPI in cxx/plugin/gpu.
+package gpu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/NeilSeligmann/G15Manager/system/battery/lowbattery"
+	"github.com/NeilSeligmann/G15Manager/system/dgpu"
+	"github.com/NeilSeligmann/G15Manager/system/gpumode"
+	"github.com/NeilSeligmann/G15Manager/system/nvidia"
+	"github.com/NeilSeligmann/G15Manager/system/persist"
+	"github.com/NeilSeligmann/G15Manager/system/plugin"
+	"github.com/NeilSeligmann/G15Manager/util"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	persistKey = "GPUAutoDisable"
+	// how often a deferred automatic disable is tried again
+	autoDisableRetry = time.Minute
+)
+
+// Control enables and disables the dedicated GPU, and optionally disables it
+// on battery according to a dgpu.Policy. The controller is safe for multiple
+// goroutines.
+type Control struct {
+	dryRun bool
+	device dgpu.Device
+	env    dgpu.Environment
+	smi    *nvidia.SMI
+	// modes is nil if the GPU modes are not supported
+	modes *gpumode.Control
+
+	// mu serializes the calls to the device, which take a while to settle
+	mu sync.Mutex
+	// disabledOnBattery is set if we disabled the GPU on battery (low battery
+	// or the policy), so it should be enabled again once charging
+	disabledOnBattery bool
+	policy            dgpu.Policy
+	lastDecision      dgpu.Decision
+	autoPending       bool
+
+	queue   chan plugin.Notification
+	errChan chan error
+}
+
+var _ plugin.Plugin = &Control{}
+
+// NewGPUControl returns a Control for the NVIDIA GPU device. If dryRun is set,
+// device should be a dgpu.Fake, and the apps using the GPU are not checked.
+// modes may be nil if the model does not support the GPU modes.
+func NewGPUControl(dryRun bool, device dgpu.Device, modes *gpumode.Control, smi *nvidia.SMI) (*Control, error) {
+	if device == nil {
+		return nil, errors.New("nil Device is invalid")
+	}
+	if smi == nil {
+		return nil, errors.New("nil SMI is invalid")
+	}
+
+	return &Control{
+		dryRun:  dryRun,
+		device:  device,
+		env:     dgpu.NewEnvironment(),
+		smi:     smi,
+		modes:   modes,
+		policy:  dgpu.DefaultPolicy,
+		queue:   make(chan plugin.Notification),
+		errChan: make(chan error),
+	}, nil
+}
+
+// RestartGPU disables and enables the GPU, e.g. to recover from a driver error
+func (c *Control) RestartGPU() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.disabledOnBattery = false
+	c.autoPending = false
+	return dgpu.Restart(c.device)
+}
+
+// DisableGPU disables the GPU. dgpu.ErrAlreadyDisabled is returned if it is
+// not enabled. Unless force is set, the GPU is not disabled while apps are
+// using it, and an error listing them is returned.
+func (c *Control) DisableGPU(force bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !force {
+		if err := c.checkInUse(); err != nil {
+			return err
+		}
+	}
+	c.disabledOnBattery = false
+	c.autoPending = false
+	return c.device.Disable()
+}
+
+// Processes returns the processes currently using the GPU. The list is empty
+// if the GPU is not enabled or in Eco mode, so nvidia-smi does not wake it up.
+func (c *Control) Processes() ([]nvidia.Process, error) {
+	status, err := c.Status()
+	if err != nil || status.State != dgpu.Enabled {
+		return nil, err
+	}
+	if c.modes != nil {
+		if s, err := c.modes.Status(); err == nil && s.Mode == gpumode.Eco {
+			return nil, nil
+		}
+	}
+	return c.smi.Processes(context.Background())
+}
+
+// Mode returns the GPU mode
+func (c *Control) Mode() (gpumode.Status, error) {
+	if c.modes == nil {
+		return gpumode.Status{}, gpumode.ErrUnsupported
+	}
+	return c.modes.Status()
+}
+
+// SetMode switches the GPU mode. Switching to or from Ultimate requires a reboot.
+func (c *Control) SetMode(m gpumode.Mode) error {
+	if c.modes == nil {
+		return gpumode.ErrUnsupported
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if m == gpumode.Eco {
+		// the firmware disables the GPU regardless of the apps using it
+		if err := c.checkInUse(); err != nil {
+			return err
+		}
+	}
+	return c.modes.SetMode(m)
+}
+
+// checkInUse returns a gpuInUseError if apps are using the GPU, and a
+// gpuRefusedError if the GPU drives the internal panel. If nvidia-smi cannot
+// tell, e.g. because it is not installed, the GPU is not considered in use.
+// Caller must hold c.mu.
+func (c *Control) checkInUse() error {
+	if c.modes != nil {
+		if s, err := c.modes.Status(); err == nil && s.Mode == gpumode.Ultimate {
+			return gpuRefusedError{msg: "GPU drives the display in Ultimate mode"}
+		}
+	}
+	if c.dryRun {
+		return nil
+	}
+	if status, err := c.device.Status(); err != nil || status.State != dgpu.Enabled {
+		// let the device report it
+		return nil
+	}
+
+	c.smi.Invalidate()
+	processes, err := c.smi.Processes(context.Background())
+	if err != nil {
+		log.Printf("gpu: unable to list processes using the GPU: %s\n", err)
+		return nil
+	}
+	if len(processes) > 0 {
+		return gpuInUseError{processes: processes}
+	}
+	return nil
+}
+
+// EnableGPU enables the GPU. dgpu.ErrAlreadyEnabled is returned if it is not disabled.
+func (c *Control) EnableGPU() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.disabledOnBattery = false
+	c.autoPending = false
+	return c.device.Enable()
+}
+
+// Status returns whether the GPU is enabled, disabled, missing or in an error state
+func (c *Control) Status() (dgpu.Status, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.device.Status()
+}
+
+// disableOnBattery disables the GPU on low battery. It returns false if there
+// is nothing to do.
+func (c *Control) disableOnBattery() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.disabledOnBattery {
+		return false, nil
+	}
+	if err := c.checkInUse(); err != nil {
+		return true, err
+	}
+	err := c.device.Disable()
+	// if it was already disabled, it was not us
+	c.disabledOnBattery = err == nil
+	return true, err
+}
+
+// restoreOnCharger enables the GPU again if it was disabled on low battery. It
+// returns false if there is nothing to do.
+func (c *Control) restoreOnCharger() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.disabledOnBattery {
+		return false, nil
+	}
+	c.disabledOnBattery = false
+	return true, c.device.Enable()
+}
+
+// Policy returns the automatic disable policy
+func (c *Control) Policy() dgpu.Policy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.policy
+}
+
+// SetPolicy replaces the automatic disable policy. It takes effect the next
+// time the charger is unplugged.
+func (c *Control) SetPolicy(p dgpu.Policy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.policy = p
+	return nil
+}
+
+// LastDecision returns what the automatic disable policy last did
+func (c *Control) LastDecision() dgpu.Decision {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lastDecision
+}
+
+// decide records the decision of the policy. Caller must hold c.mu.
+func (c *Control) decide(action dgpu.Action, reason string) {
+	if reason != "" {
+		log.Printf("gpu: auto disable %s: %s\n", action, reason)
+	} else {
+		log.Printf("gpu: auto disable %s\n", action)
+	}
+	c.lastDecision = dgpu.Decision{
+		Time:   time.Now(),
+		Action: action,
+		Reason: reason,
+	}
+}
+
+// unplugged returns the grace period before the GPU is disabled, and false if
+// the policy is not enabled
+func (c *Control) unplugged() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.policy.Enabled {
+		return 0, false
+	}
+	c.autoPending = true
+	return c.policy.Grace, true
+}
+
+// autoDisable disables the GPU unless the policy says otherwise. It returns
+// true if it should be tried again later, and false with a nil error if there
+// was nothing to do.
+func (c *Control) autoDisable() (retry bool, changed bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.autoPending {
+		return false, false, nil
+	}
+
+	reason, err := c.policy.Check(c.env)
+	if err != nil {
+		c.decide(dgpu.ActionDeferred, fmt.Sprintf("cannot check the apps and displays: %s", err))
+		return true, false, nil
+	}
+	if reason != "" {
+		c.decide(dgpu.ActionDeferred, reason)
+		return true, false, nil
+	}
+	if err := c.checkInUse(); err != nil {
+		c.decide(dgpu.ActionDeferred, err.Error())
+		return true, false, nil
+	}
+
+	c.autoPending = false
+	err = c.device.Disable()
+	switch {
+	case err == nil:
+		c.disabledOnBattery = true
+		c.decide(dgpu.ActionDisabled, "")
+	case dgpu.IsInvalidState(err):
+		c.decide(dgpu.ActionSkipped, err.Error())
+		return false, false, nil
+	default:
+		c.decide(dgpu.ActionFailed, err.Error())
+	}
+	return false, true, err
+}
+
+// pluggedIn cancels a pending automatic disable, and enables the GPU again if
+// it was disabled on battery. It returns false if there is nothing to do.
+func (c *Control) pluggedIn() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.autoPending = false
+	if !c.disabledOnBattery {
+		return false, nil
+	}
+	c.disabledOnBattery = false
+
+	err := c.device.Enable()
+	if c.policy.Enabled {
+		if err != nil {
+			c.decide(dgpu.ActionFailed, err.Error())
+		} else {
+			c.decide(dgpu.ActionEnabled, "")
+		}
+	}
+	return true, err
+}
+
+func (c *Control) Initialize() error {
+	if c.dryRun {
+		log.Println("gpu: dry run, GPU state is not controlled")
+	}
+	return nil
+}
+
+func (c *Control) loop(haltCtx context.Context, cb chan<- plugin.Callback) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("gpu: loop panic %+v\n", err)
+			c.errChan <- err.(error)
+		}
+	}()
+
+	// fires once the grace period passed after unplugging, or to retry a
+	// deferred disable
+	var autoDisable <-chan time.Time
+
+	for {
+		var action string
+		var err error
+
+		select {
+		case <-autoDisable:
+			autoDisable = nil
+			retry, changed, autoErr := c.autoDisable()
+			if retry {
+				autoDisable = time.After(autoDisableRetry)
+			}
+			cb <- plugin.Callback{
+				Event: plugin.CbNotifyClients,
+			}
+			if !changed {
+				continue
+			}
+			action = "disable"
+			err = autoErr
+		case evt := <-c.queue:
+			switch evt.Event {
+			case plugin.EvtChargerUnplugged:
+				if grace, ok := c.unplugged(); ok {
+					autoDisable = time.After(grace)
+				}
+				continue
+			case plugin.EvtChargerPluggedIn:
+				autoDisable = nil
+				var changed bool
+				if changed, err = c.pluggedIn(); !changed {
+					continue
+				}
+				action = "enable"
+			case plugin.EvtSentinelEnableGPU:
+				action = "enable"
+				err = c.EnableGPU()
+			case plugin.EvtSentinelDisableGPU:
+				action = "disable"
+				err = c.DisableGPU(false)
+			case plugin.EvtBatteryLow:
+				alert, ok := evt.Value.(lowbattery.Alert)
+				if !ok || !alert.Has(lowbattery.DisableGPU) {
+					continue
+				}
+				var changed bool
+				if changed, err = c.disableOnBattery(); !changed {
+					continue
+				}
+				action = "disable"
+			case plugin.EvtBatteryRestored:
+				var changed bool
+				if changed, err = c.restoreOnCharger(); !changed {
+					continue
+				}
+				action = "enable"
+			}
+		case <-haltCtx.Done():
+			log.Println("gpu: exiting Plugin run loop")
+			return
+		}
+
+		n := util.Notification{}
+
+		if err != nil && !dgpu.IsInvalidState(err) && !isRefused(err) {
+			n.Message = fmt.Sprintf("Unable to %s GPU. Please check log for more details", action)
+			cb <- plugin.Callback{
+				Event: plugin.CbNotifyToast,
+				Value: n,
+			}
+			c.errChan <- err
+			continue
+		} else if err != nil {
+			n.Message = err.Error()
+			cb <- plugin.Callback{
+				Event: plugin.CbNotifyToast,
+				Value: n,
+			}
+		} else {
+			n.Message = fmt.Sprintf("GPU %sd", action)
+			cb <- plugin.Callback{
+				Event: plugin.CbNotifyToast,
+				Value: n,
+			}
+		}
+		cb <- plugin.Callback{
+			Event: plugin.CbNotifyClients,
+		}
+	}
+}
+
+func (c *Control) Run(haltCtx context.Context, cb chan<- plugin.Callback) <-chan error {
+	log.Println("gpu: Starting queue loop")
+
+	go c.loop(haltCtx, cb)
+
+	return c.errChan
+}
+
+func (c *Control) Notify(t plugin.Notification) {
+	switch t.Event {
+	case plugin.EvtSentinelEnableGPU, plugin.EvtSentinelDisableGPU,
+		plugin.EvtBatteryLow, plugin.EvtBatteryRestored,
+		plugin.EvtChargerUnplugged, plugin.EvtChargerPluggedIn:
+	default:
+		return
+	}
+
+	c.queue <- t
+}
+
+func (c *Control) GetWSInfo() gin.H {
+	info := gin.H{
+		"status": dgpu.Status{},
+		"error":  nil,
+	}
+
+	status, err := c.Status()
+	if err != nil {
+		log.Printf("gpu: unable to query GPU state: %s\n", err)
+		info["error"] = err.Error()
+	} else {
+		info["status"] = status
+	}
+
+	processes, err := c.Processes()
+	if err != nil {
+		log.Printf("gpu: unable to list processes using the GPU: %s\n", err)
+	}
+	if processes == nil {
+		processes = []nvidia.Process{}
+	}
+	info["processes"] = processes
+
+	info["mode"] = nil
+	if mode, err := c.Mode(); err == nil {
+		info["mode"] = mode
+	} else if !errors.Is(err, gpumode.ErrUnsupported) {
+		log.Printf("gpu: unable to query GPU mode: %s\n", err)
+	}
+
+	c.mu.Lock()
+	info["disabledOnBattery"] = c.disabledOnBattery
+	info["autoDisable"] = gin.H{
+		"policy":       c.policy,
+		"pending":      c.autoPending,
+		"lastDecision": c.lastDecision,
+	}
+	c.mu.Unlock()
+
+	return info
+}
+
+func (c *Control) HandleWSMessage(ws *websocket.Conn, action int, value string) {
+	var err error
+	switch action {
+	// Enable GPU
+	case 0:
+		err = c.EnableGPU()
+	// Disable GPU, value "force" disables it even if apps are using it
+	case 1:
+		err = c.DisableGPU(value == "force")
+	// Restart GPU
+	case 2:
+		err = c.RestartGPU()
+	// Set Auto Disable Policy
+	case 3:
+		var p dgpu.Policy
+		if err = json.Unmarshal([]byte(value), &p); err == nil {
+			err = c.SetPolicy(p)
+		}
+	// Set GPU Mode (eco/standard/ultimate)
+	case 4:
+		var m gpumode.Mode
+		if err = m.UnmarshalText([]byte(value)); err == nil {
+			err = c.SetMode(m)
+		}
+	}
+	if err != nil {
+		log.Printf("gpu: websocket action %d failed: %s\n", action, err)
+	}
+}
+
+var _ persist.Registry = &Control{}
+
+// Name satisfies persist.Registry
+func (c *Control) Name() string {
+	return persistKey
+}
+
+// Value satisfies persist.Registry
+func (c *Control) Value() []byte {
+	b, _ := json.Marshal(c.Policy())
+	return b
+}
+
+// Load satisfies persist.Registry
+func (c *Control) Load(v []byte) error {
+	if len(v) == 0 {
+		return nil
+	}
+
+	var p dgpu.Policy
+	if err := json.Unmarshal(v, &p); err != nil {
+		return err
+	}
+	return c.SetPolicy(p)
+}
+
+// Apply satisfies persist.Registry
+func (c *Control) Apply() error {
+	return nil
+}
+
+// Close satisfied persist.Registry
+func (c *Control) Close() error {
+	return nil
+}
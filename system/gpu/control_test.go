@@ -0,0 +1,387 @@
+package gpu
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NeilSeligmann/G15Manager/system/atkacpi"
+	"github.com/NeilSeligmann/G15Manager/system/battery/lowbattery"
+	"github.com/NeilSeligmann/G15Manager/system/dgpu"
+	"github.com/NeilSeligmann/G15Manager/system/gpumode"
+	"github.com/NeilSeligmann/G15Manager/system/model"
+	"github.com/NeilSeligmann/G15Manager/system/nvidia"
+	"github.com/NeilSeligmann/G15Manager/system/plugin"
+	"github.com/NeilSeligmann/G15Manager/util"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEnvironment reports the running processes and whether an external
+// display is attached
+type fakeEnvironment struct {
+	mu        sync.Mutex
+	processes []string
+	external  bool
+	err       error
+}
+
+func (f *fakeEnvironment) set(processes []string, external bool, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.processes, f.external, f.err = processes, external, err
+}
+
+func (f *fakeEnvironment) Processes() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.processes, f.err
+}
+
+func (f *fakeEnvironment) ExternalDisplay() (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.external, f.err
+}
+
+// fakeRunner answers the nvidia-smi process queries with the compute apps in
+// computeApps
+type fakeRunner struct {
+	mu          sync.Mutex
+	computeApps string
+	runs        int
+}
+
+func (f *fakeRunner) set(computeApps string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.computeApps = computeApps
+}
+
+func (f *fakeRunner) Run(ctx context.Context, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.runs++
+	if strings.HasPrefix(args[0], "--query-compute-apps") {
+		return []byte(f.computeApps), nil
+	}
+	return nil, nil
+}
+
+// fakeWMI reports the GPU MUX device, so the GPU mode can be switched to Ultimate
+type fakeWMI struct {
+	mux uint32
+}
+
+func (f *fakeWMI) Evaluate(id atkacpi.Method, args []byte) ([]byte, error) {
+	status := make([]byte, 4)
+	if id == atkacpi.DSTS && binary.LittleEndian.Uint32(args[0:]) == atkacpi.DevsGPUMux {
+		binary.LittleEndian.PutUint32(status, 0x10000|f.mux)
+	}
+	return status, nil
+}
+
+func (f *fakeWMI) Close() error {
+	return nil
+}
+
+var enabledPolicy = dgpu.Policy{
+	Enabled: true,
+}
+
+func newTestControl(t *testing.T, state dgpu.State, modes *gpumode.Control) (*Control, *dgpu.Fake, *fakeEnvironment, *fakeRunner) {
+	device := dgpu.NewFake(dgpu.Status{State: state})
+	runner := &fakeRunner{}
+	c, err := NewGPUControl(false, device, modes, nvidia.NewSMI(runner, 0, 0))
+	require.NoError(t, err)
+
+	env := &fakeEnvironment{}
+	c.env = env
+	return c, device, env, runner
+}
+
+func TestNewGPUControl(t *testing.T) {
+	smi := nvidia.NewSMI(&fakeRunner{}, 0, 0)
+	_, err := NewGPUControl(false, nil, nil, smi)
+	require.Error(t, err)
+	_, err = NewGPUControl(false, dgpu.NewFake(dgpu.Status{}), nil, nil)
+	require.Error(t, err)
+}
+
+func TestAutoDisable(t *testing.T) {
+	c, device, _, _ := newTestControl(t, dgpu.Enabled, nil)
+
+	// nothing happens unless the policy is enabled
+	_, ok := c.unplugged()
+	require.False(t, ok)
+	retry, changed, err := c.autoDisable()
+	require.False(t, retry)
+	require.False(t, changed)
+	require.NoError(t, err)
+
+	require.NoError(t, c.SetPolicy(enabledPolicy))
+	_, ok = c.unplugged()
+	require.True(t, ok)
+
+	retry, changed, err = c.autoDisable()
+	require.False(t, retry)
+	require.True(t, changed)
+	require.NoError(t, err)
+	require.True(t, c.disabledOnBattery)
+	require.False(t, c.autoPending)
+	require.Equal(t, dgpu.ActionDisabled, c.LastDecision().Action)
+
+	changed, err = c.pluggedIn()
+	require.True(t, changed)
+	require.NoError(t, err)
+	require.False(t, c.disabledOnBattery)
+	require.Equal(t, dgpu.ActionEnabled, c.LastDecision().Action)
+	require.Equal(t, []string{"disabled", "enabled"}, device.Calls())
+
+	// nothing to restore the second time
+	changed, err = c.pluggedIn()
+	require.False(t, changed)
+	require.NoError(t, err)
+}
+
+func TestAutoDisableDeferred(t *testing.T) {
+	c, device, env, runner := newTestControl(t, dgpu.Enabled, nil)
+	require.NoError(t, c.SetPolicy(dgpu.Policy{
+		Enabled:   true,
+		Allowlist: []string{"obs64"},
+	}))
+	c.unplugged()
+
+	env.set(nil, true, nil)
+	retry, changed, err := c.autoDisable()
+	require.True(t, retry)
+	require.False(t, changed)
+	require.NoError(t, err)
+	require.Equal(t, dgpu.Decision{Action: dgpu.ActionDeferred, Reason: "an external display is attached"}, withoutTime(c.LastDecision()))
+
+	env.set([]string{`C:\Apps\OBS64.exe`}, false, nil)
+	retry, _, _ = c.autoDisable()
+	require.True(t, retry)
+	require.Equal(t, `C:\Apps\OBS64.exe is running`, c.LastDecision().Reason)
+
+	env.set(nil, false, errors.New("access denied"))
+	retry, _, _ = c.autoDisable()
+	require.True(t, retry)
+	require.Contains(t, c.LastDecision().Reason, "access denied")
+
+	// apps using the GPU are not crashed
+	env.set(nil, false, nil)
+	runner.set("5812, blender.exe, 1024\n")
+	retry, _, _ = c.autoDisable()
+	require.True(t, retry)
+	require.Equal(t, "GPU is in use by blender.exe", c.LastDecision().Reason)
+	require.Empty(t, device.Calls())
+	require.True(t, c.autoPending)
+
+	runner.set("")
+	retry, changed, err = c.autoDisable()
+	require.False(t, retry)
+	require.True(t, changed)
+	require.NoError(t, err)
+	require.Equal(t, []string{"disabled"}, device.Calls())
+}
+
+func TestAutoDisableCancelled(t *testing.T) {
+	c, device, _, _ := newTestControl(t, dgpu.Enabled, nil)
+	require.NoError(t, c.SetPolicy(enabledPolicy))
+
+	c.unplugged()
+	changed, err := c.pluggedIn()
+	require.False(t, changed)
+	require.NoError(t, err)
+
+	retry, changed, err := c.autoDisable()
+	require.False(t, retry)
+	require.False(t, changed)
+	require.NoError(t, err)
+	require.Empty(t, device.Calls())
+}
+
+func TestAutoDisableNotOurs(t *testing.T) {
+	c, device, _, _ := newTestControl(t, dgpu.Disabled, nil)
+	require.NoError(t, c.SetPolicy(enabledPolicy))
+
+	// the GPU was already disabled by the user, so it is not enabled on charger
+	c.unplugged()
+	retry, changed, err := c.autoDisable()
+	require.False(t, retry)
+	require.False(t, changed)
+	require.NoError(t, err)
+	require.Equal(t, dgpu.ActionSkipped, c.LastDecision().Action)
+	require.False(t, c.disabledOnBattery)
+
+	changed, err = c.pluggedIn()
+	require.False(t, changed)
+	require.NoError(t, err)
+	require.Equal(t, []string{"disabled"}, device.Calls())
+}
+
+func TestAutoDisableFailure(t *testing.T) {
+	c, device, _, _ := newTestControl(t, dgpu.Enabled, nil)
+	require.NoError(t, c.SetPolicy(enabledPolicy))
+
+	c.unplugged()
+	device.Set(dgpu.Status{State: dgpu.Enabled}, errors.New("cannot disable gpu"))
+	retry, changed, err := c.autoDisable()
+	require.False(t, retry)
+	require.True(t, changed)
+	require.Error(t, err)
+	require.Equal(t, dgpu.ActionFailed, c.LastDecision().Action)
+	require.False(t, c.disabledOnBattery)
+}
+
+func TestLowBattery(t *testing.T) {
+	c, device, _, runner := newTestControl(t, dgpu.Enabled, nil)
+
+	runner.set("5812, blender.exe, 1024\n")
+	changed, err := c.disableOnBattery()
+	require.True(t, changed)
+	require.True(t, isRefused(err))
+	require.False(t, c.disabledOnBattery)
+
+	runner.set("")
+	changed, err = c.disableOnBattery()
+	require.True(t, changed)
+	require.NoError(t, err)
+	require.True(t, c.disabledOnBattery)
+
+	// only once per discharge
+	changed, err = c.disableOnBattery()
+	require.False(t, changed)
+	require.NoError(t, err)
+
+	changed, err = c.restoreOnCharger()
+	require.True(t, changed)
+	require.NoError(t, err)
+	changed, err = c.restoreOnCharger()
+	require.False(t, changed)
+	require.NoError(t, err)
+	require.Equal(t, []string{"disabled", "enabled"}, device.Calls())
+
+	// a manual change takes over
+	_, err = c.disableOnBattery()
+	require.NoError(t, err)
+	require.NoError(t, c.EnableGPU())
+	changed, _ = c.restoreOnCharger()
+	require.False(t, changed)
+}
+
+func TestDisableGPURefused(t *testing.T) {
+	wmi := &fakeWMI{mux: 1}
+	modes, err := gpumode.NewControl(wmi, &model.Model{
+		Devices: model.Devices{
+			GPUMux: atkacpi.DevsGPUMux,
+		},
+	})
+	require.NoError(t, err)
+
+	c, device, _, runner := newTestControl(t, dgpu.Enabled, modes)
+
+	runner.set("5812, blender.exe, 1024\n")
+	err = c.DisableGPU(false)
+	require.True(t, isRefused(err))
+	require.Equal(t, "GPU is in use by blender.exe", err.Error())
+	require.Empty(t, device.Calls())
+
+	require.NoError(t, c.DisableGPU(true))
+	require.NoError(t, c.EnableGPU())
+
+	// the GPU drives the panel in Ultimate, even forcing would blank it
+	runner.set("")
+	wmi.mux = 0
+	err = c.DisableGPU(false)
+	require.True(t, isRefused(err))
+	require.Equal(t, "GPU drives the display in Ultimate mode", err.Error())
+	require.Equal(t, []string{"disabled", "enabled"}, device.Calls())
+
+	require.True(t, dgpu.IsInvalidState(c.EnableGPU()))
+	require.False(t, isRefused(errors.New("cannot disable gpu")))
+}
+
+func TestProcesses(t *testing.T) {
+	c, device, _, runner := newTestControl(t, dgpu.Enabled, nil)
+
+	runner.set("5812, blender.exe, 1024\n")
+	processes, err := c.Processes()
+	require.NoError(t, err)
+	require.Len(t, processes, 1)
+	require.Equal(t, 2, runner.runs)
+
+	// nvidia-smi would wake up the GPU
+	device.Set(dgpu.Status{State: dgpu.Disabled}, nil)
+	processes, err = c.Processes()
+	require.NoError(t, err)
+	require.Empty(t, processes)
+	require.Equal(t, 2, runner.runs)
+}
+
+func TestControlLoop(t *testing.T) {
+	c, device, _, runner := newTestControl(t, dgpu.Enabled, nil)
+	require.NoError(t, c.SetPolicy(enabledPolicy))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cb := make(chan plugin.Callback, 16)
+	errChan := c.Run(ctx, cb)
+
+	c.Notify(plugin.Notification{Event: plugin.EvtChargerUnplugged})
+	require.Equal(t, "GPU disabled", nextToast(t, cb))
+	require.Equal(t, []string{"disabled"}, device.Calls())
+
+	c.Notify(plugin.Notification{Event: plugin.EvtChargerPluggedIn})
+	require.Equal(t, "GPU enabled", nextToast(t, cb))
+
+	// a refusal is reported, but does not restart the controller
+	runner.set("5812, blender.exe, 1024\n")
+	c.Notify(plugin.Notification{Event: plugin.EvtBatteryLow, Value: lowbattery.Alert{
+		Actions: []lowbattery.Action{lowbattery.DisableGPU},
+	}})
+	require.Equal(t, "GPU is in use by blender.exe", nextToast(t, cb))
+
+	c.Notify(plugin.Notification{Event: plugin.EvtSentinelEnableGPU})
+	require.Equal(t, "GPU is already enabled", nextToast(t, cb))
+
+	// a failure does
+	device.Set(dgpu.Status{State: dgpu.Enabled}, errors.New("cannot disable gpu"))
+	c.Notify(plugin.Notification{Event: plugin.EvtSentinelDisableGPU})
+	select {
+	case err := <-errChan:
+		require.EqualError(t, err, "cannot disable gpu")
+	case <-time.After(time.Second):
+		t.Fatal("no error received")
+	}
+}
+
+// nextToast returns the message of the next toast sent by the loop
+func nextToast(t *testing.T, cb <-chan plugin.Callback) string {
+	for {
+		select {
+		case c := <-cb:
+			if c.Event == plugin.CbNotifyToast {
+				return c.Value.(util.Notification).Message
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no toast received")
+			return ""
+		}
+	}
+}
+
+func withoutTime(d dgpu.Decision) dgpu.Decision {
+	d.Time = time.Time{}
+	return d
+}
@@ -0,0 +1,44 @@
+package gpu
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/NeilSeligmann/G15Manager/system/nvidia"
+)
+
+// gpuInUseError is returned when the GPU is not disabled because apps are
+// still using it. Disabling it anyway would crash them.
+type gpuInUseError struct {
+	processes []nvidia.Process
+}
+
+var _ error = &gpuInUseError{}
+
+func (g gpuInUseError) Error() string {
+	names := make([]string, 0, len(g.processes))
+	for _, p := range g.processes {
+		names = append(names, p.ExeName())
+	}
+	return fmt.Sprintf("GPU is in use by %s", strings.Join(names, ", "))
+}
+
+// gpuRefusedError is returned when the GPU is not disabled because it is not
+// safe in the current GPU mode
+type gpuRefusedError struct {
+	msg string
+}
+
+var _ error = &gpuRefusedError{}
+
+func (g gpuRefusedError) Error() string {
+	return g.msg
+}
+
+// isRefused returns true if the GPU was left alone on purpose
+func isRefused(err error) bool {
+	var inUse gpuInUseError
+	var refused gpuRefusedError
+	return errors.As(err, &inUse) || errors.As(err, &refused)
+}
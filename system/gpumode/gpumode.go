@@ -0,0 +1,356 @@
+// Package gpumode switches between the Eco, Standard and Ultimate GPU modes
+// with the dGPU disable and GPU MUX devices of the firmware.
+package gpumode
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/NeilSeligmann/G15Manager/system/atkacpi"
+	"github.com/NeilSeligmann/G15Manager/system/model"
+	"github.com/NeilSeligmann/G15Manager/system/persist"
+	"golang.org/x/sys/windows"
+)
+
+const persistKey = "GPUModePending"
+
+// dstsPresent is set in the DSTS result if the device is supported
+const dstsPresent = 0x10000
+
+// the boot time is derived from the uptime, so it drifts a little
+const bootTimeTolerance = time.Minute
+
+// Mode is a GPU mode
+type Mode int
+
+// Defines the GPU modes
+const (
+	Unknown Mode = iota
+	// Eco disables the dGPU in firmware
+	Eco
+	// Standard is the hybrid mode, the dGPU renders for the iGPU when needed
+	Standard
+	// Ultimate routes the internal panel to the dGPU with the MUX
+	Ultimate
+)
+
+var modeNames = [...]string{"unknown", "eco", "standard", "ultimate"}
+
+func (m Mode) String() string {
+	if m < Unknown || m > Ultimate {
+		return modeNames[Unknown]
+	}
+	return modeNames[m]
+}
+
+// MarshalText satisfies encoding.TextMarshaler
+func (m Mode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText satisfies encoding.TextUnmarshaler
+func (m *Mode) UnmarshalText(text []byte) error {
+	for i, name := range modeNames {
+		if name == string(text) {
+			*m = Mode(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("gpumode: unknown mode %q", text)
+}
+
+var (
+	// ErrUnsupported is returned if the model has neither the dGPU disable nor the MUX
+	ErrUnsupported = errors.New("gpumode: GPU modes are not supported on this model")
+	// ErrRebootRequired is returned if a MUX change is waiting for a reboot
+	ErrRebootRequired = errors.New("gpumode: a GPU mode change is pending, please reboot first")
+)
+
+// TransitionError is returned when switching between two modes is not safe
+// or not possible
+type TransitionError struct {
+	From   Mode
+	To     Mode
+	Reason string
+}
+
+func (t *TransitionError) Error() string {
+	return fmt.Sprintf("gpumode: cannot switch from %s to %s: %s", t.From, t.To, t.Reason)
+}
+
+// Status is the active GPU mode. MUX changes only take effect after a reboot,
+// in which case Pending is the mode after the reboot.
+type Status struct {
+	Mode         Mode `json:"mode"`
+	Pending      Mode `json:"pending"`
+	EcoSupported bool `json:"ecoSupported"`
+	MuxSupported bool `json:"muxSupported"`
+}
+
+// RebootRequired returns true if a mode change is waiting for a reboot
+func (s Status) RebootRequired() bool {
+	return s.Pending != Unknown
+}
+
+// Control reads and switches the GPU mode. The controller is safe for
+// multiple goroutines.
+type Control struct {
+	wmi     atkacpi.WMI
+	devices model.Devices
+	// bootTime returns when the system was booted, so a pending change is
+	// forgotten once it has been applied by a reboot
+	bootTime func() time.Time
+
+	mu      sync.Mutex
+	pending Mode
+}
+
+var _ persist.Registry = &Control{}
+
+// NewControl returns a Control using the device IDs of the model
+func NewControl(wmi atkacpi.WMI, m *model.Model) (*Control, error) {
+	if wmi == nil {
+		return nil, errors.New("nil WMI is invalid")
+	}
+	if m == nil {
+		return nil, errors.New("nil model is invalid")
+	}
+	return &Control{
+		wmi:     wmi,
+		devices: m.Devices,
+		bootTime: func() time.Time {
+			return time.Now().Add(-windows.DurationSinceBoot())
+		},
+	}, nil
+}
+
+func (c *Control) read(device uint32) (value uint32, ok bool, err error) {
+	if device == 0 {
+		return 0, false, nil
+	}
+
+	args := make([]byte, 4)
+	binary.LittleEndian.PutUint32(args[0:], device)
+
+	status, err := c.wmi.Evaluate(atkacpi.DSTS, args)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(status) < 4 {
+		return 0, false, nil
+	}
+
+	v := binary.LittleEndian.Uint32(status[0:4])
+	if v&dstsPresent == 0 {
+		return 0, false, nil
+	}
+	return v & 0xffff, true, nil
+}
+
+// write sets the device, and reads it back since the firmware ignores the
+// change if the dGPU is busy
+func (c *Control) write(device uint32, value uint32) error {
+	args := make([]byte, 8)
+	binary.LittleEndian.PutUint32(args[0:], device)
+	binary.LittleEndian.PutUint32(args[4:], value)
+
+	if _, err := c.wmi.Evaluate(atkacpi.DEVS, args); err != nil {
+		return err
+	}
+
+	actual, _, err := c.read(device)
+	if err != nil {
+		return err
+	}
+	if actual != value {
+		return fmt.Errorf("gpumode: device %08x reads back %d instead of %d", device, actual, value)
+	}
+	return nil
+}
+
+// status returns the active mode. Caller must hold c.mu.
+func (c *Control) status() (Status, error) {
+	eco, ecoOK, err := c.read(c.devices.GPUEco)
+	if err != nil {
+		return Status{}, err
+	}
+	mux, muxOK, err := c.read(c.devices.GPUMux)
+	if err != nil {
+		return Status{}, err
+	}
+	if !ecoOK && !muxOK {
+		return Status{}, ErrUnsupported
+	}
+
+	s := Status{
+		EcoSupported: ecoOK,
+		MuxSupported: muxOK,
+	}
+
+	// the MUX reads 0 when routed to the dGPU, and reports what is set
+	// rather than what is active
+	configured := Standard
+	switch {
+	case muxOK && mux == 0:
+		configured = Ultimate
+	case ecoOK && eco == 1:
+		configured = Eco
+	}
+
+	switch {
+	case c.pending == Unknown:
+		s.Mode = configured
+	case c.pending == configured:
+		// the pending change can only be between Standard and Ultimate
+		s.Pending = c.pending
+		s.Mode = Standard
+		if c.pending == Standard {
+			s.Mode = Ultimate
+		}
+	default:
+		// the MUX was changed back by something else
+		c.pending = Unknown
+		s.Mode = configured
+	}
+	return s, nil
+}
+
+// Status returns the active mode, and the mode after a reboot if a change is pending
+func (c *Control) Status() (Status, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.status()
+}
+
+// SetMode switches to the mode. Switching to or from Ultimate only takes
+// effect after a reboot. A *TransitionError is returned if the switch is not
+// safe, e.g. disabling the dGPU while it drives the panel.
+func (c *Control) SetMode(to Mode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, err := c.status()
+	if err != nil {
+		return err
+	}
+
+	if s.RebootRequired() {
+		if to == s.Mode {
+			// cancel the pending change
+			return c.setMux(s.Mode)
+		}
+		if to == s.Pending {
+			return nil
+		}
+		return ErrRebootRequired
+	}
+	if to == s.Mode {
+		return nil
+	}
+
+	unsupported := &TransitionError{From: s.Mode, To: to, Reason: "not supported on this model"}
+	switch {
+	case s.Mode == Eco && to == Standard:
+		return c.write(c.devices.GPUEco, 0)
+	case s.Mode == Standard && to == Eco:
+		if !s.EcoSupported {
+			return unsupported
+		}
+		return c.write(c.devices.GPUEco, 1)
+	case s.Mode == Standard && to == Ultimate, s.Mode == Ultimate && to == Standard:
+		if !s.MuxSupported {
+			return unsupported
+		}
+		return c.setMux(to)
+	case s.Mode == Eco && to == Ultimate:
+		// the dGPU has to be enabled before the panel is routed to it
+		return &TransitionError{From: s.Mode, To: to, Reason: "switch to standard first"}
+	case s.Mode == Ultimate && to == Eco:
+		// disabling the dGPU would blank the panel it drives
+		return &TransitionError{From: s.Mode, To: to, Reason: "switch to standard and reboot first"}
+	}
+	return &TransitionError{From: s.Mode, To: to, Reason: "unknown mode"}
+}
+
+// setMux routes the panel for the mode, which takes effect after a reboot.
+// Caller must hold c.mu.
+func (c *Control) setMux(to Mode) error {
+	value := uint32(1)
+	if to == Ultimate {
+		value = 0
+	}
+	if err := c.write(c.devices.GPUMux, value); err != nil {
+		return err
+	}
+
+	if c.pending != Unknown {
+		c.pending = Unknown
+	} else {
+		c.pending = to
+	}
+	log.Printf("gpumode: %s mode is set, pending reboot: %t\n", to, c.pending != Unknown)
+	return nil
+}
+
+type persistValue struct {
+	Pending  Mode      `json:"pending"`
+	BootTime time.Time `json:"bootTime"`
+}
+
+// Name satisfies persist.Registry
+func (c *Control) Name() string {
+	return persistKey
+}
+
+// Value satisfies persist.Registry
+func (c *Control) Value() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, _ := json.Marshal(persistValue{
+		Pending:  c.pending,
+		BootTime: c.bootTime(),
+	})
+	return b
+}
+
+// Load satisfies persist.Registry. The pending change is only restored if
+// the system has not been rebooted since.
+func (c *Control) Load(v []byte) error {
+	if len(v) == 0 {
+		return nil
+	}
+
+	var p persistValue
+	if err := json.Unmarshal(v, &p); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	drift := c.bootTime().Sub(p.BootTime)
+	if drift < -bootTimeTolerance || drift > bootTimeTolerance {
+		c.pending = Unknown
+		return nil
+	}
+	c.pending = p.Pending
+	return nil
+}
+
+// Apply satisfies persist.Registry. The firmware keeps the mode, so there is
+// nothing to apply.
+func (c *Control) Apply() error {
+	return nil
+}
+
+// Close satisfied persist.Registry
+func (c *Control) Close() error {
+	return nil
+}
@@ -0,0 +1,174 @@
+package gpumode
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NeilSeligmann/G15Manager/system/atkacpi"
+	"github.com/NeilSeligmann/G15Manager/system/model"
+	"github.com/stretchr/testify/require"
+)
+
+var testModel = &model.Model{
+	Devices: model.Devices{
+		GPUEco: atkacpi.DevsGPUEco,
+		GPUMux: atkacpi.DevsGPUMux,
+	},
+}
+
+// fakeWMI reports the devices in values, and ignores writes to stuck devices
+type fakeWMI struct {
+	values map[uint32]uint32
+	stuck  map[uint32]bool
+	writes int
+}
+
+func (f *fakeWMI) Evaluate(id atkacpi.Method, args []byte) ([]byte, error) {
+	device := binary.LittleEndian.Uint32(args[0:])
+	switch id {
+	case atkacpi.DEVS:
+		f.writes++
+		if !f.stuck[device] {
+			f.values[device] = binary.LittleEndian.Uint32(args[4:])
+		}
+		return make([]byte, 4), nil
+	case atkacpi.DSTS:
+		status := make([]byte, 4)
+		if v, ok := f.values[device]; ok {
+			binary.LittleEndian.PutUint32(status, dstsPresent|v)
+		}
+		return status, nil
+	}
+	return nil, errors.New("unexpected method")
+}
+
+func (f *fakeWMI) Close() error {
+	return nil
+}
+
+func newTestControl(t *testing.T, wmi *fakeWMI) *Control {
+	c, err := NewControl(wmi, testModel)
+	require.NoError(t, err)
+	boot := time.Date(2021, 8, 16, 12, 0, 0, 0, time.UTC)
+	c.bootTime = func() time.Time { return boot }
+	return c
+}
+
+func TestModeStatus(t *testing.T) {
+	wmi := &fakeWMI{values: map[uint32]uint32{}}
+	c := newTestControl(t, wmi)
+
+	_, err := c.Status()
+	require.True(t, errors.Is(err, ErrUnsupported))
+
+	wmi.values[atkacpi.DevsGPUEco] = 1
+	s, err := c.Status()
+	require.NoError(t, err)
+	require.Equal(t, Status{Mode: Eco, EcoSupported: true}, s)
+
+	wmi.values[atkacpi.DevsGPUEco] = 0
+	wmi.values[atkacpi.DevsGPUMux] = 1
+	s, err = c.Status()
+	require.NoError(t, err)
+	require.Equal(t, Status{Mode: Standard, EcoSupported: true, MuxSupported: true}, s)
+
+	wmi.values[atkacpi.DevsGPUMux] = 0
+	s, err = c.Status()
+	require.NoError(t, err)
+	require.Equal(t, Ultimate, s.Mode)
+	require.False(t, s.RebootRequired())
+}
+
+func TestModeTransitions(t *testing.T) {
+	wmi := &fakeWMI{values: map[uint32]uint32{
+		atkacpi.DevsGPUEco: 1,
+		atkacpi.DevsGPUMux: 1,
+	}}
+	c := newTestControl(t, wmi)
+
+	var transition *TransitionError
+	require.True(t, errors.As(c.SetMode(Ultimate), &transition))
+	require.Equal(t, Eco, transition.From)
+	require.Equal(t, 0, wmi.writes)
+
+	require.NoError(t, c.SetMode(Standard))
+	require.Equal(t, uint32(0), wmi.values[atkacpi.DevsGPUEco])
+
+	require.NoError(t, c.SetMode(Ultimate))
+	s, err := c.Status()
+	require.NoError(t, err)
+	require.Equal(t, Status{Mode: Standard, Pending: Ultimate, EcoSupported: true, MuxSupported: true}, s)
+	require.True(t, s.RebootRequired())
+
+	require.True(t, errors.Is(c.SetMode(Eco), ErrRebootRequired))
+	require.NoError(t, c.SetMode(Ultimate))
+
+	// cancelling the pending change
+	require.NoError(t, c.SetMode(Standard))
+	s, err = c.Status()
+	require.NoError(t, err)
+	require.Equal(t, Standard, s.Mode)
+	require.False(t, s.RebootRequired())
+
+	// after a reboot into Ultimate
+	wmi.values[atkacpi.DevsGPUMux] = 0
+	require.True(t, errors.As(c.SetMode(Eco), &transition))
+	require.Equal(t, Ultimate, transition.From)
+
+	require.NoError(t, c.SetMode(Standard))
+	s, err = c.Status()
+	require.NoError(t, err)
+	require.Equal(t, Ultimate, s.Mode)
+	require.Equal(t, Standard, s.Pending)
+
+	// the MUX was changed back by another tool
+	wmi.values[atkacpi.DevsGPUMux] = 0
+	s, err = c.Status()
+	require.NoError(t, err)
+	require.Equal(t, Status{Mode: Ultimate, EcoSupported: true, MuxSupported: true}, s)
+}
+
+func TestModeUnsupported(t *testing.T) {
+	wmi := &fakeWMI{
+		values: map[uint32]uint32{atkacpi.DevsGPUEco: 0},
+		stuck:  map[uint32]bool{atkacpi.DevsGPUEco: true},
+	}
+	c := newTestControl(t, wmi)
+
+	var transition *TransitionError
+	require.True(t, errors.As(c.SetMode(Ultimate), &transition))
+
+	// the firmware refuses while the dGPU is busy
+	require.Error(t, c.SetMode(Eco))
+	s, err := c.Status()
+	require.NoError(t, err)
+	require.Equal(t, Standard, s.Mode)
+}
+
+func TestModePersist(t *testing.T) {
+	wmi := &fakeWMI{values: map[uint32]uint32{
+		atkacpi.DevsGPUEco: 0,
+		atkacpi.DevsGPUMux: 1,
+	}}
+	c := newTestControl(t, wmi)
+	require.NotEmpty(t, c.Name())
+	require.NoError(t, c.SetMode(Ultimate))
+	b := c.Value()
+
+	loaded := newTestControl(t, wmi)
+	require.NoError(t, loaded.Load(b))
+	s, err := loaded.Status()
+	require.NoError(t, err)
+	require.Equal(t, Ultimate, s.Pending)
+
+	// rebooted since
+	rebooted := newTestControl(t, wmi)
+	rebooted.bootTime = func() time.Time { return time.Date(2021, 8, 16, 18, 0, 0, 0, time.UTC) }
+	require.NoError(t, rebooted.Load(b))
+	s, err = rebooted.Status()
+	require.NoError(t, err)
+	require.Equal(t, Ultimate, s.Mode)
+	require.False(t, s.RebootRequired())
+}
@@ -0,0 +1,149 @@
+// Package aura encodes ASUS Aura lighting effects into N-Key reports.
+package aura
+
+import (
+	"fmt"
+
+	"github.com/NeilSeligmann/G15Manager/system/keyboard/protocol"
+)
+
+// Mode is the name of a lighting effect
+type Mode string
+
+// Defines the supported lighting effects
+const (
+	Static     Mode = "static"
+	Breathing  Mode = "breathing"
+	ColorCycle Mode = "colorCycle"
+	Strobe     Mode = "strobe"
+)
+
+// Speed is the name of an effect speed
+type Speed string
+
+// Defines the effect speeds
+const (
+	Low    Speed = "low"
+	Medium Speed = "medium"
+	High   Speed = "high"
+)
+
+// Direction is the name of an effect direction
+type Direction string
+
+// Defines the effect directions
+const (
+	Right Direction = "right"
+	Left  Direction = "left"
+	Up    Direction = "up"
+	Down  Direction = "down"
+)
+
+// DefaultProfile is the key of the effect used when a profile has no effect of its own
+const DefaultProfile = "default"
+
+var (
+	modes = map[Mode]protocol.Mode{
+		Static:     protocol.ModeStatic,
+		Breathing:  protocol.ModeBreathing,
+		ColorCycle: protocol.ModeColorCycle,
+		Strobe:     protocol.ModeStrobe,
+	}
+	speeds = map[Speed]protocol.Speed{
+		Low:    protocol.SpeedLow,
+		Medium: protocol.SpeedMedium,
+		High:   protocol.SpeedHigh,
+	}
+	directions = map[Direction]protocol.Direction{
+		Right: protocol.DirectionRight,
+		Left:  protocol.DirectionLeft,
+		Up:    protocol.DirectionUp,
+		Down:  protocol.DirectionDown,
+	}
+)
+
+// Effect defines a lighting effect for the whole keyboard. Speed and Direction
+// are optional and default to Medium and Right.
+type Effect struct {
+	Mode      Mode           `json:"mode"`
+	Color     protocol.Color `json:"color"`
+	Speed     Speed          `json:"speed,omitempty"`
+	Direction Direction      `json:"direction,omitempty"`
+}
+
+// DefaultEffect is a static white, the same as the keyboard without Aura control
+var DefaultEffect = Effect{
+	Mode:  Static,
+	Color: protocol.Color{R: 0xff, G: 0xff, B: 0xff},
+}
+
+func (e Effect) String() string {
+	return fmt.Sprintf("%s %s", e.Mode, e.Color)
+}
+
+// Validate returns an error if the effect cannot be encoded
+func (e Effect) Validate() error {
+	if _, ok := modes[e.Mode]; !ok {
+		return fmt.Errorf("aura: unknown mode %q", e.Mode)
+	}
+	if _, ok := speeds[e.Speed]; !ok && e.Speed != "" {
+		return fmt.Errorf("aura: unknown speed %q", e.Speed)
+	}
+	if _, ok := directions[e.Direction]; !ok && e.Direction != "" {
+		return fmt.Errorf("aura: unknown direction %q", e.Direction)
+	}
+	return nil
+}
+
+// Messages returns the N-Key messages that set and apply the effect
+func (e Effect) Messages() ([]protocol.Message, error) {
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+
+	speed := protocol.SpeedMedium
+	if e.Speed != "" {
+		speed = speeds[e.Speed]
+	}
+	direction := protocol.DirectionRight
+	if e.Direction != "" {
+		direction = directions[e.Direction]
+	}
+
+	return []protocol.Message{
+		protocol.SetLightingMode{
+			Zone:      0,
+			Mode:      modes[e.Mode],
+			Color:     e.Color,
+			Speed:     speed,
+			Direction: direction,
+		},
+		protocol.ApplyLighting{},
+	}, nil
+}
+
+// Encode returns the reports that have to be written to the keyboard control
+// interface, in order, to show the effect
+func Encode(e Effect) ([][]byte, error) {
+	msgs, err := e.Messages()
+	if err != nil {
+		return nil, err
+	}
+	reports := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		reports = append(reports, msg.Encode())
+	}
+	return reports, nil
+}
+
+// ForProfile returns the effect configured for the profile, falling back to the
+// DefaultProfile entry and then to DefaultEffect
+func ForProfile(effects map[string]Effect, profile string) Effect {
+	if e, ok := effects[profile]; ok {
+		return e
+	}
+	if e, ok := effects[DefaultProfile]; ok {
+		return e
+	}
+	return DefaultEffect
+}
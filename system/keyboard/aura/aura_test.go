@@ -0,0 +1,106 @@
+package aura
+
+import (
+	"testing"
+
+	"github.com/NeilSeligmann/G15Manager/system/keyboard/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessages(t *testing.T) {
+	tests := []struct {
+		name   string
+		effect Effect
+		mode   protocol.SetLightingMode
+	}{
+		{
+			name:   "default speed and direction",
+			effect: DefaultEffect,
+			mode: protocol.SetLightingMode{
+				Mode:      protocol.ModeStatic,
+				Color:     protocol.Color{R: 0xff, G: 0xff, B: 0xff},
+				Speed:     protocol.SpeedMedium,
+				Direction: protocol.DirectionRight,
+			},
+		},
+		{
+			name: "breathing slow",
+			effect: Effect{
+				Mode:  Breathing,
+				Color: protocol.Color{R: 0xff},
+				Speed: Low,
+			},
+			mode: protocol.SetLightingMode{
+				Mode:      protocol.ModeBreathing,
+				Color:     protocol.Color{R: 0xff},
+				Speed:     protocol.SpeedLow,
+				Direction: protocol.DirectionRight,
+			},
+		},
+		{
+			name: "color cycle fast left",
+			effect: Effect{
+				Mode:      ColorCycle,
+				Speed:     High,
+				Direction: Left,
+			},
+			mode: protocol.SetLightingMode{
+				Mode:      protocol.ModeColorCycle,
+				Speed:     protocol.SpeedHigh,
+				Direction: protocol.DirectionLeft,
+			},
+		},
+		{
+			name: "strobe down",
+			effect: Effect{
+				Mode:      Strobe,
+				Color:     protocol.Color{G: 0x80, B: 0xff},
+				Direction: Down,
+			},
+			mode: protocol.SetLightingMode{
+				Mode:      protocol.ModeStrobe,
+				Color:     protocol.Color{G: 0x80, B: 0xff},
+				Speed:     protocol.SpeedMedium,
+				Direction: protocol.DirectionDown,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			msgs, err := tc.effect.Messages()
+			require.NoError(t, err)
+			require.Equal(t, []protocol.Message{tc.mode, protocol.ApplyLighting{}}, msgs)
+
+			// the bytes are checked against the sources in the protocol tests
+			reports, err := Encode(tc.effect)
+			require.NoError(t, err)
+			require.Equal(t, [][]byte{tc.mode.Encode(), protocol.ApplyLighting{}.Encode()}, reports)
+		})
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	_, err := Encode(Effect{Mode: "rainbow"})
+	require.Error(t, err)
+
+	_, err = Encode(Effect{Mode: Static, Speed: "ludicrous"})
+	require.Error(t, err)
+
+	_, err = Encode(Effect{Mode: Static, Direction: "sideways"})
+	require.Error(t, err)
+}
+
+func TestForProfile(t *testing.T) {
+	red := Effect{Mode: Static, Color: protocol.Color{R: 0xff}}
+	blue := Effect{Mode: Breathing, Color: protocol.Color{B: 0xff}}
+
+	require.Equal(t, DefaultEffect, ForProfile(nil, "Turbo"))
+
+	effects := map[string]Effect{
+		DefaultProfile: red,
+		"Turbo":        blue,
+	}
+	require.Equal(t, blue, ForProfile(effects, "Turbo"))
+	require.Equal(t, red, ForProfile(effects, "Silent"))
+}
@@ -0,0 +1,12 @@
+package keyboard
+
+// Bindings maps N-Key key codes to a binding. A key bound to an empty binding
+// is disabled, and keys without a binding keep their built-in action.
+type Bindings map[uint32]string
+
+// Lookup returns the binding of the key code, and whether the key is bound.
+// Disabled keys are bound, with an empty binding.
+func (b Bindings) Lookup(keyCode uint32) (string, bool) {
+	binding, ok := b[keyCode]
+	return binding, ok
+}
@@ -0,0 +1,51 @@
+package keyboard
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBindingsLookup(t *testing.T) {
+	b := Bindings{
+		KeyFnF4: "$key:playPause",
+		KeyFnC:  "",
+		KeyFnUp: "$macro:test",
+		KeyFnF5: "Taskmgr.exe",
+	}
+
+	binding, ok := b.Lookup(KeyFnF4)
+	require.True(t, ok)
+	require.Equal(t, "$key:playPause", binding)
+
+	// disabled
+	binding, ok = b.Lookup(KeyFnC)
+	require.True(t, ok)
+	require.Equal(t, "", binding)
+
+	// built-in action
+	_, ok = b.Lookup(KeyFnV)
+	require.False(t, ok)
+
+	_, ok = Bindings(nil).Lookup(KeyFnV)
+	require.False(t, ok)
+}
+
+func TestBindingsJSON(t *testing.T) {
+	b := Bindings{
+		KeyFnF4: "$key:playPause",
+		KeyFnC:  "",
+	}
+
+	buf, err := json.Marshal(b)
+	require.NoError(t, err)
+
+	var loaded Bindings
+	require.NoError(t, json.Unmarshal(buf, &loaded))
+	require.Equal(t, b, loaded)
+
+	// a disabled key stays disabled once persisted
+	_, ok := loaded.Lookup(KeyFnC)
+	require.True(t, ok)
+}
@@ -1,10 +1,9 @@
 package keyboard
 
-// Defines the vendorID/productID for NKEY keyboard
+// Defines the vendorID for NKEY keyboard. The productID differs between
+// models, see system/model
 const (
 	VendorID = 0x0b05
-	// ProductID = 0x1866
-	ProductID = 0x19b6
 )
 
 // Define key codes
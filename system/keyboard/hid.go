@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/karalabe/usb"
 )
@@ -14,6 +16,13 @@ const (
 	reportID      = 0x5a
 )
 
+const (
+	reconnectBackoffMin = time.Millisecond * 250
+	reconnectBackoffMax = time.Second * 10
+	// reads time out to check if the listener should stop
+	readTimeout = time.Millisecond * 250
+)
+
 var (
 	hidDevices = []string{
 		"mi_00&col01", // Special key combo
@@ -21,60 +30,236 @@ var (
 	}
 )
 
-// NewHidListener will read HID report and return key code to the channel
-func NewHidListener(haltCtx context.Context, eventCh chan uint32) (map[string]usb.DeviceInfo, error) {
-	devicesFound := make(map[string]usb.DeviceInfo)
-	devices, err := usb.EnumerateHid(VendorID, ProductID)
+// Device is an opened HID interface that we can read reports from. ReadTimeout
+// returns 0 and no error if no report arrived before the timeout.
+type Device interface {
+	ReadTimeout(b []byte, timeout time.Duration) (int, error)
+	Close() error
+}
+
+// Enumerator finds and opens the HID interfaces of the keyboard
+type Enumerator interface {
+	// Enumerate returns the paths of the HID interfaces currently attached
+	Enumerate() ([]string, error)
+	// Open opens the HID interface with the given path
+	Open(path string) (Device, error)
+}
+
+// Health is sent by the listener whenever a HID interface is (re)connected or lost
+type Health struct {
+	Interface string
+	Connected bool
+	Err       error
+}
+
+type usbEnumerator struct {
+	mu        sync.Mutex
+	vendorID  uint16
+	productID uint16
+	devices   map[string]usb.DeviceInfo
+}
+
+var _ Enumerator = &usbEnumerator{}
+
+// NewUSBEnumerator returns an Enumerator backed by hidapi for the given
+// vendorID/productID. The interfaces are opened for reading with a timeout.
+func NewUSBEnumerator(vendorID, productID uint16) Enumerator {
+	return &usbEnumerator{
+		vendorID:  vendorID,
+		productID: productID,
+		devices:   make(map[string]usb.DeviceInfo),
+	}
+}
+
+func (u *usbEnumerator) Enumerate() ([]string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	devices, err := usb.EnumerateHid(u.vendorID, u.productID)
 	if err != nil {
 		return nil, err
 	}
 
+	paths := make([]string, 0, len(devices))
+	u.devices = make(map[string]usb.DeviceInfo, len(devices))
 	for _, device := range devices {
-		// TODO: make it less inefficient
-		for _, hid := range hidDevices {
-			if !strings.Contains(device.Path, hid) {
-				continue
-			}
-			devicesFound[hid] = device
-		}
+		u.devices[device.Path] = device
+		paths = append(paths, device.Path)
 	}
-	if len(devicesFound) == 0 {
-		return nil, fmt.Errorf("No devices found")
+	return paths, nil
+}
+
+func (u *usbEnumerator) Open(path string) (Device, error) {
+	u.mu.Lock()
+	info, ok := u.devices[path]
+	u.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("hid: %s was not enumerated", path)
 	}
+	return openDevice(info.Path)
+}
 
-	openDevices := make([]usb.Device, 0, 2)
+// HidListenerConfig defines where the HID listener reads from and reports to.
+// Key codes are sent to EventCh, and connection changes to HealthCh. If OnReport
+// is set, it will be called with every raw report read (e.g. for capturing).
+type HidListenerConfig struct {
+	Enumerator Enumerator
+	EventCh    chan<- uint32
+	HealthCh   chan<- Health
+	OnReport   func(report []byte)
+}
 
-	for _, device := range devicesFound {
-		d, err := device.Open()
-		if err != nil {
-			return nil, err
+type hidListener struct {
+	HidListenerConfig
+
+	backoffMin time.Duration
+	backoffMax time.Duration
+}
+
+// NewHidListener will read HID report and return key code to the channel.
+// If the device is lost (e.g. the N-Key device resets after suspend), the listener
+// will re-enumerate and reopen it with backoff, and report the change to HealthCh.
+func NewHidListener(haltCtx context.Context, conf HidListenerConfig) error {
+	l := &hidListener{
+		HidListenerConfig: conf,
+		backoffMin:        reconnectBackoffMin,
+		backoffMax:        reconnectBackoffMax,
+	}
+	return l.start(haltCtx)
+}
+
+// ParseReport returns the key code in the HID report, if there is one
+func ParseReport(report []byte) (uint32, bool) {
+	if len(report) < 2 {
+		return 0, false
+	}
+	if report[1] > 0 && report[1] < 236 {
+		return uint32(report[1]), true
+	}
+	return 0, false
+}
+
+func (l *hidListener) start(haltCtx context.Context) error {
+	paths, err := l.Enumerator.Enumerate()
+	if err != nil {
+		return err
+	}
+
+	found := make([]string, 0, len(hidDevices))
+	for _, hid := range hidDevices {
+		if findInterface(paths, hid) != "" {
+			found = append(found, hid)
 		}
-		openDevices = append(openDevices, d)
-		log.Printf("hid: reading from %s\n", device.Path)
 	}
-	for _, d := range openDevices {
-		go readDevice(haltCtx, eventCh, d)
+	if len(found) == 0 {
+		return fmt.Errorf("No devices found")
 	}
-	return devicesFound, nil
+
+	for _, hid := range found {
+		go l.watch(haltCtx, hid)
+	}
+	return nil
 }
 
-func readDevice(haltCtx context.Context, eventCh chan uint32, dev usb.Device) {
+// watch keeps the given HID interface open and read from until haltCtx is done
+func (l *hidListener) watch(haltCtx context.Context, hid string) {
+	backoff := l.backoffMin
 	for {
-		select {
-		case <-haltCtx.Done():
-			dev.Close()
+		dev, path, err := l.open(hid)
+		if err != nil {
+			log.Printf("hid: unable to open %s, retrying in %s: %s\n", hid, backoff, err)
+			l.report(haltCtx, Health{Interface: hid, Err: err})
+
+			select {
+			case <-time.After(backoff):
+			case <-haltCtx.Done():
+				return
+			}
+			backoff *= 2
+			if backoff > l.backoffMax {
+				backoff = l.backoffMax
+			}
+			continue
+		}
+
+		backoff = l.backoffMin
+		log.Printf("hid: reading from %s\n", path)
+		l.report(haltCtx, Health{Interface: hid, Connected: true})
+
+		err = l.read(haltCtx, dev)
+		if err == nil {
 			log.Printf("hid: closing read channel\n")
 			return
-		default:
-			buf := make([]byte, reportBufSize)
-			buf[0] = reportID
-			_, err := dev.Read(buf)
-			if err != nil {
-				log.Fatalln(err)
-			}
-			if buf[1] > 0 && buf[1] < 236 {
-				eventCh <- uint32(buf[1])
+		}
+		log.Printf("hid: lost %s: %s\n", hid, err)
+		l.report(haltCtx, Health{Interface: hid, Err: err})
+	}
+}
+
+func (l *hidListener) open(hid string) (Device, string, error) {
+	paths, err := l.Enumerator.Enumerate()
+	if err != nil {
+		return nil, "", err
+	}
+	path := findInterface(paths, hid)
+	if path == "" {
+		return nil, "", fmt.Errorf("interface %s not found", hid)
+	}
+	dev, err := l.Enumerator.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return dev, path, nil
+}
+
+// read returns nil when haltCtx is done, otherwise the read error. The device
+// is closed in both cases, once no read is in flight: reads time out to check
+// haltCtx instead of being unblocked by closing the device.
+func (l *hidListener) read(haltCtx context.Context, dev Device) error {
+	defer dev.Close()
+
+	for {
+		buf := make([]byte, reportBufSize)
+		buf[0] = reportID
+		n, err := dev.ReadTimeout(buf, readTimeout)
+		if haltCtx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		if l.OnReport != nil {
+			l.OnReport(buf)
+		}
+		if keyCode, ok := ParseReport(buf); ok {
+			select {
+			case l.EventCh <- keyCode:
+			case <-haltCtx.Done():
+				return nil
 			}
 		}
 	}
 }
+
+func (l *hidListener) report(haltCtx context.Context, h Health) {
+	if l.HealthCh == nil {
+		return
+	}
+	select {
+	case l.HealthCh <- h:
+	case <-haltCtx.Done():
+	}
+}
+
+func findInterface(paths []string, hid string) string {
+	for _, path := range paths {
+		if strings.Contains(path, hid) {
+			return path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,7 @@
+package keyboard
+
+import "errors"
+
+func openDevice(path string) (Device, error) {
+	return nil, errors.New("hid: reading with a timeout is only implemented on Windows")
+}
@@ -0,0 +1,167 @@
+package keyboard
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testPath = `\\?\hid#vid_0b05&pid_19b6&mi_00&col01#8&1e16c781&0&0000`
+
+type fakeDevice struct {
+	reports chan []byte
+	closed  chan struct{}
+	once    sync.Once
+
+	mu      sync.Mutex
+	reading bool
+	// closedReading is set if the device was closed during a read
+	closedReading bool
+}
+
+func newFakeDevice() *fakeDevice {
+	return &fakeDevice{
+		reports: make(chan []byte),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (f *fakeDevice) setReading(reading bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reading = reading
+}
+
+func (f *fakeDevice) ReadTimeout(b []byte, timeout time.Duration) (int, error) {
+	f.setReading(true)
+	defer f.setReading(false)
+
+	select {
+	case r, ok := <-f.reports:
+		if !ok {
+			return 0, errors.New("device reset")
+		}
+		return copy(b, r), nil
+	case <-time.After(timeout):
+		return 0, nil
+	case <-f.closed:
+		return 0, errors.New("device closed")
+	}
+}
+
+func (f *fakeDevice) Close() error {
+	f.mu.Lock()
+	f.closedReading = f.closedReading || f.reading
+	f.mu.Unlock()
+
+	f.once.Do(func() {
+		close(f.closed)
+	})
+	return nil
+}
+
+type fakeEnumerator struct {
+	mu      sync.Mutex
+	present bool
+	devices chan *fakeDevice
+}
+
+func (f *fakeEnumerator) setPresent(p bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.present = p
+}
+
+func (f *fakeEnumerator) Enumerate() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.present {
+		return nil, nil
+	}
+	return []string{testPath}, nil
+}
+
+func (f *fakeEnumerator) Open(path string) (Device, error) {
+	select {
+	case d := <-f.devices:
+		return d, nil
+	case <-time.After(time.Second):
+		return nil, errors.New("no device")
+	}
+}
+
+func newTestListener(e Enumerator, eventCh chan uint32, healthCh chan Health) *hidListener {
+	return &hidListener{
+		HidListenerConfig: HidListenerConfig{
+			Enumerator: e,
+			EventCh:    eventCh,
+			HealthCh:   healthCh,
+		},
+		backoffMin: time.Millisecond,
+		backoffMax: time.Millisecond * 5,
+	}
+}
+
+func TestHidListenerNoDevice(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := newTestListener(&fakeEnumerator{}, make(chan uint32), make(chan Health))
+	require.Error(t, l.start(ctx))
+}
+
+func TestHidListenerReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eventCh := make(chan uint32)
+	healthCh := make(chan Health)
+	e := &fakeEnumerator{
+		present: true,
+		devices: make(chan *fakeDevice, 2),
+	}
+	first, second := newFakeDevice(), newFakeDevice()
+	e.devices <- first
+	e.devices <- second
+
+	l := newTestListener(e, eventCh, healthCh)
+	require.NoError(t, l.start(ctx))
+
+	require.True(t, (<-healthCh).Connected)
+	first.reports <- []byte{reportID, byte(KeyROG)}
+	require.Equal(t, KeyROG, <-eventCh)
+
+	// device goes away and comes back after a few attempts
+	e.setPresent(false)
+	close(first.reports)
+	require.False(t, (<-healthCh).Connected)
+	require.False(t, (<-healthCh).Connected)
+	e.setPresent(true)
+
+	var h Health
+	for h = <-healthCh; !h.Connected; h = <-healthCh {
+	}
+	require.Equal(t, "mi_00&col01", h.Interface)
+
+	second.reports <- []byte{reportID, byte(KeyFnF5)}
+	require.Equal(t, KeyFnF5, <-eventCh)
+
+	// ignored reports should not be forwarded
+	second.reports <- []byte{reportID, 0}
+	second.reports <- []byte{reportID, byte(KeyFnC)}
+	require.Equal(t, KeyFnC, <-eventCh)
+
+	cancel()
+	select {
+	case <-second.closed:
+	case <-time.After(time.Second):
+		t.Fatal("device was not closed on halt")
+	}
+	second.mu.Lock()
+	defer second.mu.Unlock()
+	require.False(t, second.closedReading, "device was closed during a read")
+}
@@ -0,0 +1,87 @@
+package keyboard
+
+import (
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// inputBufSize is larger than any input report of the keyboard, as a HID read
+// fails if the buffer is smaller than the report
+const inputBufSize = 256
+
+// hidDevice reads input reports with overlapped IO, so a read can time out.
+// hidapi only offers blocking reads through karalabe/usb, and closing the
+// device is the only way to unblock those.
+type hidDevice struct {
+	handle windows.Handle
+	event  windows.Handle
+	buf    []byte
+}
+
+var _ Device = &hidDevice{}
+
+func openDevice(path string) (Device, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := windows.CreateFile(
+		p,
+		windows.GENERIC_READ|windows.GENERIC_WRITE,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE,
+		nil,
+		windows.OPEN_EXISTING,
+		windows.FILE_FLAG_OVERLAPPED,
+		0,
+	)
+	if err != nil {
+		return nil, err
+	}
+	// manual reset, as GetOverlappedResult may wait on it as well
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		windows.CloseHandle(h)
+		return nil, err
+	}
+	return &hidDevice{
+		handle: h,
+		event:  event,
+		buf:    make([]byte, inputBufSize),
+	}, nil
+}
+
+func (d *hidDevice) ReadTimeout(b []byte, timeout time.Duration) (int, error) {
+	if err := windows.ResetEvent(d.event); err != nil {
+		return 0, err
+	}
+
+	var done uint32
+	o := &windows.Overlapped{HEvent: d.event}
+	err := windows.ReadFile(d.handle, d.buf, &done, o)
+	if err == nil {
+		return copy(b, d.buf[:done]), nil
+	}
+	if err != windows.ERROR_IO_PENDING {
+		return 0, err
+	}
+
+	ev, waitErr := windows.WaitForSingleObject(d.event, uint32(timeout.Milliseconds()))
+	if waitErr != nil || ev == uint32(windows.WAIT_TIMEOUT) {
+		// the buffer must not be written after we return, so wait for the
+		// cancellation to complete
+		windows.CancelIoEx(d.handle, o)
+	}
+	if err := windows.GetOverlappedResult(d.handle, o, &done, true); err != nil {
+		if err == windows.ERROR_OPERATION_ABORTED {
+			return 0, waitErr
+		}
+		return 0, err
+	}
+	return copy(b, d.buf[:done]), nil
+}
+
+func (d *hidDevice) Close() error {
+	windows.CloseHandle(d.event)
+	return windows.CloseHandle(d.handle)
+}
@@ -0,0 +1,12 @@
+package keyboard
+
+import (
+	"errors"
+	"time"
+)
+
+// IdleTime returns how long ago the user last pressed a key or moved the
+// mouse in the session
+func IdleTime() (time.Duration, error) {
+	return 0, errors.New("keyboard: idle time is only implemented on Windows")
+}
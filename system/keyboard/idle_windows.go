@@ -0,0 +1,35 @@
+package keyboard
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	user32               = windows.NewLazySystemDLL("user32.dll")
+	kernel32             = windows.NewLazySystemDLL("kernel32.dll")
+	procGetLastInputInfo = user32.NewProc("GetLastInputInfo")
+	procGetTickCount     = kernel32.NewProc("GetTickCount")
+)
+
+// lastInputInfo is LASTINPUTINFO
+type lastInputInfo struct {
+	Size uint32
+	Time uint32
+}
+
+// IdleTime returns how long ago the user last pressed a key or moved the
+// mouse in the session
+func IdleTime() (time.Duration, error) {
+	info := lastInputInfo{}
+	info.Size = uint32(unsafe.Sizeof(info))
+	ret, _, err := procGetLastInputInfo.Call(uintptr(unsafe.Pointer(&info)))
+	if ret == 0 {
+		return 0, err
+	}
+	now, _, _ := procGetTickCount.Call()
+	// both tick counts wrap around after 49.7 days
+	return time.Duration(uint32(now)-info.Time) * time.Millisecond, nil
+}
@@ -0,0 +1,141 @@
+package macro
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	whKeyboardLL  = 13
+	wmQuit        = 0x0012
+	wmKeyUp       = 0x0101
+	wmSysKeyUp    = 0x0105
+	llkhfExtended = 0x01
+	llkhfInjected = 0x10
+)
+
+var (
+	user32                  = windows.NewLazySystemDLL("user32.dll")
+	procSetWindowsHookExW   = user32.NewProc("SetWindowsHookExW")
+	procUnhookWindowsHookEx = user32.NewProc("UnhookWindowsHookEx")
+	procCallNextHookEx      = user32.NewProc("CallNextHookEx")
+	procGetMessageW         = user32.NewProc("GetMessageW")
+	procPostThreadMessageW  = user32.NewProc("PostThreadMessageW")
+)
+
+// kbdllHookStruct is KBDLLHOOKSTRUCT
+type kbdllHookStruct struct {
+	VkCode    uint32
+	ScanCode  uint32
+	Flags     uint32
+	Time      uint32
+	ExtraInfo uintptr
+}
+
+// message is MSG, we only need the storage
+type message struct {
+	Hwnd    uintptr
+	Message uint32
+	WParam  uintptr
+	LParam  uintptr
+	Time    uint32
+	X, Y    int32
+}
+
+var (
+	// callbacks cannot be freed, so only one is ever created
+	hookOnce     sync.Once
+	hookCallback uintptr
+
+	hookMu sync.Mutex
+	hookCh chan<- KeyEvent
+)
+
+func hookProc(nCode int, wParam uintptr, lParam *kbdllHookStruct) uintptr {
+	if nCode >= 0 {
+		hookMu.Lock()
+		ch := hookCh
+		hookMu.Unlock()
+
+		if ch != nil {
+			e := KeyEvent{
+				ScanCode: uint16(lParam.ScanCode),
+				Extended: lParam.Flags&llkhfExtended != 0,
+				Up:       wParam == wmKeyUp || wParam == wmSysKeyUp,
+				Injected: lParam.Flags&llkhfInjected != 0,
+			}
+			// never hold up the keyboard of the whole system
+			select {
+			case ch <- e:
+			default:
+			}
+		}
+	}
+	ret, _, _ := procCallNextHookEx.Call(0, uintptr(nCode), wParam, uintptr(unsafe.Pointer(lParam)))
+	return ret
+}
+
+// Hook installs a low level keyboard hook, and sends every key event to ch
+// until haltCtx is done. Only one hook can be installed at a time.
+func Hook(haltCtx context.Context, ch chan<- KeyEvent) error {
+	hookMu.Lock()
+	if hookCh != nil {
+		hookMu.Unlock()
+		return errors.New("macro: keyboard hook is already installed")
+	}
+	hookCh = ch
+	hookMu.Unlock()
+
+	hookOnce.Do(func() {
+		hookCallback = windows.NewCallback(hookProc)
+	})
+
+	ready := make(chan error, 1)
+	go func() {
+		defer func() {
+			hookMu.Lock()
+			hookCh = nil
+			hookMu.Unlock()
+		}()
+
+		// hooks are called on the thread that installed them, from its message loop
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		hook, _, err := procSetWindowsHookExW.Call(whKeyboardLL, hookCallback, 0, 0)
+		if hook == 0 {
+			ready <- err
+			return
+		}
+		defer procUnhookWindowsHookEx.Call(hook)
+
+		threadID := windows.GetCurrentThreadId()
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-haltCtx.Done():
+				procPostThreadMessageW.Call(uintptr(threadID), wmQuit, 0, 0)
+			case <-stop:
+			}
+		}()
+
+		ready <- nil
+
+		var msg message
+		for {
+			ret, _, _ := procGetMessageW.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0)
+			// WM_QUIT or error
+			if int32(ret) <= 0 {
+				return
+			}
+		}
+	}()
+
+	return <-ready
+}
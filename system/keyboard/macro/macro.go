@@ -0,0 +1,331 @@
+// Package macro records and plays back sequences of keystrokes.
+package macro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BindingPrefix is the prefix of a ROG key command that plays a macro, e.g. "$macro:build"
+const BindingPrefix = "$macro:"
+
+var (
+	// ErrPlaying is returned when a macro is requested while another one is playing
+	ErrPlaying = errors.New("macro: a macro is already playing")
+	// ErrNotRecording is returned when a recording is stopped before it was started
+	ErrNotRecording = errors.New("macro: not recording")
+	// ErrRecording is returned when a recording is started twice
+	ErrRecording = errors.New("macro: already recording")
+)
+
+// Event is what a step does with its key
+type Event string
+
+// Defines the step events
+const (
+	// Press presses and releases the key, for steps edited by hand
+	Press Event = ""
+	// Down presses the key and holds it, e.g. a modifier
+	Down Event = "down"
+	// Up releases the key
+	Up Event = "up"
+)
+
+// Step is a single key event, performed Delay milliseconds after the previous
+// step. Extended is set for the keys sharing a scan code with the numpad (e.g.
+// the arrows, Home/End or right Ctrl), which are sent with an 0xe0 prefix.
+type Step struct {
+	ScanCode uint16 `json:"scanCode"`
+	Extended bool   `json:"extended,omitempty"`
+	Delay    uint32 `json:"delay"`
+	Event    Event  `json:"event,omitempty"`
+}
+
+// key identifies a physical key, as the extended keys reuse scan codes
+type key struct {
+	scanCode uint16
+	extended bool
+}
+
+// Macro is a named sequence of key presses. If Hotkey is not zero, the macro is
+// played when the N-Key keyboard reports that key code.
+type Macro struct {
+	Name   string `json:"name"`
+	Hotkey uint32 `json:"hotkey"`
+	Steps  []Step `json:"steps"`
+}
+
+// Validate returns an error if the macro cannot be played or bound
+func (m Macro) Validate() error {
+	if m.Name == "" {
+		return errors.New("macro: name cannot be empty")
+	}
+	// ROG key commands are sent as a comma separated list
+	if strings.Contains(m.Name, ",") {
+		return fmt.Errorf("macro: name %q cannot contain a comma", m.Name)
+	}
+	if len(m.Steps) == 0 {
+		return fmt.Errorf("macro: %s has no steps", m.Name)
+	}
+	for i, s := range m.Steps {
+		if s.ScanCode == 0 {
+			return fmt.Errorf("macro: %s has an empty scan code at step %d", m.Name, i)
+		}
+		switch s.Event {
+		case Press, Down, Up:
+		default:
+			return fmt.Errorf("macro: %s has an unknown event %q at step %d", m.Name, s.Event, i)
+		}
+	}
+	return nil
+}
+
+// Binding returns the macro name if the ROG key command plays a macro
+func Binding(cmd string) (string, bool) {
+	if !strings.HasPrefix(cmd, BindingPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(cmd, BindingPrefix), true
+}
+
+// Emulator sends key events to the system
+type Emulator interface {
+	// EmulateKeyPress presses and releases the key
+	EmulateKeyPress(scanCode uint16) error
+	// EmulateKey presses the key, or releases it if up is true. extended is
+	// set for the keys with an 0xe0 prefix.
+	EmulateKey(scanCode uint16, extended bool, up bool) error
+}
+
+// Player plays macros one at a time. The player is safe for multiple goroutines.
+type Player struct {
+	emulator Emulator
+
+	mu      sync.Mutex
+	playing string
+	cancel  context.CancelFunc
+}
+
+// NewPlayer returns a Player sending key presses to emulator
+func NewPlayer(emulator Emulator) *Player {
+	return &Player{
+		emulator: emulator,
+	}
+}
+
+// Play sends the key events of the macro, and blocks until the macro is done,
+// Stop is called, or ctx is done. Keys left down are released when it returns.
+// Only one macro can play at a time, so a macro that triggers its own hotkey
+// (or another macro) will get ErrPlaying instead of recursing.
+func (p *Player) Play(ctx context.Context, m Macro) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	if p.cancel != nil {
+		p.mu.Unlock()
+		return ErrPlaying
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p.playing = m.Name
+	p.cancel = cancel
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		p.playing = ""
+		p.cancel = nil
+		p.mu.Unlock()
+		cancel()
+	}()
+
+	// keys held down, in the order they were pressed
+	var held []key
+	defer func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			p.emulator.EmulateKey(held[i].scanCode, held[i].extended, true)
+		}
+	}()
+
+	for _, s := range m.Steps {
+		if s.Delay > 0 {
+			select {
+			case <-time.After(time.Duration(s.Delay) * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var err error
+		k := key{scanCode: s.ScanCode, extended: s.Extended}
+		switch {
+		case s.Event == Down:
+			if err = p.emulator.EmulateKey(k.scanCode, k.extended, false); err == nil {
+				held = append(held, k)
+			}
+		case s.Event == Up:
+			if err = p.emulator.EmulateKey(k.scanCode, k.extended, true); err == nil {
+				held = release(held, k)
+			}
+		case s.Extended:
+			if err = p.emulator.EmulateKey(k.scanCode, true, false); err == nil {
+				err = p.emulator.EmulateKey(k.scanCode, true, true)
+			}
+		default:
+			err = p.emulator.EmulateKeyPress(s.ScanCode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// release removes the key from the held keys
+func release(held []key, released key) []key {
+	kept := held[:0]
+	for _, k := range held {
+		if k != released {
+			kept = append(kept, k)
+		}
+	}
+	return kept
+}
+
+// Stop cancels the macro currently playing, if any
+func (p *Player) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cancel != nil {
+		p.cancel()
+	}
+}
+
+// Playing returns the name of the macro currently playing
+func (p *Player) Playing() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.playing, p.cancel != nil
+}
+
+// KeyEvent is a key reported by the keyboard hook
+type KeyEvent struct {
+	ScanCode uint16
+	// Extended is set for the keys with an 0xe0 prefix
+	Extended bool
+	Up       bool
+	// Injected is set for keys sent by software (including our own playback)
+	Injected bool
+}
+
+// Recorder turns key events into macro steps, keeping the delay between them.
+// Key presses and releases are recorded separately, so keys held together
+// (e.g. Ctrl+C) are played the same way. The recorder is safe for multiple
+// goroutines.
+type Recorder struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	recording bool
+	last      time.Time
+	steps     []Step
+	// down is the keys pressed since recording started
+	down map[key]bool
+}
+
+// NewRecorder returns a Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{
+		now: time.Now,
+	}
+}
+
+// Start discards anything recorded so far and starts recording
+func (r *Recorder) Start() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.recording {
+		return ErrRecording
+	}
+	r.recording = true
+	r.steps = nil
+	r.last = time.Time{}
+	r.down = make(map[key]bool)
+	return nil
+}
+
+// Record adds the key event as a step. Injected keys are ignored, so playing a
+// macro while recording does not record the macro. So are the repeats of a
+// held key, and the release of a key pressed before recording started.
+func (r *Recorder) Record(e KeyEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.recording || e.Injected || e.ScanCode == 0 {
+		return
+	}
+
+	k := key{scanCode: e.ScanCode, extended: e.Extended}
+	event := Down
+	if e.Up {
+		if !r.down[k] {
+			return
+		}
+		delete(r.down, k)
+		event = Up
+	} else {
+		if r.down[k] {
+			return
+		}
+		r.down[k] = true
+	}
+
+	now := r.now()
+	var delay uint32
+	if !r.last.IsZero() {
+		delay = uint32(now.Sub(r.last) / time.Millisecond)
+	}
+	r.last = now
+	r.steps = append(r.steps, Step{
+		ScanCode: e.ScanCode,
+		Extended: e.Extended,
+		Delay:    delay,
+		Event:    event,
+	})
+}
+
+// Recording returns true if the recorder was started and not stopped yet
+func (r *Recorder) Recording() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.recording
+}
+
+// Stop stops recording and returns the recorded steps as a macro
+func (r *Recorder) Stop(name string) (Macro, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.recording {
+		return Macro{}, ErrNotRecording
+	}
+	r.recording = false
+
+	m := Macro{
+		Name:  name,
+		Steps: r.steps,
+	}
+	r.steps = nil
+	r.down = nil
+	return m, m.Validate()
+}
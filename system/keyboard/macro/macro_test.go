@@ -0,0 +1,306 @@
+package macro
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeEmulator struct {
+	mu      sync.Mutex
+	pressed []uint16
+	events  []Step
+	onPress func(scanCode uint16)
+}
+
+func (f *fakeEmulator) EmulateKey(scanCode uint16, extended bool, up bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	event := Down
+	if up {
+		event = Up
+	}
+	f.events = append(f.events, Step{ScanCode: scanCode, Extended: extended, Event: event})
+	return nil
+}
+
+func (f *fakeEmulator) Events() []Step {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Step(nil), f.events...)
+}
+
+func (f *fakeEmulator) EmulateKeyPress(scanCode uint16) error {
+	f.mu.Lock()
+	f.pressed = append(f.pressed, scanCode)
+	onPress := f.onPress
+	f.mu.Unlock()
+
+	if onPress != nil {
+		onPress(scanCode)
+	}
+	return nil
+}
+
+func (f *fakeEmulator) Pressed() []uint16 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]uint16(nil), f.pressed...)
+}
+
+var hello = Macro{
+	Name: "hello",
+	Steps: []Step{
+		{ScanCode: 0x23},
+		{ScanCode: 0x12, Delay: 1},
+		{ScanCode: 0x26, Delay: 1},
+	},
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, hello.Validate())
+	require.Error(t, Macro{Steps: hello.Steps}.Validate())
+	require.Error(t, Macro{Name: "a,b", Steps: hello.Steps}.Validate())
+	require.Error(t, Macro{Name: "empty"}.Validate())
+	require.Error(t, Macro{Name: "zero", Steps: []Step{{}}}.Validate())
+	require.Error(t, Macro{Name: "event", Steps: []Step{{ScanCode: 0x1e, Event: "hold"}}}.Validate())
+}
+
+func TestBinding(t *testing.T) {
+	name, ok := Binding("$macro:hello")
+	require.True(t, ok)
+	require.Equal(t, "hello", name)
+
+	_, ok = Binding("$webclient")
+	require.False(t, ok)
+}
+
+func TestPlay(t *testing.T) {
+	e := &fakeEmulator{}
+	p := NewPlayer(e)
+
+	require.NoError(t, p.Play(context.Background(), hello))
+	require.Equal(t, []uint16{0x23, 0x12, 0x26}, e.Pressed())
+
+	_, playing := p.Playing()
+	require.False(t, playing)
+}
+
+func TestPlayHeldKeys(t *testing.T) {
+	e := &fakeEmulator{}
+	p := NewPlayer(e)
+
+	// Ctrl+C, then Shift left down
+	ctrlC := Macro{
+		Name: "copy",
+		Steps: []Step{
+			{ScanCode: 0x1d, Event: Down},
+			{ScanCode: 0x2e, Event: Down},
+			{ScanCode: 0x2e, Event: Up},
+			{ScanCode: 0x1d, Event: Up},
+			{ScanCode: 0x2a, Event: Down},
+		},
+	}
+	require.NoError(t, p.Play(context.Background(), ctrlC))
+	require.Empty(t, e.Pressed())
+	require.Equal(t, []Step{
+		{ScanCode: 0x1d, Event: Down},
+		{ScanCode: 0x2e, Event: Down},
+		{ScanCode: 0x2e, Event: Up},
+		{ScanCode: 0x1d, Event: Up},
+		{ScanCode: 0x2a, Event: Down},
+		// released when done
+		{ScanCode: 0x2a, Event: Up},
+	}, e.Events())
+}
+
+func TestPlayRecursion(t *testing.T) {
+	e := &fakeEmulator{}
+	p := NewPlayer(e)
+
+	var nested error
+	e.onPress = func(uint16) {
+		// e.g. the macro presses its own hotkey
+		if nested == nil {
+			nested = p.Play(context.Background(), hello)
+		}
+	}
+
+	require.NoError(t, p.Play(context.Background(), hello))
+	require.True(t, errors.Is(nested, ErrPlaying))
+	require.Len(t, e.Pressed(), len(hello.Steps))
+}
+
+func TestPlayStop(t *testing.T) {
+	e := &fakeEmulator{}
+	p := NewPlayer(e)
+
+	slow := Macro{
+		Name: "slow",
+		Steps: []Step{
+			{ScanCode: 0x1e},
+			{ScanCode: 0x1f, Delay: 60 * 1000},
+		},
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- p.Play(context.Background(), slow)
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(e.Pressed()) == 1
+	}, time.Second, time.Millisecond)
+
+	name, playing := p.Playing()
+	require.True(t, playing)
+	require.Equal(t, "slow", name)
+
+	p.Stop()
+	select {
+	case err := <-done:
+		require.True(t, errors.Is(err, context.Canceled))
+	case <-time.After(time.Second):
+		t.Fatal("macro was not cancelled")
+	}
+	require.Equal(t, []uint16{0x1e}, e.Pressed())
+}
+
+func TestPlayStopReleases(t *testing.T) {
+	e := &fakeEmulator{}
+	p := NewPlayer(e)
+
+	held := Macro{
+		Name: "held",
+		Steps: []Step{
+			{ScanCode: 0x1d, Event: Down},
+			{ScanCode: 0x1f, Delay: 60 * 1000},
+		},
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- p.Play(context.Background(), held)
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(e.Events()) == 1
+	}, time.Second, time.Millisecond)
+
+	p.Stop()
+	require.True(t, errors.Is(<-done, context.Canceled))
+	require.Equal(t, []Step{
+		{ScanCode: 0x1d, Event: Down},
+		{ScanCode: 0x1d, Event: Up},
+	}, e.Events())
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+	clock := time.Unix(0, 0)
+	r.now = func() time.Time {
+		return clock
+	}
+
+	_, err := r.Stop("nothing")
+	require.True(t, errors.Is(err, ErrNotRecording))
+
+	// not recording yet
+	r.Record(KeyEvent{ScanCode: 0x10})
+
+	require.NoError(t, r.Start())
+	require.True(t, errors.Is(r.Start(), ErrRecording))
+	require.True(t, r.Recording())
+
+	// pressed before recording started
+	r.Record(KeyEvent{ScanCode: 0x10, Up: true})
+
+	r.Record(KeyEvent{ScanCode: 0x23})
+	clock = clock.Add(time.Millisecond * 5)
+	r.Record(KeyEvent{ScanCode: 0x23, Up: true})
+	clock = clock.Add(time.Millisecond * 120)
+	r.Record(KeyEvent{ScanCode: 0x2a})
+	clock = clock.Add(time.Millisecond * 30)
+	// repeated while held
+	r.Record(KeyEvent{ScanCode: 0x2a})
+	r.Record(KeyEvent{ScanCode: 0x26, Injected: true})
+	clock = clock.Add(time.Millisecond * 10)
+	r.Record(KeyEvent{ScanCode: 0x26})
+	r.Record(KeyEvent{ScanCode: 0x26, Up: true})
+	clock = clock.Add(time.Millisecond * 20)
+	r.Record(KeyEvent{ScanCode: 0x2a, Up: true})
+
+	m, err := r.Stop("hi")
+	require.NoError(t, err)
+	require.False(t, r.Recording())
+	require.Equal(t, Macro{
+		Name: "hi",
+		Steps: []Step{
+			{ScanCode: 0x23, Event: Down},
+			{ScanCode: 0x23, Delay: 5, Event: Up},
+			{ScanCode: 0x2a, Delay: 120, Event: Down},
+			{ScanCode: 0x26, Delay: 40, Event: Down},
+			{ScanCode: 0x26, Event: Up},
+			{ScanCode: 0x2a, Delay: 20, Event: Up},
+		},
+	}, m)
+}
+
+func TestRecordReplayExtended(t *testing.T) {
+	r := NewRecorder()
+	clock := time.Unix(0, 0)
+	r.now = func() time.Time {
+		return clock
+	}
+	require.NoError(t, r.Start())
+
+	// the Up arrow shares its scan code with numpad 8, so both are held
+	// independently
+	r.Record(KeyEvent{ScanCode: 0x48, Extended: true})
+	r.Record(KeyEvent{ScanCode: 0x48})
+	r.Record(KeyEvent{ScanCode: 0x48, Extended: true, Up: true})
+	r.Record(KeyEvent{ScanCode: 0x48, Up: true})
+	// right Ctrl held over the recording
+	r.Record(KeyEvent{ScanCode: 0x1d, Extended: true})
+
+	m, err := r.Stop("arrows")
+	require.NoError(t, err)
+	require.Equal(t, []Step{
+		{ScanCode: 0x48, Extended: true, Event: Down},
+		{ScanCode: 0x48, Event: Down},
+		{ScanCode: 0x48, Extended: true, Event: Up},
+		{ScanCode: 0x48, Event: Up},
+		{ScanCode: 0x1d, Extended: true, Event: Down},
+	}, m.Steps)
+
+	b, err := json.Marshal(m)
+	require.NoError(t, err)
+	var loaded Macro
+	require.NoError(t, json.Unmarshal(b, &loaded))
+	require.Equal(t, m, loaded)
+
+	// an extended press edited by hand
+	loaded.Steps = append(loaded.Steps, Step{ScanCode: 0x4b, Extended: true})
+
+	e := &fakeEmulator{}
+	p := NewPlayer(e)
+	require.NoError(t, p.Play(context.Background(), loaded))
+	require.Equal(t, []Step{
+		{ScanCode: 0x48, Extended: true, Event: Down},
+		{ScanCode: 0x48, Event: Down},
+		{ScanCode: 0x48, Extended: true, Event: Up},
+		{ScanCode: 0x48, Event: Up},
+		{ScanCode: 0x1d, Extended: true, Event: Down},
+		{ScanCode: 0x4b, Extended: true, Event: Down},
+		{ScanCode: 0x4b, Extended: true, Event: Up},
+		// released when the macro ends
+		{ScanCode: 0x1d, Extended: true, Event: Up},
+	}, e.Events())
+	require.Empty(t, e.Pressed())
+}
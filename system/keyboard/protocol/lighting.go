@@ -0,0 +1,97 @@
+package protocol
+
+import "fmt"
+
+// Mode defines the lighting effect
+type Mode byte
+
+// Defines the lighting modes
+const (
+	ModeStatic     Mode = 0x00
+	ModeBreathing  Mode = 0x01
+	ModeColorCycle Mode = 0x02
+	ModeStrobe     Mode = 0x0a // called "pulse" by some tools
+)
+
+// Speed defines how fast an animated effect runs
+type Speed byte
+
+// Defines the effect speeds
+const (
+	SpeedLow    Speed = 0xe1
+	SpeedMedium Speed = 0xeb
+	SpeedHigh   Speed = 0xf5
+)
+
+// Direction defines which way an animated effect travels
+type Direction byte
+
+// Defines the effect directions
+const (
+	DirectionRight Direction = 0x00
+	DirectionLeft  Direction = 0x01
+	DirectionUp    Direction = 0x02
+	DirectionDown  Direction = 0x03
+)
+
+// Color is a RGB color
+type Color struct {
+	R byte `json:"r"`
+	G byte `json:"g"`
+	B byte `json:"b"`
+}
+
+func (c Color) String() string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// SetLightingMode sets the lighting effect of a zone (0 for the whole keyboard).
+// The effect only takes effect after ApplyLighting is sent.
+type SetLightingMode struct {
+	Zone      byte
+	Mode      Mode
+	Color     Color
+	Speed     Speed
+	Direction Direction
+}
+
+// Encode satisfies Message
+func (m SetLightingMode) Encode() []byte {
+	return report(cmdLightingMode,
+		m.Zone,
+		byte(m.Mode),
+		m.Color.R, m.Color.G, m.Color.B,
+		byte(m.Speed),
+		byte(m.Direction),
+	)
+}
+
+func decodeLightingMode(args []byte) (Message, error) {
+	if len(args) < 7 {
+		return nil, fmt.Errorf("%w: lighting mode is too short", ErrInvalidReport)
+	}
+	return SetLightingMode{
+		Zone:      args[0],
+		Mode:      Mode(args[1]),
+		Color:     Color{R: args[2], G: args[3], B: args[4]},
+		Speed:     Speed(args[5]),
+		Direction: Direction(args[6]),
+	}, nil
+}
+
+// ApplyLighting applies the lighting modes set previously
+type ApplyLighting struct{}
+
+// Encode satisfies Message
+func (m ApplyLighting) Encode() []byte {
+	return report(cmdLightingApply)
+}
+
+// SaveLighting persists the applied lighting modes in the keyboard, so they
+// survive a reboot
+type SaveLighting struct{}
+
+// Encode satisfies Message
+func (m SaveLighting) Encode() []byte {
+	return report(cmdLightingSave)
+}
@@ -0,0 +1,155 @@
+// Package protocol encodes and decodes the feature reports understood by the
+// N-Key keyboard control interface (report ID 0x5a).
+//
+// The initialization sequence was captured via API Monitor, also referencing
+// https://github.com/flukejones/rog-core/blob/master/kernel-patch/0001-HID-asus-add-support-for-ASUS-N-Key-keyboard-v5.8.patch
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Defines the report ID and the length every report is padded to
+const (
+	ReportID     = 0x5a
+	ReportLength = 64
+)
+
+// Defines the command byte (the byte after the report ID) of each message
+const (
+	cmdInitHandshake   byte = 0x89
+	cmdInitVendor      byte = 0x41 // 'A' of "ASUS Tech.Inc."
+	cmdInitLighting    byte = 0x05
+	cmdBrightness      byte = 0xba
+	cmdTouchpad        byte = 0xf4
+	cmdLightingMode    byte = 0xb3
+	cmdLightingApply   byte = 0xb4
+	cmdLightingSave    byte = 0xb5
+	vendorString            = "ASUS Tech.Inc."
+	touchpadToggleCode byte = 0x6b
+)
+
+var (
+	initLightingArgs = []byte{0x20, 0x31, 0x00, 0x08}
+	brightnessArgs   = []byte{0xc5, 0xc4}
+)
+
+// ErrInvalidReport is returned when the report is not an N-Key control report
+var ErrInvalidReport = errors.New("protocol: invalid report")
+
+// ErrUnknownMessage is returned when the report is valid but the message is not known
+var ErrUnknownMessage = errors.New("protocol: unknown message")
+
+// Message is a command to the keyboard control interface
+type Message interface {
+	// Encode returns the report, padded to ReportLength
+	Encode() []byte
+}
+
+// InitStage defines the stages of the initialization sequence
+type InitStage byte
+
+// Defines the initialization stages, in the order they should be sent
+const (
+	InitHandshake InitStage = InitStage(cmdInitHandshake)
+	InitVendor    InitStage = InitStage(cmdInitVendor)
+	InitLighting  InitStage = InitStage(cmdInitLighting)
+)
+
+// Init initializes the control interface for backlight control and
+// disabling/enabling touchpad. See InitSequence.
+type Init struct {
+	Stage InitStage
+}
+
+// InitSequence returns the messages to be sent prior to any other message,
+// and after ACPI resume
+func InitSequence() []Message {
+	return []Message{
+		Init{Stage: InitHandshake},
+		Init{Stage: InitVendor},
+		Init{Stage: InitLighting},
+	}
+}
+
+// Encode satisfies Message
+func (m Init) Encode() []byte {
+	switch m.Stage {
+	case InitVendor:
+		return report(cmdInitVendor, []byte(vendorString)[1:]...)
+	case InitLighting:
+		return report(cmdInitLighting, initLightingArgs...)
+	default:
+		return report(cmdInitHandshake)
+	}
+}
+
+// SetBrightness sets the keyboard backlight level
+type SetBrightness struct {
+	Level byte
+}
+
+// Encode satisfies Message
+func (m SetBrightness) Encode() []byte {
+	return report(cmdBrightness, brightnessArgs[0], brightnessArgs[1], m.Level)
+}
+
+// ToggleTouchpad toggles enabling/disabling the touchpad.
+// Note: there is no message to set (or query) the state directly.
+type ToggleTouchpad struct{}
+
+// Encode satisfies Message
+func (m ToggleTouchpad) Encode() []byte {
+	return report(cmdTouchpad, touchpadToggleCode)
+}
+
+// Decode returns the Message encoded in the report
+func Decode(b []byte) (Message, error) {
+	if len(b) < 2 || b[0] != ReportID {
+		return nil, ErrInvalidReport
+	}
+	args := b[2:]
+
+	switch b[1] {
+	case cmdInitHandshake:
+		return Init{Stage: InitHandshake}, nil
+	case cmdInitVendor:
+		if !bytes.HasPrefix(args, []byte(vendorString)[1:]) {
+			return nil, fmt.Errorf("%w: unexpected vendor string", ErrInvalidReport)
+		}
+		return Init{Stage: InitVendor}, nil
+	case cmdInitLighting:
+		if !bytes.HasPrefix(args, initLightingArgs) {
+			return nil, fmt.Errorf("%w: unexpected lighting initialization", ErrInvalidReport)
+		}
+		return Init{Stage: InitLighting}, nil
+	case cmdBrightness:
+		if len(args) < 3 || !bytes.HasPrefix(args, brightnessArgs) {
+			return nil, fmt.Errorf("%w: unexpected brightness arguments", ErrInvalidReport)
+		}
+		return SetBrightness{Level: args[2]}, nil
+	case cmdTouchpad:
+		if len(args) < 1 || args[0] != touchpadToggleCode {
+			return nil, fmt.Errorf("%w: unexpected touchpad arguments", ErrInvalidReport)
+		}
+		return ToggleTouchpad{}, nil
+	case cmdLightingMode:
+		return decodeLightingMode(args)
+	case cmdLightingApply:
+		return ApplyLighting{}, nil
+	case cmdLightingSave:
+		return SaveLighting{}, nil
+	default:
+		return nil, fmt.Errorf("%w: 0x%02x", ErrUnknownMessage, b[1])
+	}
+}
+
+func report(cmd byte, args ...byte) []byte {
+	b := make([]byte, ReportLength)
+	b[0] = ReportID
+	b[1] = cmd
+	copy(b[2:], args)
+	return b
+}
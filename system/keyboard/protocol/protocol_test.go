@@ -0,0 +1,169 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func padded(b ...byte) []byte {
+	out := make([]byte, ReportLength)
+	copy(out, b)
+	return out
+}
+
+// golden reports are written out by hand from sources that do not share the
+// encoder:
+//   - hid-asus: drivers/hid/hid-asus.c in Linux, which sends FEATURE_KBD_REPORT_ID
+//     (0x5a) reports in asus_kbd_init, asus_kbd_get_functions and
+//     asus_kbd_backlight_set
+//   - G14Manager: the buffers hardcoded in cxx/plugin/keyboard before this
+//     package, inherited from https://github.com/zllovesuki/G14Manager
+//   - asusctl: rog-aura in https://gitlab.com/asus-linux/asusctl, whose
+//     LED_APPLY, LED_SET and AuraEffect reports use the same layout after the
+//     report ID. asusctl writes them to the LED collection as 0x5d, so only the
+//     report ID is replaced with 0x5a here.
+var golden = []struct {
+	name   string
+	msg    Message
+	report []byte
+}{
+	{
+		// G14Manager
+		name:   "init handshake",
+		msg:    Init{Stage: InitHandshake},
+		report: padded(0x5a, 0x89),
+	},
+	{
+		// hid-asus asus_kbd_init: "ASUS Tech.Inc."
+		name: "init vendor",
+		msg:  Init{Stage: InitVendor},
+		report: padded(
+			0x5a, 0x41, 0x53, 0x55, 0x53, 0x20, 0x54, 0x65,
+			0x63, 0x68, 0x2e, 0x49, 0x6e, 0x63, 0x2e,
+		),
+	},
+	{
+		// hid-asus asus_kbd_get_functions
+		name:   "init lighting",
+		msg:    Init{Stage: InitLighting},
+		report: padded(0x5a, 0x05, 0x20, 0x31, 0x00, 0x08),
+	},
+	{
+		// hid-asus asus_kbd_backlight_set
+		name:   "brightness off",
+		msg:    SetBrightness{Level: 0},
+		report: padded(0x5a, 0xba, 0xc5, 0xc4, 0x00),
+	},
+	{
+		// hid-asus asus_kbd_backlight_set
+		name:   "brightness high",
+		msg:    SetBrightness{Level: 3},
+		report: padded(0x5a, 0xba, 0xc5, 0xc4, 0x03),
+	},
+	{
+		// G14Manager
+		name:   "toggle touchpad",
+		msg:    ToggleTouchpad{},
+		report: padded(0x5a, 0xf4, 0x6b),
+	},
+	{
+		// asusctl: zone, mode, RGB, speed (0xe1 low, 0xeb med, 0xf5 high),
+		// direction (right, left, up, down)
+		name: "static red",
+		msg: SetLightingMode{
+			Mode:  ModeStatic,
+			Color: Color{R: 0xff},
+			Speed: SpeedLow,
+		},
+		report: padded(0x5a, 0xb3, 0x00, 0x00, 0xff, 0x00, 0x00, 0xe1, 0x00),
+	},
+	{
+		// asusctl: breathe is mode 1
+		name: "breathing blue medium up",
+		msg: SetLightingMode{
+			Mode:      ModeBreathing,
+			Color:     Color{B: 0xff},
+			Speed:     SpeedMedium,
+			Direction: DirectionUp,
+		},
+		report: padded(0x5a, 0xb3, 0x00, 0x01, 0x00, 0x00, 0xff, 0xeb, 0x02),
+	},
+	{
+		// asusctl: the color cycle is mode 2 ("strobe" in asusctl)
+		name: "color cycle left",
+		msg: SetLightingMode{
+			Mode:      ModeColorCycle,
+			Speed:     SpeedHigh,
+			Direction: DirectionLeft,
+		},
+		report: padded(0x5a, 0xb3, 0x00, 0x02, 0x00, 0x00, 0x00, 0xf5, 0x01),
+	},
+	{
+		// asusctl: the strobe is mode 10 ("pulse" in asusctl)
+		name: "strobe green down",
+		msg: SetLightingMode{
+			Mode:      ModeStrobe,
+			Color:     Color{G: 0xff},
+			Speed:     SpeedLow,
+			Direction: DirectionDown,
+		},
+		report: padded(0x5a, 0xb3, 0x00, 0x0a, 0x00, 0xff, 0x00, 0xe1, 0x03),
+	},
+	{
+		// asusctl LED_APPLY
+		name:   "apply lighting",
+		msg:    ApplyLighting{},
+		report: padded(0x5a, 0xb4),
+	},
+	{
+		// asusctl LED_SET
+		name:   "save lighting",
+		msg:    SaveLighting{},
+		report: padded(0x5a, 0xb5),
+	},
+}
+
+func TestEncodeGolden(t *testing.T) {
+	for _, tc := range golden {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.report, tc.msg.Encode())
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, tc := range golden {
+		t.Run(tc.name, func(t *testing.T) {
+			decoded, err := Decode(tc.msg.Encode())
+			require.NoError(t, err)
+			require.Equal(t, tc.msg, decoded)
+		})
+	}
+}
+
+func TestInitSequence(t *testing.T) {
+	seq := InitSequence()
+	require.Len(t, seq, 3)
+	require.Equal(t, golden[0].report, seq[0].Encode())
+	require.Equal(t, golden[1].report, seq[1].Encode())
+	require.Equal(t, golden[2].report, seq[2].Encode())
+}
+
+func TestDecodeErrors(t *testing.T) {
+	_, err := Decode(nil)
+	require.True(t, errors.Is(err, ErrInvalidReport))
+
+	_, err = Decode(padded(0x5d, 0xb3))
+	require.True(t, errors.Is(err, ErrInvalidReport))
+
+	_, err = Decode(padded(0x5a, 0xba, 0x00, 0x00, 0x01))
+	require.True(t, errors.Is(err, ErrInvalidReport))
+
+	_, err = Decode([]byte{0x5a, 0xb3, 0x00})
+	require.True(t, errors.Is(err, ErrInvalidReport))
+
+	_, err = Decode(padded(0x5a, 0x42))
+	require.True(t, errors.Is(err, ErrUnknownMessage))
+}
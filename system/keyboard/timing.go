@@ -0,0 +1,67 @@
+package keyboard
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Fades defines the duration of brightness transitions on wake (also after
+// being idle), when the backlight is turned off (on suspend or when idle), and
+// on Fn+Up/Down. A zero duration changes the brightness immediately. They are
+// encoded in JSON in milliseconds.
+type Fades struct {
+	Wake time.Duration
+	Off  time.Duration
+	Keys time.Duration
+}
+
+type fadesJSON struct {
+	Wake int64 `json:"wake"`
+	Off  int64 `json:"off"`
+	Keys int64 `json:"keys"`
+}
+
+// MarshalJSON encodes the durations in milliseconds
+func (f Fades) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fadesJSON{
+		Wake: f.Wake.Milliseconds(),
+		Off:  f.Off.Milliseconds(),
+		Keys: f.Keys.Milliseconds(),
+	})
+}
+
+// UnmarshalJSON decodes the durations in milliseconds. Missing durations are
+// left alone.
+func (f *Fades) UnmarshalJSON(b []byte) error {
+	v := fadesJSON{
+		Wake: f.Wake.Milliseconds(),
+		Off:  f.Off.Milliseconds(),
+		Keys: f.Keys.Milliseconds(),
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	f.Wake = time.Duration(v.Wake) * time.Millisecond
+	f.Off = time.Duration(v.Off) * time.Millisecond
+	f.Keys = time.Duration(v.Keys) * time.Millisecond
+	return nil
+}
+
+// IdleTimeout is how long there must be no input before the backlight fades
+// out, and 0 keeps it on. It is encoded in JSON in seconds.
+type IdleTimeout time.Duration
+
+// MarshalJSON encodes the timeout in seconds
+func (t IdleTimeout) MarshalJSON() ([]byte, error) {
+	return json.Marshal(int64(time.Duration(t) / time.Second))
+}
+
+// UnmarshalJSON decodes the timeout in seconds
+func (t *IdleTimeout) UnmarshalJSON(b []byte) error {
+	var seconds int64
+	if err := json.Unmarshal(b, &seconds); err != nil {
+		return err
+	}
+	*t = IdleTimeout(time.Duration(seconds) * time.Second)
+	return nil
+}
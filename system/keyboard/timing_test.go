@@ -0,0 +1,53 @@
+package keyboard
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFadesJSON(t *testing.T) {
+	f := Fades{
+		Wake: time.Millisecond * 600,
+		Off:  time.Millisecond * 300,
+		Keys: 0,
+	}
+
+	buf, err := json.Marshal(f)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"wake":600,"off":300,"keys":0}`, string(buf))
+
+	var loaded Fades
+	require.NoError(t, json.Unmarshal(buf, &loaded))
+	require.Equal(t, f, loaded)
+
+	// missing durations are kept
+	require.NoError(t, json.Unmarshal([]byte(`{"keys":150}`), &loaded))
+	require.Equal(t, Fades{
+		Wake: time.Millisecond * 600,
+		Off:  time.Millisecond * 300,
+		Keys: time.Millisecond * 150,
+	}, loaded)
+
+	require.Error(t, json.Unmarshal([]byte(`{"wake":"600ms"}`), &loaded))
+}
+
+func TestIdleTimeoutJSON(t *testing.T) {
+	config := struct {
+		IdleTimeout IdleTimeout `json:"idleTimeout"`
+	}{
+		IdleTimeout: IdleTimeout(time.Minute * 2),
+	}
+
+	buf, err := json.Marshal(config)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"idleTimeout":120}`, string(buf))
+
+	config.IdleTimeout = 0
+	require.NoError(t, json.Unmarshal(buf, &config))
+	require.Equal(t, IdleTimeout(time.Minute*2), config.IdleTimeout)
+
+	require.Error(t, json.Unmarshal([]byte(`{"idleTimeout":"2m"}`), &config))
+}
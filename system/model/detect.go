@@ -0,0 +1,52 @@
+package model
+
+import (
+	"log"
+
+	"github.com/karalabe/usb"
+	"golang.org/x/sys/windows/registry"
+)
+
+const (
+	biosKeyPath        = `HARDWARE\DESCRIPTION\System\BIOS`
+	productNameValue   = "SystemProductName"
+	fallbackModelIndex = 0
+)
+
+// Detect picks the model from the DMI product name. If the product name is unknown,
+// the model is picked by probing for the N-Key keyboard. If that fails as well,
+// the first entry of Models is returned.
+func Detect() *Model {
+	productName, err := readProductName()
+	if err != nil {
+		log.Printf("model: unable to read product name: %s\n", err)
+	} else if m, ok := LookupProductName(productName); ok {
+		log.Printf("model: detected %s (%s)\n", m, productName)
+		return m
+	}
+
+	if m, ok := LookupHID(probeHID); ok {
+		log.Printf("model: detected %s from keyboard %04x:%04x (product name: %s)\n", m, m.VendorID, m.ProductID, productName)
+		return m
+	}
+
+	m := &Models[fallbackModelIndex]
+	log.Printf("model: unknown model \"%s\", falling back to %s\n", productName, m)
+	return m
+}
+
+func readProductName() (string, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, biosKeyPath, registry.QUERY_VALUE)
+	if err != nil {
+		return "", err
+	}
+	defer key.Close()
+
+	name, _, err := key.GetStringValue(productNameValue)
+	return name, err
+}
+
+func probeHID(vendorID, productID uint16) bool {
+	devices, err := usb.EnumerateHid(vendorID, productID)
+	return err == nil && len(devices) > 0
+}
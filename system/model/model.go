@@ -0,0 +1,158 @@
+package model
+
+import (
+	"strings"
+
+	"github.com/NeilSeligmann/G15Manager/system/atkacpi"
+	"github.com/NeilSeligmann/G15Manager/system/keyboard"
+)
+
+// Model declares what a laptop model supports, so a single binary can drive
+// different Zephyrus variants.
+type Model struct {
+	Name string
+	// ProductNames are matched against the DMI product name (e.g. "ROG Zephyrus G15 GA503QR_GA503QR")
+	ProductNames []string
+	// VendorID/ProductID of the N-Key keyboard
+	VendorID  uint16
+	ProductID uint16
+	// Keys are the key codes the N-Key keyboard reports on this model
+	Keys []uint32
+	// BrightnessLevels is the number of keyboard backlight levels, including off
+	BrightnessLevels int
+	Devices          Devices
+	ChargeLimit      Bounds
+	// FanCurve indicates if custom fan curves can be set
+	FanCurve bool
+	// Aura indicates if every SKU of the model has an RGB keyboard. Most ship
+	// with a single color backlight, so it can also be enabled in the keyboard config.
+	Aura bool
+}
+
+// Devices defines the ATK device IDs (IIA0) used with DEVS/DSTS
+type Devices struct {
+	HardwareCtrl       uint32
+	BatteryChargeLimit uint32
+	ThrottleCtrl       uint32
+	CPUFanCurve        uint32
+	GPUFanCurve        uint32
+	CheckCharger       uint32
+	// GPUEco disables the dGPU in firmware, GPUMux routes the panel to the
+	// dGPU. Either is reported as missing by DSTS if the model lacks it.
+	GPUEco uint32
+	GPUMux uint32
+	// PanelOverdrive speeds up the pixel response of the internal panel
+	PanelOverdrive uint32
+}
+
+// Bounds defines the inclusive range of a setting
+type Bounds struct {
+	Min uint8
+	Max uint8
+}
+
+var (
+	defaultKeys = []uint32{
+		keyboard.KeyROG,
+		keyboard.KeyFnF4,
+		keyboard.KeyFnF5,
+		keyboard.KeyVolUp,
+		keyboard.KeyVolDown,
+		keyboard.KeyMuteMic,
+		keyboard.KeyTpadToggle,
+		keyboard.KeyLCDUp,
+		keyboard.KeyLCDDown,
+		keyboard.KeySleep,
+		keyboard.KeyRFKill,
+		keyboard.KeyFnUp,
+		keyboard.KeyFnDown,
+		keyboard.KeyFnC,
+		keyboard.KeyFnV,
+	}
+	defaultDevices = Devices{
+		HardwareCtrl:       atkacpi.DevsHardwareCtrl,
+		BatteryChargeLimit: atkacpi.DevsBatteryChargeLimit,
+		ThrottleCtrl:       atkacpi.DevsThrottleCtrl,
+		CPUFanCurve:        atkacpi.DevsCPUFanCurve,
+		GPUFanCurve:        atkacpi.DevsGPUFanCurve,
+		CheckCharger:       atkacpi.DstsCheckCharger,
+		GPUEco:             atkacpi.DevsGPUEco,
+		GPUMux:             atkacpi.DevsGPUMux,
+		PanelOverdrive:     atkacpi.DevsPanelOverdrive,
+	}
+)
+
+// Models is the list of known models. The first entry is used as the fallback
+// when the model cannot be detected.
+var Models = []Model{
+	{
+		Name:             "Zephyrus G15 (2021)",
+		ProductNames:     []string{"GA503Q"},
+		VendorID:         keyboard.VendorID,
+		ProductID:        0x19b6,
+		Keys:             defaultKeys,
+		BrightnessLevels: 4,
+		Devices:          defaultDevices,
+		ChargeLimit:      Bounds{Min: 40, Max: 100},
+		FanCurve:         true,
+	},
+	{
+		Name:             "Zephyrus G14 (2021)",
+		ProductNames:     []string{"GA401Q"},
+		VendorID:         keyboard.VendorID,
+		ProductID:        0x19b6,
+		Keys:             defaultKeys,
+		BrightnessLevels: 4,
+		Devices:          defaultDevices,
+		ChargeLimit:      Bounds{Min: 40, Max: 100},
+		FanCurve:         true,
+	},
+	{
+		Name:             "Zephyrus G14 (2020)",
+		ProductNames:     []string{"GA401I"},
+		VendorID:         keyboard.VendorID,
+		ProductID:        0x1866,
+		Keys:             defaultKeys,
+		BrightnessLevels: 4,
+		Devices:          defaultDevices,
+		ChargeLimit:      Bounds{Min: 40, Max: 100},
+		FanCurve:         true,
+	},
+}
+
+// HasKey returns true if the N-Key keyboard on this model reports the key code
+func (m *Model) HasKey(keyCode uint32) bool {
+	for _, k := range m.Keys {
+		if k == keyCode {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Model) String() string {
+	return m.Name
+}
+
+// LookupProductName returns the model matching the DMI product name
+func LookupProductName(productName string) (*Model, bool) {
+	productName = strings.ToUpper(productName)
+	for i := range Models {
+		for _, name := range Models[i].ProductNames {
+			if strings.Contains(productName, strings.ToUpper(name)) {
+				return &Models[i], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// LookupHID returns the first model whose N-Key keyboard is present according to probe
+func LookupHID(probe func(vendorID, productID uint16) bool) (*Model, bool) {
+	for i := range Models {
+		if probe(Models[i].VendorID, Models[i].ProductID) {
+			return &Models[i], true
+		}
+	}
+	return nil, false
+}
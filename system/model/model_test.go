@@ -0,0 +1,45 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/NeilSeligmann/G15Manager/system/keyboard"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupProductName(t *testing.T) {
+	m, ok := LookupProductName("ROG Zephyrus G15 GA503QR_GA503QR")
+	require.True(t, ok)
+	require.Equal(t, uint16(0x19b6), m.ProductID)
+
+	m, ok = LookupProductName("rog zephyrus g14 ga401iv_ga401iv")
+	require.True(t, ok)
+	require.Equal(t, uint16(0x1866), m.ProductID)
+
+	_, ok = LookupProductName("System Product Name")
+	require.False(t, ok)
+}
+
+func TestLookupHID(t *testing.T) {
+	m, ok := LookupHID(func(vendorID, productID uint16) bool {
+		return vendorID == keyboard.VendorID && productID == 0x1866
+	})
+	require.True(t, ok)
+	require.Equal(t, "Zephyrus G14 (2020)", m.Name)
+
+	_, ok = LookupHID(func(vendorID, productID uint16) bool {
+		return false
+	})
+	require.False(t, ok)
+}
+
+func TestModelsAreComplete(t *testing.T) {
+	for _, m := range Models {
+		require.NotEmpty(t, m.ProductNames, m.Name)
+		require.NotZero(t, m.ProductID, m.Name)
+		require.True(t, m.HasKey(keyboard.KeyROG), m.Name)
+		require.True(t, m.BrightnessLevels > 1, m.Name)
+		require.True(t, m.ChargeLimit.Min <= m.ChargeLimit.Max, m.Name)
+		require.NotZero(t, m.Devices.BatteryChargeLimit, m.Name)
+	}
+}
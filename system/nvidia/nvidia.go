@@ -0,0 +1,218 @@
+// Package nvidia queries the NVIDIA GPU with nvidia-smi.
+package nvidia
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const runTimeout = time.Second * 5
+
+// Runner runs nvidia-smi with the given arguments, and returns its output
+type Runner interface {
+	Run(ctx context.Context, args ...string) ([]byte, error)
+}
+
+// Process is a process using the GPU. Type is "C" (compute), "G" (graphics)
+// or "C+G", and UsedMemory is in MiB, or 0 if not reported (e.g. with the WDDM
+// driver model).
+type Process struct {
+	PID        int    `json:"pid"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	UsedMemory int    `json:"usedMemory"`
+}
+
+// ExeName returns the executable name of the process, without its path
+func (p Process) ExeName() string {
+	if i := strings.LastIndexAny(p.Name, `\/`); i >= 0 {
+		return p.Name[i+1:]
+	}
+	return p.Name
+}
+
+// ParseComputeApps parses the output of
+// "nvidia-smi --query-compute-apps=pid,process_name,used_memory --format=csv,noheader,nounits"
+func ParseComputeApps(out []byte) ([]Process, error) {
+	r := csv.NewReader(bytes.NewReader(out))
+	r.TrimLeadingSpace = true
+	r.FieldsPerRecord = 3
+
+	var processes []Process
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		pid, err := strconv.Atoi(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("nvidia: invalid pid %q", record[0])
+		}
+		processes = append(processes, Process{
+			PID:        pid,
+			Name:       record[1],
+			Type:       "C",
+			UsedMemory: parseMemory(record[2]),
+		})
+	}
+	return processes, nil
+}
+
+// ParseQueryPIDs parses the processes in the output of "nvidia-smi -q -d PIDS"
+func ParseQueryPIDs(out []byte) ([]Process, error) {
+	var processes []Process
+	var current *Process
+
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		key, value, ok := splitField(s.Text())
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Process ID":
+			pid, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("nvidia: invalid pid %q", value)
+			}
+			processes = append(processes, Process{PID: pid})
+			current = &processes[len(processes)-1]
+		case "Type":
+			if current != nil {
+				current.Type = value
+			}
+		case "Name", "Process Name":
+			if current != nil {
+				current.Name = value
+			}
+		case "Used GPU Memory":
+			if current != nil {
+				current.UsedMemory = parseMemory(value)
+			}
+		}
+	}
+	return processes, s.Err()
+}
+
+// splitField splits "    Process ID      : 1234" into its key and value
+func splitField(line string) (key, value string, ok bool) {
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]), true
+}
+
+// parseMemory parses "120" or "120 MiB", and returns 0 for "[N/A]" and such
+func parseMemory(s string) int {
+	v, err := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(s, "MiB")))
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// SMI queries nvidia-smi, and caches the results since spawning it takes a
+// while. A single SMI should be shared by the consumers. The SMI is safe for
+// multiple goroutines.
+type SMI struct {
+	runner       Runner
+	ttl          time.Duration
+	processesTTL time.Duration
+	now          func() time.Time
+
+	mu          sync.Mutex
+	processes   []Process
+	processErr  error
+	processesAt time.Time
+
+	telemetry    Telemetry
+	telemetryErr error
+	telemetryAt  time.Time
+}
+
+// NewSMI returns an SMI running nvidia-smi with runner. The telemetry is
+// cached for ttl, and the processes for processesTTL, as listing them spawns
+// nvidia-smi twice.
+func NewSMI(runner Runner, ttl, processesTTL time.Duration) *SMI {
+	return &SMI{
+		runner:       runner,
+		ttl:          ttl,
+		processesTTL: processesTTL,
+		now:          time.Now,
+	}
+}
+
+// Invalidate discards the cached results, e.g. before a decision that needs
+// fresh data
+func (s *SMI) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.processesAt = time.Time{}
+	s.telemetryAt = time.Time{}
+}
+
+// Processes returns the processes using the GPU (compute and graphics), sorted by PID
+func (s *SMI) Processes(ctx context.Context) ([]Process, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.processesAt.IsZero() && s.now().Sub(s.processesAt) < s.processesTTL {
+		return s.processes, s.processErr
+	}
+
+	s.processes, s.processErr = s.queryProcesses(ctx)
+	s.processesAt = s.now()
+	return s.processes, s.processErr
+}
+
+func (s *SMI) queryProcesses(ctx context.Context) ([]Process, error) {
+	ctx, cancel := context.WithTimeout(ctx, runTimeout)
+	defer cancel()
+
+	out, err := s.runner.Run(ctx, "--query-compute-apps=pid,process_name,used_memory", "--format=csv,noheader,nounits")
+	if err != nil {
+		return nil, err
+	}
+	compute, err := ParseComputeApps(out)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err = s.runner.Run(ctx, "-q", "-d", "PIDS")
+	if err != nil {
+		return nil, err
+	}
+	all, err := ParseQueryPIDs(out)
+	if err != nil {
+		return nil, err
+	}
+
+	// the query lists compute apps as well, with their type
+	seen := make(map[int]bool, len(all))
+	for _, p := range all {
+		seen[p.PID] = true
+	}
+	for _, p := range compute {
+		if !seen[p.PID] {
+			all = append(all, p)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].PID < all[j].PID
+	})
+	return all, nil
+}
@@ -0,0 +1,176 @@
+package nvidia
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const queryPIDs = `
+==============NVSMI LOG==============
+
+Timestamp                                 : Mon Aug 16 12:00:00 2021
+Driver Version                            : 471.41
+CUDA Version                              : 11.4
+
+Attached GPUs                             : 1
+GPU 00000000:01:00.0
+    Processes
+        GPU instance ID                   : N/A
+        Compute instance ID               : N/A
+        Process ID                        : 5812
+            Type                          : C+G
+            Name                          : C:\Program Files\obs-studio\bin\64bit\obs64.exe
+            Used GPU Memory               : Not available in WDDM driver model
+        GPU instance ID                   : N/A
+        Compute instance ID               : N/A
+        Process ID                        : 1204
+            Type                          : G
+            Name                          : C:\Games\game.exe
+            Used GPU Memory               : 512 MiB
+`
+
+const noPIDs = `
+==============NVSMI LOG==============
+
+Attached GPUs                             : 1
+GPU 00000000:01:00.0
+    Processes                             : None
+`
+
+func TestParseComputeApps(t *testing.T) {
+	processes, err := ParseComputeApps([]byte("5812, C:\\Program Files\\obs-studio\\bin\\64bit\\obs64.exe, [N/A]\n7311, python.exe, 1024\n"))
+	require.NoError(t, err)
+	require.Equal(t, []Process{
+		{PID: 5812, Name: `C:\Program Files\obs-studio\bin\64bit\obs64.exe`, Type: "C"},
+		{PID: 7311, Name: "python.exe", Type: "C", UsedMemory: 1024},
+	}, processes)
+	require.Equal(t, "obs64.exe", processes[0].ExeName())
+
+	processes, err = ParseComputeApps(nil)
+	require.NoError(t, err)
+	require.Empty(t, processes)
+
+	_, err = ParseComputeApps([]byte("abc, python.exe, 10\n"))
+	require.Error(t, err)
+}
+
+func TestParseQueryPIDs(t *testing.T) {
+	processes, err := ParseQueryPIDs([]byte(queryPIDs))
+	require.NoError(t, err)
+	require.Equal(t, []Process{
+		{PID: 5812, Name: `C:\Program Files\obs-studio\bin\64bit\obs64.exe`, Type: "C+G"},
+		{PID: 1204, Name: `C:\Games\game.exe`, Type: "G", UsedMemory: 512},
+	}, processes)
+
+	processes, err = ParseQueryPIDs([]byte(noPIDs))
+	require.NoError(t, err)
+	require.Empty(t, processes)
+}
+
+type fakeRunner struct {
+	outputs map[string]string
+	err     error
+	runs    int
+}
+
+func (f *fakeRunner) Run(ctx context.Context, args ...string) ([]byte, error) {
+	f.runs++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []byte(f.outputs[strings.Join(args, " ")]), nil
+}
+
+func TestSMIProcesses(t *testing.T) {
+	runner := &fakeRunner{
+		outputs: map[string]string{
+			"--query-compute-apps=pid,process_name,used_memory --format=csv,noheader,nounits": "5812, obs64.exe, [N/A]\n7311, python.exe, 1024\n",
+			"-q -d PIDS": queryPIDs,
+		},
+	}
+	smi := NewSMI(runner, time.Second, time.Second*10)
+	now := time.Date(2021, 8, 16, 12, 0, 0, 0, time.UTC)
+	smi.now = func() time.Time { return now }
+
+	processes, err := smi.Processes(context.Background())
+	require.NoError(t, err)
+	require.Len(t, processes, 3)
+	require.Equal(t, 1204, processes[0].PID)
+	require.Equal(t, "C+G", processes[1].Type)
+	require.Equal(t, 7311, processes[2].PID)
+	require.Equal(t, 2, runner.runs)
+
+	// cached longer than the telemetry
+	now = now.Add(time.Second * 5)
+	_, err = smi.Processes(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, runner.runs)
+
+	smi.Invalidate()
+	runner.err = errors.New("NVIDIA-SMI has failed because it couldn't communicate with the NVIDIA driver")
+	_, err = smi.Processes(context.Background())
+	require.Error(t, err)
+
+	now = now.Add(time.Second * 10)
+	runner.err = nil
+	processes, err = smi.Processes(context.Background())
+	require.NoError(t, err)
+	require.Len(t, processes, 3)
+}
+
+func TestParseTelemetry(t *testing.T) {
+	telemetry, err := ParseTelemetry([]byte("61, 37, 12, 45.30, 80.00, 1395, 6000, 1024, 6144, P0, 0x0000000000000024\r\n"))
+	require.NoError(t, err)
+	require.Equal(t, Telemetry{
+		Temperature:       61,
+		Utilization:       37,
+		MemoryUtilization: 12,
+		PowerDraw:         45.3,
+		PowerLimit:        80,
+		GraphicsClock:     1395,
+		MemoryClock:       6000,
+		MemoryUsed:        1024,
+		MemoryTotal:       6144,
+		PState:            "P0",
+		ThrottleReasons:   []string{"swPowerCap", "swThermalSlowdown"},
+	}, telemetry)
+
+	telemetry, err = ParseTelemetry([]byte("45, 0, 0, [N/A], [Not Supported], 210, 405, 0, 6144, [N/A], [N/A]\n"))
+	require.NoError(t, err)
+	require.Equal(t, 0.0, telemetry.PowerDraw)
+	require.Equal(t, "", telemetry.PState)
+	require.Empty(t, telemetry.ThrottleReasons)
+
+	_, err = ParseTelemetry([]byte("45, 0\n"))
+	require.Error(t, err)
+	_, err = ParseTelemetry(nil)
+	require.Error(t, err)
+}
+
+func TestSMITelemetry(t *testing.T) {
+	runner := &fakeRunner{
+		outputs: map[string]string{
+			"--query-gpu=" + strings.Join(telemetryFields, ",") + " --format=csv,noheader,nounits": "61, 37, 12, 45.30, 80.00, 1395, 6000, 1024, 6144, P0, 0x0000000000000000\n",
+		},
+	}
+	smi := NewSMI(runner, time.Second, time.Second*10)
+	now := time.Date(2021, 8, 16, 12, 0, 0, 0, time.UTC)
+	smi.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		telemetry, err := smi.Telemetry(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 61.0, telemetry.Temperature)
+	}
+	require.Equal(t, 1, runner.runs)
+
+	now = now.Add(time.Second)
+	_, err := smi.Telemetry(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, runner.runs)
+}
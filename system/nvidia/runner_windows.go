@@ -0,0 +1,26 @@
+package nvidia
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+type execRunner struct {
+	path string
+}
+
+var _ Runner = &execRunner{}
+
+// NewRunner returns a Runner for the nvidia-smi installed with the driver
+func NewRunner() Runner {
+	return &execRunner{
+		path: "nvidia-smi",
+	}
+}
+
+func (e *execRunner) Run(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, e.path, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	return cmd.Output()
+}
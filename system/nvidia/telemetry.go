@@ -0,0 +1,136 @@
+package nvidia
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// telemetryFields are queried with --query-gpu, in the order of Telemetry
+var telemetryFields = []string{
+	"temperature.gpu",
+	"utilization.gpu",
+	"utilization.memory",
+	"power.draw",
+	"power.limit",
+	"clocks.gr",
+	"clocks.mem",
+	"memory.used",
+	"memory.total",
+	"pstate",
+	"clocks_throttle_reasons.active",
+}
+
+// throttleReasons are the bits of clocks_throttle_reasons.active
+var throttleReasons = []struct {
+	mask uint64
+	name string
+}{
+	{0x001, "idle"},
+	{0x002, "applicationsClocks"},
+	{0x004, "swPowerCap"},
+	{0x008, "hwSlowdown"},
+	{0x010, "syncBoost"},
+	{0x020, "swThermalSlowdown"},
+	{0x040, "hwThermalSlowdown"},
+	{0x080, "hwPowerBrakeSlowdown"},
+	{0x100, "displayClocks"},
+}
+
+// Telemetry is a snapshot of the GPU. Values the GPU does not report are 0.
+type Telemetry struct {
+	// Temperature in °C
+	Temperature float64 `json:"temperature"`
+	// Utilization and MemoryUtilization in %
+	Utilization       int `json:"utilization"`
+	MemoryUtilization int `json:"memoryUtilization"`
+	// PowerDraw and PowerLimit in W
+	PowerDraw  float64 `json:"powerDraw"`
+	PowerLimit float64 `json:"powerLimit"`
+	// GraphicsClock and MemoryClock in MHz
+	GraphicsClock int `json:"graphicsClock"`
+	MemoryClock   int `json:"memoryClock"`
+	// MemoryUsed and MemoryTotal in MiB
+	MemoryUsed  int    `json:"memoryUsed"`
+	MemoryTotal int    `json:"memoryTotal"`
+	PState      string `json:"pState"`
+	// ThrottleReasons lists why the clocks are lowered, e.g. "swPowerCap"
+	ThrottleReasons []string `json:"throttleReasons"`
+}
+
+// ParseTelemetry parses the output of
+// "nvidia-smi --query-gpu=<telemetryFields> --format=csv,noheader,nounits".
+// Only the first GPU is returned.
+func ParseTelemetry(out []byte) (Telemetry, error) {
+	r := csv.NewReader(bytes.NewReader(out))
+	r.TrimLeadingSpace = true
+	r.FieldsPerRecord = len(telemetryFields)
+
+	record, err := r.Read()
+	if err != nil {
+		return Telemetry{}, fmt.Errorf("nvidia: invalid telemetry: %w", err)
+	}
+
+	t := Telemetry{
+		Temperature:       parseFloat(record[0]),
+		Utilization:       int(parseFloat(record[1])),
+		MemoryUtilization: int(parseFloat(record[2])),
+		PowerDraw:         parseFloat(record[3]),
+		PowerLimit:        parseFloat(record[4]),
+		GraphicsClock:     int(parseFloat(record[5])),
+		MemoryClock:       int(parseFloat(record[6])),
+		MemoryUsed:        int(parseFloat(record[7])),
+		MemoryTotal:       int(parseFloat(record[8])),
+		PState:            record[9],
+		ThrottleReasons:   []string{},
+	}
+	if t.PState == "[N/A]" {
+		t.PState = ""
+	}
+
+	if mask, err := strconv.ParseUint(strings.TrimPrefix(record[10], "0x"), 16, 64); err == nil {
+		for _, reason := range throttleReasons {
+			if mask&reason.mask != 0 {
+				t.ThrottleReasons = append(t.ThrottleReasons, reason.name)
+			}
+		}
+	}
+	return t, nil
+}
+
+// parseFloat returns 0 for "[N/A]", "[Not Supported]" and such
+func parseFloat(s string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// Telemetry returns a snapshot of the GPU
+func (s *SMI) Telemetry(ctx context.Context) (Telemetry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.telemetryAt.IsZero() && s.now().Sub(s.telemetryAt) < s.ttl {
+		return s.telemetry, s.telemetryErr
+	}
+
+	s.telemetry, s.telemetryErr = s.queryTelemetry(ctx)
+	s.telemetryAt = s.now()
+	return s.telemetry, s.telemetryErr
+}
+
+func (s *SMI) queryTelemetry(ctx context.Context) (Telemetry, error) {
+	ctx, cancel := context.WithTimeout(ctx, runTimeout)
+	defer cancel()
+
+	out, err := s.runner.Run(ctx, "--query-gpu="+strings.Join(telemetryFields, ","), "--format=csv,noheader,nounits")
+	if err != nil {
+		return Telemetry{}, err
+	}
+	return ParseTelemetry(out)
+}
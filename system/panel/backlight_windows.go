@@ -0,0 +1,65 @@
+package panel
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/bi-zone/wmi"
+)
+
+const (
+	wmiNamespace = `root\wmi`
+	setTimeout   = time.Second * 10
+)
+
+type wmiMonitorBrightness struct {
+	CurrentBrightness uint8
+	Active            bool
+}
+
+type wmiBacklight struct{}
+
+var _ Backlight = wmiBacklight{}
+
+// NewBacklight returns a Backlight using the WmiMonitorBrightness classes of
+// the internal panel
+func NewBacklight() Backlight {
+	return wmiBacklight{}
+}
+
+func (wmiBacklight) Brightness() (int, error) {
+	var monitors []wmiMonitorBrightness
+	if err := wmi.QueryNamespace("SELECT CurrentBrightness, Active FROM WmiMonitorBrightness", &monitors, wmiNamespace); err != nil {
+		return 0, err
+	}
+	for _, m := range monitors {
+		if m.Active {
+			return int(m.CurrentBrightness), nil
+		}
+	}
+	return 0, ErrNoBacklight
+}
+
+// SetBrightness calls WmiSetBrightness through PowerShell, since the WMI
+// package cannot call methods
+func (wmiBacklight) SetBrightness(level int) error {
+	if err := validateBrightness(level); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), setTimeout)
+	defer cancel()
+
+	script := fmt.Sprintf("Get-CimInstance -Namespace root/WMI -ClassName WmiMonitorBrightnessMethods | "+
+		"Where-Object Active | Invoke-CimMethod -MethodName WmiSetBrightness -Arguments @{Timeout=[uint32]0; Brightness=[byte]%d}", level)
+	cmd := exec.CommandContext(ctx, "powershell.exe", "-NoProfile", "-NonInteractive", "-Command", script)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("panel: unable to set brightness: %w: %s", err, out)
+	}
+	return nil
+}
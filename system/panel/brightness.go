@@ -0,0 +1,97 @@
+package panel
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoBacklight is returned if the brightness of the panel cannot be controlled
+var ErrNoBacklight = errors.New("panel: brightness control is not available")
+
+// Backlight reads and sets the brightness of the internal panel, in %
+type Backlight interface {
+	Brightness() (int, error)
+	SetBrightness(level int) error
+}
+
+// brightnessStep is how much the brightness changes per step, in %
+const brightnessStep = 10
+
+// stepBrightness returns the level moved by steps (negative to dim), kept
+// within 0-100%
+func stepBrightness(level, steps int) int {
+	level += steps * brightnessStep
+	if level < 0 {
+		return 0
+	}
+	if level > 100 {
+		return 100
+	}
+	return level
+}
+
+func validateBrightness(level int) error {
+	if level < 0 || level > 100 {
+		return fmt.Errorf("panel: invalid brightness %d%%", level)
+	}
+	return nil
+}
+
+// BrightnessPresets are the brightness to set when the charger is plugged in
+// or unplugged. A nil level (null in JSON) leaves the brightness alone, so
+// 0% can be set as well.
+type BrightnessPresets struct {
+	Enabled   bool `json:"enabled"`
+	PluggedIn *int `json:"pluggedIn"`
+	Unplugged *int `json:"unplugged"`
+}
+
+// Validate returns an error if a level is out of range
+func (b BrightnessPresets) Validate() error {
+	for _, level := range []*int{b.PluggedIn, b.Unplugged} {
+		if level == nil {
+			continue
+		}
+		if err := validateBrightness(*level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Target returns the brightness for the power source. ok is false if it
+// should be left alone.
+func (b BrightnessPresets) Target(pluggedIn bool) (level int, ok bool) {
+	if !b.Enabled {
+		return 0, false
+	}
+	target := b.Unplugged
+	if pluggedIn {
+		target = b.PluggedIn
+	}
+	if target == nil {
+		return 0, false
+	}
+	return *target, true
+}
+
+// FakeBacklight is a Backlight for tests and dry runs
+type FakeBacklight struct {
+	Level int
+	// Err is returned by all calls if set
+	Err error
+}
+
+var _ Backlight = &FakeBacklight{}
+
+func (f *FakeBacklight) Brightness() (int, error) {
+	return f.Level, f.Err
+}
+
+func (f *FakeBacklight) SetBrightness(level int) error {
+	if f.Err != nil {
+		return f.Err
+	}
+	f.Level = level
+	return nil
+}
@@ -0,0 +1,595 @@
+// Package panel controls the settings of the internal display panel.
+package panel
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/NeilSeligmann/G15Manager/system/atkacpi"
+	"github.com/NeilSeligmann/G15Manager/system/model"
+	"github.com/NeilSeligmann/G15Manager/system/persist"
+	"github.com/NeilSeligmann/G15Manager/system/plugin"
+	"github.com/NeilSeligmann/G15Manager/util"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const persistKey = "PanelSettings"
+
+// the firmware changes the brightness after the LCD key is handled, so the
+// level is read a little later
+const osdDelay = time.Millisecond * 300
+
+// how often the overdrive and brightness served to the clients are read again,
+// as other tools can change them
+const refreshInterval = time.Second * 10
+
+// dstsPresent is set in the DSTS result if the device is supported
+const dstsPresent = 0x10000
+
+// ErrUnsupported is returned if the panel has no overdrive
+var ErrUnsupported = errors.New("panel: overdrive is not supported on this model")
+
+// Control sets the panel overdrive, optionally per thermal profile, and
+// applies it again after resume since the firmware resets it. Until the
+// overdrive is configured, the one set in firmware is left alone. It also sets
+// the brightness, optionally per power source. The controller is safe for
+// multiple goroutines.
+type Control struct {
+	wmi       atkacpi.WMI
+	device    uint32
+	backlight Backlight
+
+	mu         sync.Mutex
+	overdrive  bool
+	configured bool
+	// profiles overrides the overdrive for the thermal profiles
+	profiles       map[string]bool
+	thermalProfile string
+	presets        BrightnessPresets
+
+	// the last values read from the hardware, so clients are served without
+	// evaluating ACPI or WMI methods. level is -1 if there is no backlight.
+	firmwareOverdrive bool
+	overdriveOK       bool
+	level             int
+
+	queue   chan plugin.Notification
+	errChan chan error
+}
+
+var _ plugin.Plugin = &Control{}
+var _ persist.Registry = &Control{}
+
+// NewControl returns a Control for the panel of the model
+func NewControl(wmi atkacpi.WMI, m *model.Model, backlight Backlight) (*Control, error) {
+	if wmi == nil {
+		return nil, errors.New("nil WMI is invalid")
+	}
+	if m == nil {
+		return nil, errors.New("nil model is invalid")
+	}
+	if backlight == nil {
+		return nil, errors.New("nil Backlight is invalid")
+	}
+	return &Control{
+		wmi:       wmi,
+		device:    m.Devices.PanelOverdrive,
+		backlight: backlight,
+		profiles:  map[string]bool{},
+		level:     -1,
+		queue:     make(chan plugin.Notification),
+		errChan:   make(chan error),
+	}, nil
+}
+
+// read returns the overdrive set in firmware. ok is false if the panel has no
+// overdrive. Caller must hold c.mu.
+func (c *Control) read() (enabled bool, ok bool, err error) {
+	enabled, ok, err = c.readDevice()
+	if err == nil {
+		c.firmwareOverdrive, c.overdriveOK = enabled, ok
+	}
+	return enabled, ok, err
+}
+
+func (c *Control) readDevice() (enabled bool, ok bool, err error) {
+	if c.device == 0 {
+		return false, false, nil
+	}
+
+	args := make([]byte, 4)
+	binary.LittleEndian.PutUint32(args[0:], c.device)
+
+	status, err := c.wmi.Evaluate(atkacpi.DSTS, args)
+	if err != nil {
+		return false, false, err
+	}
+	if len(status) < 4 {
+		return false, false, nil
+	}
+
+	v := binary.LittleEndian.Uint32(status[0:4])
+	if v&dstsPresent == 0 {
+		return false, false, nil
+	}
+	return v&1 == 1, true, nil
+}
+
+func (c *Control) write(enabled bool) error {
+	value := uint32(0)
+	if enabled {
+		value = 1
+	}
+
+	args := make([]byte, 8)
+	binary.LittleEndian.PutUint32(args[0:], c.device)
+	binary.LittleEndian.PutUint32(args[4:], value)
+
+	if _, err := c.wmi.Evaluate(atkacpi.DEVS, args); err != nil {
+		return err
+	}
+
+	actual, _, err := c.read()
+	if err != nil {
+		return err
+	}
+	if actual != enabled {
+		return fmt.Errorf("panel: overdrive reads back %t instead of %t", actual, enabled)
+	}
+	return nil
+}
+
+// effective returns the overdrive for the current thermal profile. Caller
+// must hold c.mu.
+func (c *Control) effective() bool {
+	if enabled, ok := c.profiles[c.thermalProfile]; ok {
+		return enabled
+	}
+	return c.overdrive
+}
+
+// apply writes the overdrive for the current thermal profile if it differs
+// from the firmware. Nothing is written until the overdrive is configured.
+// Caller must hold c.mu.
+func (c *Control) apply() error {
+	if !c.configured && len(c.profiles) == 0 {
+		return nil
+	}
+
+	current, ok, err := c.read()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+
+	enabled := c.effective()
+	if current == enabled {
+		return nil
+	}
+	log.Printf("panel: setting overdrive to %t\n", enabled)
+	return c.write(enabled)
+}
+
+// Overdrive returns the overdrive set in firmware
+func (c *Control) Overdrive() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	enabled, ok, err := c.read()
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, ErrUnsupported
+	}
+	return enabled, nil
+}
+
+// SetOverdrive enables or disables the overdrive. It is the default for the
+// thermal profiles without their own setting.
+func (c *Control) SetOverdrive(enabled bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok, err := c.read(); err != nil {
+		return err
+	} else if !ok {
+		return ErrUnsupported
+	}
+
+	if err := c.write(enabled); err != nil {
+		return err
+	}
+	c.overdrive = enabled
+	c.configured = true
+	return nil
+}
+
+// ProfileOverdrive returns a copy of the overdrive per thermal profile
+func (c *Control) ProfileOverdrive() map[string]bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	profiles := make(map[string]bool, len(c.profiles))
+	for name, enabled := range c.profiles {
+		profiles[name] = enabled
+	}
+	return profiles
+}
+
+// SetProfileOverdrive replaces the overdrive per thermal profile, and applies
+// it for the current profile
+func (c *Control) SetProfileOverdrive(profiles map[string]bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.profiles = make(map[string]bool, len(profiles))
+	for name, enabled := range profiles {
+		c.profiles[name] = enabled
+	}
+	return c.apply()
+}
+
+func (c *Control) setThermalProfile(profile string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.thermalProfile = profile
+	return c.apply()
+}
+
+func (c *Control) reapply() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.apply()
+}
+
+// Brightness returns the brightness of the panel in %
+func (c *Control) Brightness() (int, error) {
+	level, err := c.backlight.Brightness()
+	if err != nil {
+		level = -1
+	}
+	c.setLevel(level)
+	return level, err
+}
+
+// SetBrightness sets the brightness of the panel in %
+func (c *Control) SetBrightness(level int) error {
+	if err := validateBrightness(level); err != nil {
+		return err
+	}
+	if err := c.backlight.SetBrightness(level); err != nil {
+		return err
+	}
+	c.setLevel(level)
+	return nil
+}
+
+// StepBrightness changes the brightness of the panel by steps of 10%, and
+// returns the new level. Negative steps dim the panel.
+func (c *Control) StepBrightness(steps int) (int, error) {
+	level, err := c.backlight.Brightness()
+	if err != nil {
+		return 0, err
+	}
+	level = stepBrightness(level, steps)
+	if err := c.SetBrightness(level); err != nil {
+		return 0, err
+	}
+	return level, nil
+}
+
+func (c *Control) setLevel(level int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.level = level
+}
+
+// refresh reads the overdrive and brightness served to the clients again
+func (c *Control) refresh() {
+	if _, err := c.Overdrive(); err != nil && !errors.Is(err, ErrUnsupported) {
+		log.Printf("panel: unable to read overdrive: %s\n", err)
+	}
+	// the backlight may be unavailable, e.g. with the lid closed
+	c.Brightness()
+}
+
+// BrightnessPresets returns the brightness for the power sources
+func (c *Control) BrightnessPresets() BrightnessPresets {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.presets
+}
+
+// SetBrightnessPresets replaces the brightness for the power sources. They
+// take effect the next time the charger is plugged in or unplugged.
+func (c *Control) SetBrightnessPresets(p BrightnessPresets) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.presets = p
+	return nil
+}
+
+// applyPreset sets the brightness for the power source. changed is false if
+// there is nothing to do.
+func (c *Control) applyPreset(pluggedIn bool) (level int, changed bool, err error) {
+	level, ok := c.BrightnessPresets().Target(pluggedIn)
+	if !ok {
+		return 0, false, nil
+	}
+	if current, err := c.backlight.Brightness(); err == nil && current == level {
+		return level, false, nil
+	}
+	if err := c.backlight.SetBrightness(level); err != nil {
+		return level, false, err
+	}
+	c.setLevel(level)
+	return level, true, nil
+}
+
+func (c *Control) notifyBrightness(cb chan<- plugin.Callback, level int) {
+	cb <- plugin.Callback{
+		Event: plugin.CbNotifyToast,
+		Value: util.Notification{
+			Message:   fmt.Sprintf("Display Brightness: %d%%", level),
+			Delay:     time.Millisecond * 500,
+			Immediate: true,
+		},
+	}
+}
+
+// Initialize satisfies system/plugin.Plugin. Unless it was configured, the
+// default overdrive is the one set in firmware.
+func (c *Control) Initialize() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.configured {
+		return nil
+	}
+	enabled, ok, err := c.read()
+	if err != nil {
+		return err
+	}
+	if ok {
+		c.overdrive = enabled
+	}
+	return nil
+}
+
+func (c *Control) loop(haltCtx context.Context, cb chan<- plugin.Callback) {
+	// fires to show the brightness after the LCD keys
+	var osd <-chan time.Time
+
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	c.refresh()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.refresh()
+		case <-osd:
+			osd = nil
+			level, err := c.Brightness()
+			if err != nil {
+				log.Printf("panel: unable to read brightness: %s\n", err)
+				continue
+			}
+			c.notifyBrightness(cb, level)
+		case t := <-c.queue:
+			switch t.Event {
+			case plugin.EvtSentinelLCDBrightness:
+				osd = time.After(osdDelay)
+			case plugin.EvtStepLCDBrightness:
+				steps, ok := t.Value.(int)
+				if !ok {
+					continue
+				}
+				level, err := c.StepBrightness(steps)
+				if err != nil {
+					// the backlight may be unavailable, e.g. with the lid closed
+					log.Printf("panel: unable to set brightness: %s\n", err)
+					continue
+				}
+				c.notifyBrightness(cb, level)
+			case plugin.EvtChargerPluggedIn, plugin.EvtChargerUnplugged:
+				level, changed, err := c.applyPreset(t.Event == plugin.EvtChargerPluggedIn)
+				if err != nil {
+					// the backlight may be unavailable, e.g. with the lid closed
+					log.Printf("panel: unable to set brightness: %s\n", err)
+					continue
+				}
+				if changed {
+					log.Printf("panel: brightness set to %d%%\n", level)
+					c.notifyBrightness(cb, level)
+				}
+			case plugin.EvtACPIResume:
+				// the firmware resets the overdrive while suspended
+				if err := c.reapply(); err != nil {
+					c.errChan <- err
+				}
+			case plugin.EvtThermalProfileChanged:
+				profile, ok := t.Value.(string)
+				if !ok {
+					continue
+				}
+				if err := c.setThermalProfile(profile); err != nil {
+					c.errChan <- err
+				}
+			}
+		case <-haltCtx.Done():
+			log.Println("panel: exiting Plugin run loop")
+			return
+		}
+	}
+}
+
+// Run satisfies system/plugin.Plugin
+func (c *Control) Run(haltCtx context.Context, cb chan<- plugin.Callback) <-chan error {
+	log.Println("panel: Starting queue loop")
+
+	go c.loop(haltCtx, cb)
+
+	return c.errChan
+}
+
+// Notify satisfies system/plugin.Plugin
+func (c *Control) Notify(t plugin.Notification) {
+	switch t.Event {
+	case plugin.EvtACPIResume, plugin.EvtThermalProfileChanged, plugin.EvtSentinelLCDBrightness,
+		plugin.EvtStepLCDBrightness, plugin.EvtChargerPluggedIn, plugin.EvtChargerUnplugged:
+	default:
+		return
+	}
+
+	c.queue <- t
+}
+
+// GetWSInfo serves the values last read by the plugin loop
+func (c *Control) GetWSInfo() gin.H {
+	profiles := c.ProfileOverdrive()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	overdrive := gin.H{
+		"supported": c.overdriveOK,
+		"enabled":   c.overdriveOK && c.firmwareOverdrive,
+		"default":   c.overdrive,
+		"profiles":  profiles,
+	}
+	brightness := gin.H{
+		"supported": c.level >= 0,
+		"level":     0,
+		"presets":   c.presets,
+	}
+	if c.level >= 0 {
+		brightness["level"] = c.level
+	}
+
+	return gin.H{
+		"overdrive":  overdrive,
+		"brightness": brightness,
+	}
+}
+
+func (c *Control) HandleWSMessage(ws *websocket.Conn, action int, value string) {
+	var err error
+	switch action {
+	// Set Overdrive (true/false)
+	case 0:
+		var enabled bool
+		if enabled, err = strconv.ParseBool(value); err == nil {
+			err = c.SetOverdrive(enabled)
+		}
+	// Set Overdrive per Thermal Profile
+	case 1:
+		var profiles map[string]bool
+		if err = json.Unmarshal([]byte(value), &profiles); err == nil {
+			err = c.SetProfileOverdrive(profiles)
+		}
+	// Set Brightness (0-100)
+	case 2:
+		var level int
+		if level, err = strconv.Atoi(value); err == nil {
+			err = c.SetBrightness(level)
+		}
+	// Set Brightness Presets for the power sources
+	case 3:
+		var p BrightnessPresets
+		if err = json.Unmarshal([]byte(value), &p); err == nil {
+			err = c.SetBrightnessPresets(p)
+		}
+	}
+	if err != nil {
+		log.Printf("panel: websocket action %d failed: %s\n", action, err)
+	}
+}
+
+type persistValue struct {
+	Overdrive  *bool             `json:"overdrive,omitempty"`
+	Profiles   map[string]bool   `json:"profiles"`
+	Brightness BrightnessPresets `json:"brightness"`
+}
+
+// Name satisfies persist.Registry
+func (c *Control) Name() string {
+	return persistKey
+}
+
+// Value satisfies persist.Registry
+func (c *Control) Value() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := persistValue{
+		Profiles:   c.profiles,
+		Brightness: c.presets,
+	}
+	if c.configured {
+		overdrive := c.overdrive
+		p.Overdrive = &overdrive
+	}
+	b, _ := json.Marshal(p)
+	return b
+}
+
+// Load satisfies persist.Registry
+func (c *Control) Load(v []byte) error {
+	if len(v) == 0 {
+		return nil
+	}
+
+	var p persistValue
+	if err := json.Unmarshal(v, &p); err != nil {
+		return err
+	}
+	if err := p.Brightness.Validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.presets = p.Brightness
+
+	if p.Overdrive != nil {
+		c.overdrive = *p.Overdrive
+		c.configured = true
+	}
+	c.profiles = p.Profiles
+	if c.profiles == nil {
+		c.profiles = map[string]bool{}
+	}
+	return nil
+}
+
+// Apply satisfies persist.Registry
+func (c *Control) Apply() error {
+	return c.reapply()
+}
+
+// Close satisfied persist.Registry
+func (c *Control) Close() error {
+	return nil
+}
@@ -0,0 +1,246 @@
+package panel
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NeilSeligmann/G15Manager/system/atkacpi"
+	"github.com/NeilSeligmann/G15Manager/system/model"
+	"github.com/NeilSeligmann/G15Manager/system/plugin"
+	"github.com/NeilSeligmann/G15Manager/util"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+var testModel = &model.Model{
+	Devices: model.Devices{
+		PanelOverdrive: atkacpi.DevsPanelOverdrive,
+	},
+}
+
+// fakeWMI reports the devices in values
+type fakeWMI struct {
+	values map[uint32]uint32
+	writes int
+}
+
+func (f *fakeWMI) Evaluate(id atkacpi.Method, args []byte) ([]byte, error) {
+	device := binary.LittleEndian.Uint32(args[0:])
+	switch id {
+	case atkacpi.DEVS:
+		f.writes++
+		f.values[device] = binary.LittleEndian.Uint32(args[4:])
+		return make([]byte, 4), nil
+	case atkacpi.DSTS:
+		status := make([]byte, 4)
+		if v, ok := f.values[device]; ok {
+			binary.LittleEndian.PutUint32(status, dstsPresent|v)
+		}
+		return status, nil
+	}
+	return nil, errors.New("unexpected method")
+}
+
+func (f *fakeWMI) Close() error {
+	return nil
+}
+
+func TestOverdrive(t *testing.T) {
+	wmi := &fakeWMI{values: map[uint32]uint32{}}
+	c, err := NewControl(wmi, testModel, &FakeBacklight{})
+	require.NoError(t, err)
+
+	_, err = c.Overdrive()
+	require.True(t, errors.Is(err, ErrUnsupported))
+	require.True(t, errors.Is(c.SetOverdrive(false), ErrUnsupported))
+	require.NoError(t, c.Apply())
+	require.Equal(t, 0, wmi.writes)
+
+	// left alone until configured
+	wmi.values[atkacpi.DevsPanelOverdrive] = 0
+	require.NoError(t, c.Initialize())
+	require.NoError(t, c.Apply())
+	require.Equal(t, 0, wmi.writes)
+
+	wmi.values[atkacpi.DevsPanelOverdrive] = 1
+	require.NoError(t, c.SetOverdrive(false))
+	enabled, err := c.Overdrive()
+	require.NoError(t, err)
+	require.False(t, enabled)
+
+	// the firmware resets it on resume
+	wmi.values[atkacpi.DevsPanelOverdrive] = 1
+	require.NoError(t, c.reapply())
+	require.Equal(t, uint32(0), wmi.values[atkacpi.DevsPanelOverdrive])
+
+	// clients are served the last value read
+	wmi.values[atkacpi.DevsPanelOverdrive] = 1
+	overdrive := c.GetWSInfo()["overdrive"].(gin.H)
+	require.Equal(t, true, overdrive["supported"])
+	require.Equal(t, false, overdrive["enabled"])
+	c.refresh()
+	require.Equal(t, true, c.GetWSInfo()["overdrive"].(gin.H)["enabled"])
+}
+
+func TestOverdriveProfiles(t *testing.T) {
+	wmi := &fakeWMI{values: map[uint32]uint32{atkacpi.DevsPanelOverdrive: 1}}
+	c, err := NewControl(wmi, testModel, &FakeBacklight{})
+	require.NoError(t, err)
+	require.NoError(t, c.Initialize())
+
+	require.NoError(t, c.SetProfileOverdrive(map[string]bool{"Silent": false}))
+	require.Equal(t, 0, wmi.writes)
+
+	require.NoError(t, c.setThermalProfile("Silent"))
+	require.Equal(t, uint32(0), wmi.values[atkacpi.DevsPanelOverdrive])
+
+	require.NoError(t, c.setThermalProfile("Performance"))
+	require.Equal(t, uint32(1), wmi.values[atkacpi.DevsPanelOverdrive])
+	require.Equal(t, 2, wmi.writes)
+}
+
+func TestPanelPersist(t *testing.T) {
+	wmi := &fakeWMI{values: map[uint32]uint32{atkacpi.DevsPanelOverdrive: 1}}
+	c, err := NewControl(wmi, testModel, &FakeBacklight{})
+	require.NoError(t, err)
+	require.NotEmpty(t, c.Name())
+
+	require.NoError(t, c.SetOverdrive(false))
+	require.NoError(t, c.SetProfileOverdrive(map[string]bool{"Performance": true}))
+
+	loaded, err := NewControl(wmi, testModel, &FakeBacklight{})
+	require.NoError(t, err)
+	require.NoError(t, loaded.Load(c.Value()))
+	require.Equal(t, map[string]bool{"Performance": true}, loaded.ProfileOverdrive())
+
+	wmi.values[atkacpi.DevsPanelOverdrive] = 1
+	require.NoError(t, loaded.Initialize())
+	require.NoError(t, loaded.Apply())
+	require.Equal(t, uint32(0), wmi.values[atkacpi.DevsPanelOverdrive])
+
+	// nothing persisted, the firmware is left alone
+	unset, err := NewControl(wmi, testModel, &FakeBacklight{})
+	require.NoError(t, err)
+	require.NoError(t, unset.Load([]byte(`{"brightness":{}}`)))
+	wmi.values[atkacpi.DevsPanelOverdrive] = 1
+	writes := wmi.writes
+	require.NoError(t, unset.Initialize())
+	require.NoError(t, unset.Apply())
+	require.Equal(t, writes, wmi.writes)
+	require.NotContains(t, string(unset.Value()), "overdrive")
+}
+
+func TestBrightnessPresets(t *testing.T) {
+	wmi := &fakeWMI{values: map[uint32]uint32{}}
+	backlight := &FakeBacklight{Level: 80}
+	c, err := NewControl(wmi, testModel, backlight)
+	require.NoError(t, err)
+
+	require.Error(t, c.SetBrightness(101))
+	require.NoError(t, c.SetBrightness(70))
+	level, err := c.Brightness()
+	require.NoError(t, err)
+	require.Equal(t, 70, level)
+
+	backlight.Level = 60
+	require.Equal(t, 70, c.GetWSInfo()["brightness"].(gin.H)["level"])
+	c.refresh()
+	require.Equal(t, 60, c.GetWSInfo()["brightness"].(gin.H)["level"])
+
+	percent := func(level int) *int {
+		return &level
+	}
+	require.Error(t, c.SetBrightnessPresets(BrightnessPresets{Enabled: true, PluggedIn: percent(100), Unplugged: percent(-1)}))
+
+	_, changed, err := c.applyPreset(false)
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	require.NoError(t, c.SetBrightnessPresets(BrightnessPresets{Enabled: true, PluggedIn: percent(100), Unplugged: percent(40)}))
+	level, changed, err = c.applyPreset(false)
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, 40, level)
+	require.Equal(t, 40, backlight.Level)
+
+	// already at the preset
+	_, changed, err = c.applyPreset(false)
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	// 0% is a level, only nil leaves the brightness alone
+	require.NoError(t, c.SetBrightnessPresets(BrightnessPresets{Enabled: true, Unplugged: percent(0)}))
+	level, changed, err = c.applyPreset(false)
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, 0, level)
+	_, changed, err = c.applyPreset(true)
+	require.NoError(t, err)
+	require.False(t, changed)
+	require.Equal(t, 0, backlight.Level)
+
+	backlight.Err = ErrNoBacklight
+	require.NoError(t, c.SetBrightnessPresets(BrightnessPresets{Enabled: true, PluggedIn: percent(100)}))
+	_, _, err = c.applyPreset(true)
+	require.True(t, errors.Is(err, ErrNoBacklight))
+
+	loaded, err := NewControl(wmi, testModel, &FakeBacklight{})
+	require.NoError(t, err)
+	require.NoError(t, loaded.Load(c.Value()))
+	require.Equal(t, c.BrightnessPresets(), loaded.BrightnessPresets())
+	require.Error(t, loaded.Load([]byte(`{"brightness":{"pluggedIn":120}}`)))
+}
+
+func TestStepBrightness(t *testing.T) {
+	wmi := &fakeWMI{values: map[uint32]uint32{}}
+	backlight := &FakeBacklight{Level: 45}
+	c, err := NewControl(wmi, testModel, backlight)
+	require.NoError(t, err)
+
+	level, err := c.StepBrightness(1)
+	require.NoError(t, err)
+	require.Equal(t, 55, level)
+	require.Equal(t, 55, backlight.Level)
+	require.Equal(t, 55, c.GetWSInfo()["brightness"].(gin.H)["level"])
+
+	level, err = c.StepBrightness(-2)
+	require.NoError(t, err)
+	require.Equal(t, 35, level)
+
+	// kept within 0-100%
+	level, err = c.StepBrightness(-5)
+	require.NoError(t, err)
+	require.Equal(t, 0, level)
+	backlight.Level = 95
+	level, err = c.StepBrightness(1)
+	require.NoError(t, err)
+	require.Equal(t, 100, level)
+
+	backlight.Err = ErrNoBacklight
+	_, err = c.StepBrightness(1)
+	require.True(t, errors.Is(err, ErrNoBacklight))
+}
+
+func TestStepBrightnessEvent(t *testing.T) {
+	wmi := &fakeWMI{values: map[uint32]uint32{}}
+	backlight := &FakeBacklight{Level: 50}
+	c, err := NewControl(wmi, testModel, backlight)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cb := make(chan plugin.Callback, 1)
+	c.Run(ctx, cb)
+
+	c.Notify(plugin.Notification{Event: plugin.EvtStepLCDBrightness, Value: -1})
+	select {
+	case ev := <-cb:
+		require.Equal(t, plugin.CbNotifyToast, ev.Event)
+		require.Equal(t, "Display Brightness: 40%", ev.Value.(util.Notification).Message)
+	case <-time.After(time.Second):
+		t.Fatal("no brightness notification")
+	}
+}
@@ -69,7 +69,10 @@ func (h *RegistryConfigHelper) Load() error {
 			log.Printf("persist: error loading \"%s\" from the Registry: %s\n", config.Name(), err)
 			return err
 		}
-		config.Load(v)
+		if err := config.Load(v); err != nil {
+			// keep the default, the config will be persisted again on save
+			log.Printf("persist: invalid \"%s\" in the Registry: %s\n", config.Name(), err)
+		}
 	}
 
 	return nil
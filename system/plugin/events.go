@@ -15,10 +15,20 @@ const (
 	EvtSentinelEnableGPU
 	EvtSentinelDisableGPU
 	EvtSentinelCycleRefreshRate
+	EvtThermalProfileChanged
+	EvtBatteryLow
+	EvtBatteryRestored
+	EvtSentinelLCDBrightness
+	EvtDisplayTopologyChanged
+	EvtStepLCDBrightness
 
 	CbPersistConfig
 	CbNotifyToast
 	CbNotifyClients
+	CbThermalProfileChanged
+	CbBatteryLow
+	CbBatteryRestored
+	CbStepLCDBrightness
 )
 
 func (e Event) String() string {
@@ -33,9 +43,19 @@ func (e Event) String() string {
 		"Event (sentinel): Enable GPU",
 		"Event (sentinel): Disable GPU",
 		"Event (sentinel): Cycle Refresh Rate",
+		"Event: Thermal profile changed",
+		"Event: Battery low",
+		"Event: Battery low actions undone",
+		"Event (sentinel): LCD brightness key",
+		"Event: Display topology changed",
+		"Event: Step LCD brightness",
 
 		"Callback: Request to persist config",
 		"Callback: Request to notify user",
 		"Callback: Request to notify clients",
+		"Callback: Thermal profile changed",
+		"Callback: Battery low",
+		"Callback: Battery low actions undone",
+		"Callback: Step LCD brightness",
 	}[e]
 }
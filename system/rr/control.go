@@ -0,0 +1,468 @@
+// Package rr switches the refresh rate of the internal panel as a plugin. The
+// panel itself is driven by cxx/rr.
+package rr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/NeilSeligmann/G15Manager/system/battery/lowbattery"
+	"github.com/NeilSeligmann/G15Manager/system/display"
+	"github.com/NeilSeligmann/G15Manager/system/persist"
+	"github.com/NeilSeligmann/G15Manager/system/plugin"
+	"github.com/NeilSeligmann/G15Manager/util"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Control changes the refresh rate of the internal panel on request, with the
+// power source and on low battery. The controller is safe for multiple
+// goroutines.
+type Control struct {
+	dryRun     bool
+	newDisplay func() (display.RefreshRater, error)
+	// pendingRetry is how often a deferred refresh rate change is tried again
+	pendingRetry time.Duration
+
+	// mu guards the display, which is used by the loop and the websocket
+	mu       sync.Mutex
+	pDisplay display.RefreshRater
+	// restoreRate is the refresh rate before switching to the lowest on low battery
+	restoreRate int
+	// power switches the refresh rate with the power source. pendingRate is
+	// the rate to switch to once the internal display is primary.
+	power       display.PowerRates
+	pendingRate int
+
+	queue   chan plugin.Notification
+	errChan chan error
+}
+
+const (
+	persistKey = "RefreshRatePower"
+
+	notifyDelay time.Duration = time.Second * 3
+	// how often a deferred refresh rate change is tried again
+	defaultPendingRetry = time.Second * 30
+)
+
+var errNoDisplay = errors.New("rr: internal display is not primary")
+
+var _ plugin.Plugin = &Control{}
+
+// NewRRControl returns a Control for the internal panel. newDisplay opens the
+// panel, and fails if it is not the primary display. It is called again when
+// the displays change.
+func NewRRControl(dryRun bool, newDisplay func() (display.RefreshRater, error)) (*Control, error) {
+	if newDisplay == nil {
+		return nil, errors.New("nil newDisplay is invalid")
+	}
+	return &Control{
+		dryRun:       dryRun,
+		newDisplay:   newDisplay,
+		pendingRetry: defaultPendingRetry,
+		pDisplay:     nil,
+		queue:        make(chan plugin.Notification),
+		errChan:      make(chan error),
+	}, nil
+}
+
+// Initialize satisfies system/plugin.Plugin
+func (c *Control) Initialize() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.initDisplay()
+	return nil
+}
+
+// initDisplay looks for the internal display. Caller must hold c.mu.
+func (c *Control) initDisplay() {
+	d, err := c.newDisplay()
+	if err != nil {
+		log.Printf("rr: internal display not active/primary")
+		c.pDisplay = nil
+		return
+	}
+	c.pDisplay = d
+}
+
+// reinitDisplay looks for the internal display again after the displays
+// changed. It returns false if the internal display is not primary. Caller
+// must hold c.mu.
+func (c *Control) reinitDisplay() bool {
+	if c.pDisplay != nil {
+		c.pDisplay.Release()
+		c.pDisplay = nil
+	}
+	c.initDisplay()
+	return c.pDisplay != nil
+}
+
+// RefreshRates returns the refresh rates supported by the internal display,
+// or nil if it is not primary
+func (c *Control) RefreshRates() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pDisplay == nil {
+		return nil
+	}
+	return c.pDisplay.ListRefreshRates()
+}
+
+// SetRefreshRate changes the refresh rate of the internal display.
+// display.ErrUnsupportedRate is returned if the display does not support it.
+func (c *Control) SetRefreshRate(rate int) error {
+	if c.dryRun {
+		log.Printf("rr: dry run, not changing refresh rate to %d Hz\n", rate)
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pDisplay == nil {
+		// try again, in case the laptop now has internal display as primary
+		c.initDisplay()
+		if c.pDisplay == nil {
+			return errNoDisplay
+		}
+	}
+	if err := display.SetRefreshRate(c.pDisplay, rate); err != nil {
+		return err
+	}
+	c.restoreRate = 0
+	c.pendingRate = 0
+	return nil
+}
+
+// PowerRates returns the refresh rates for the power sources
+func (c *Control) PowerRates() display.PowerRates {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.power
+}
+
+// SetPowerRates replaces the refresh rates for the power sources. They take
+// effect the next time the charger is plugged in or unplugged.
+func (c *Control) SetPowerRates(p display.PowerRates) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.power = p
+	return nil
+}
+
+func (c *Control) loop(haltCtx context.Context, cb chan<- plugin.Callback) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("rr: loop panic %+v\n", err)
+			c.errChan <- err.(error)
+		}
+	}()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	c.mu.Lock()
+	current := 0
+	if c.pDisplay != nil {
+		current = c.pDisplay.GetCurrent()
+	}
+	c.mu.Unlock()
+
+	if current != 0 {
+		cb <- plugin.Callback{
+			Event: plugin.CbNotifyToast,
+			Value: util.Notification{
+				Message: fmt.Sprintf("Current Refresh Rate: %d Hz", current),
+				Delay:   notifyDelay,
+			},
+		}
+	}
+
+	// fires to retry a refresh rate change deferred until the internal
+	// display is primary
+	var retry <-chan time.Time
+
+	for {
+		var ret int
+		var changed bool
+		var err error
+
+		select {
+		case <-retry:
+			retry = nil
+			c.mu.Lock()
+			ret, changed, err = c.applyPending()
+			c.mu.Unlock()
+			if errors.Is(err, errNoDisplay) {
+				retry = time.After(c.pendingRetry)
+				continue
+			}
+		case t := <-c.queue:
+			if c.dryRun {
+				log.Println("rr: dry run, not changing refresh rate")
+				continue
+			}
+			if t.Event == plugin.EvtDisplayTopologyChanged {
+				c.mu.Lock()
+				available := c.reinitDisplay()
+				if available {
+					// a deferred change can be applied now
+					ret, changed, _ = c.applyPending()
+				}
+				c.mu.Unlock()
+				log.Printf("rr: displays changed, internal display available: %t\n", available)
+				cb <- plugin.Callback{
+					Event: plugin.CbNotifyClients,
+				}
+				break
+			}
+			ret, changed, err = c.handle(t)
+			if errors.Is(err, errNoDisplay) {
+				if isPowerEvent(t.Event) {
+					log.Println("rr: internal display is not primary, deferring refresh rate change")
+					retry = time.After(c.pendingRetry)
+					continue
+				}
+				cb <- plugin.Callback{
+					Event: plugin.CbNotifyToast,
+					Value: util.Notification{
+						Message: "Internal display is not primary, will not change refresh rate",
+						Delay:   notifyDelay,
+					},
+				}
+				continue
+			}
+		case <-haltCtx.Done():
+			log.Println("rr: exiting Plugin run loop")
+			c.mu.Lock()
+			if c.pDisplay != nil {
+				c.pDisplay.Release()
+			}
+			c.mu.Unlock()
+			return
+		}
+
+		if !changed {
+			continue
+		}
+		log.Printf("rr: ret value %d\n", ret)
+		n := util.Notification{
+			Delay: notifyDelay,
+		}
+		if ret == 0 {
+			n.Message = "Unable to change refresh rate"
+		} else {
+			n.Message = fmt.Sprintf("Refresh Rate changed to %d Hz", ret)
+		}
+		cb <- plugin.Callback{
+			Event: plugin.CbNotifyToast,
+			Value: n,
+		}
+	}
+}
+
+func isPowerEvent(evt plugin.Event) bool {
+	return evt == plugin.EvtChargerPluggedIn || evt == plugin.EvtChargerUnplugged
+}
+
+// applyPending switches to the refresh rate for the power source. errNoDisplay
+// is returned if it has to be tried again later. Caller must hold c.mu.
+func (c *Control) applyPending() (int, bool, error) {
+	if c.pendingRate == 0 {
+		return 0, false, nil
+	}
+	if c.pDisplay == nil {
+		c.initDisplay()
+		if c.pDisplay == nil {
+			return 0, false, errNoDisplay
+		}
+	}
+
+	rate := c.pendingRate
+	c.pendingRate = 0
+	if !display.Supports(c.pDisplay, rate) {
+		log.Printf("rr: %d Hz is not supported by the internal display\n", rate)
+		return 0, false, nil
+	}
+	if c.pDisplay.GetCurrent() == rate {
+		return 0, false, nil
+	}
+	return c.pDisplay.SetRefreshRate(rate), true, nil
+}
+
+// handle changes the refresh rate for the event. It returns the new refresh
+// rate, or 0 if it failed, and false if there was nothing to do.
+func (c *Control) handle(t plugin.Notification) (int, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if isPowerEvent(t.Event) {
+		c.pendingRate = c.power.Target(t.Event == plugin.EvtChargerPluggedIn)
+		// the power source takes over from the low battery rate
+		if c.pendingRate != 0 {
+			c.restoreRate = 0
+		}
+		return c.applyPending()
+	}
+
+	if c.pDisplay == nil {
+		// try again, in case the laptop now has internal display as primary
+		c.initDisplay()
+		if c.pDisplay == nil {
+			return 0, false, errNoDisplay
+		}
+	}
+
+	switch t.Event {
+	case plugin.EvtBatteryLow:
+		alert, ok := t.Value.(lowbattery.Alert)
+		if !ok || !alert.Has(lowbattery.LowestRefreshRate) {
+			return 0, false, nil
+		}
+		current, lowest := c.pDisplay.GetCurrent(), c.pDisplay.GetLowest()
+		if lowest == 0 || lowest >= current {
+			return 0, false, nil
+		}
+		ret := c.pDisplay.SetRefreshRate(lowest)
+		if ret != 0 && c.restoreRate == 0 {
+			c.restoreRate = current
+		}
+		return ret, true, nil
+	case plugin.EvtBatteryRestored:
+		if c.restoreRate == 0 {
+			return 0, false, nil
+		}
+		ret := c.pDisplay.SetRefreshRate(c.restoreRate)
+		c.restoreRate = 0
+		return ret, true, nil
+	default:
+		c.restoreRate = 0
+		c.pendingRate = 0
+		return c.pDisplay.CycleRefreshRate(), true, nil
+	}
+}
+
+// Run satisfies system/plugin.Plugin
+func (c *Control) Run(haltCtx context.Context, cb chan<- plugin.Callback) <-chan error {
+	log.Println("rr: Starting queue loop")
+
+	go c.loop(haltCtx, cb)
+
+	return c.errChan
+}
+
+// Notify satisfies system/plugin.Plugin
+func (c *Control) Notify(t plugin.Notification) {
+	if c.dryRun {
+		return
+	}
+
+	switch t.Event {
+	case plugin.EvtSentinelCycleRefreshRate, plugin.EvtBatteryLow, plugin.EvtBatteryRestored,
+		plugin.EvtChargerPluggedIn, plugin.EvtChargerUnplugged, plugin.EvtDisplayTopologyChanged:
+	default:
+		return
+	}
+
+	c.queue <- t
+}
+
+func (c *Control) GetWSInfo() gin.H {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info := gin.H{
+		"available":   c.pDisplay != nil,
+		"powerRates":  c.power,
+		"pendingRate": c.pendingRate,
+	}
+	if c.pDisplay != nil {
+		info["currentRefreshRate"] = c.pDisplay.GetCurrent()
+		info["refreshRates"] = c.pDisplay.ListRefreshRates()
+	}
+	return info
+}
+
+func (c *Control) HandleWSMessage(ws *websocket.Conn, action int, value string) {
+	switch action {
+	// Cycle RR
+	case 0:
+		c.mu.Lock()
+		if c.pDisplay != nil {
+			c.pDisplay.CycleRefreshRate()
+		} else {
+			log.Println("rr: internal display is unavailable, not cycling refresh rate")
+		}
+		c.mu.Unlock()
+	// Set RR (Hz)
+	case 1:
+		rate, err := strconv.Atoi(value)
+		if err == nil {
+			err = c.SetRefreshRate(rate)
+		}
+		if err != nil {
+			log.Printf("rr: unable to set refresh rate: %s\n", err)
+		}
+	// Set Refresh Rates for the power sources
+	case 2:
+		var p display.PowerRates
+		err := json.Unmarshal([]byte(value), &p)
+		if err == nil {
+			err = c.SetPowerRates(p)
+		}
+		if err != nil {
+			log.Printf("rr: unable to set power refresh rates: %s\n", err)
+		}
+	}
+}
+
+var _ persist.Registry = &Control{}
+
+// Name satisfies persist.Registry
+func (c *Control) Name() string {
+	return persistKey
+}
+
+// Value satisfies persist.Registry
+func (c *Control) Value() []byte {
+	b, _ := json.Marshal(c.PowerRates())
+	return b
+}
+
+// Load satisfies persist.Registry
+func (c *Control) Load(v []byte) error {
+	if len(v) == 0 {
+		return nil
+	}
+
+	var p display.PowerRates
+	if err := json.Unmarshal(v, &p); err != nil {
+		return err
+	}
+	return c.SetPowerRates(p)
+}
+
+// Apply satisfies persist.Registry
+func (c *Control) Apply() error {
+	return nil
+}
+
+// Close satisfied persist.Registry
+func (c *Control) Close() error {
+	return nil
+}
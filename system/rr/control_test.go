@@ -0,0 +1,257 @@
+package rr
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NeilSeligmann/G15Manager/system/display"
+	"github.com/NeilSeligmann/G15Manager/system/plugin"
+	"github.com/NeilSeligmann/G15Manager/util"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePanel opens the display only while the internal panel is primary
+type fakePanel struct {
+	mu      sync.Mutex
+	display *display.Fake
+	primary bool
+	opens   int
+}
+
+func (p *fakePanel) setPrimary(primary bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.primary = primary
+}
+
+func (p *fakePanel) open() (display.RefreshRater, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.opens++
+	if !p.primary {
+		return nil, errors.New("internal display not found")
+	}
+	return p.display, nil
+}
+
+func newTestControl(t *testing.T, primary bool) (*Control, *fakePanel) {
+	panel := &fakePanel{
+		display: display.NewFake(144, 60, 144),
+		primary: primary,
+	}
+	c, err := NewRRControl(false, panel.open)
+	require.NoError(t, err)
+	require.NoError(t, c.Initialize())
+	return c, panel
+}
+
+// run starts the loop, and returns the callbacks it sends
+func run(t *testing.T, c *Control) <-chan plugin.Callback {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	cb := make(chan plugin.Callback, 16)
+	c.Run(ctx, cb)
+	return cb
+}
+
+// waitFor returns the next callback of the event, skipping the others
+func waitFor(t *testing.T, cb <-chan plugin.Callback, event plugin.Event) plugin.Callback {
+	for {
+		select {
+		case c := <-cb:
+			if c.Event == event {
+				return c
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %v callback received", event)
+		}
+	}
+}
+
+func waitForToast(t *testing.T, cb <-chan plugin.Callback, message string) {
+	for {
+		c := waitFor(t, cb, plugin.CbNotifyToast)
+		if c.Value.(util.Notification).Message == message {
+			return
+		}
+	}
+}
+
+func TestNewRRControl(t *testing.T) {
+	_, err := NewRRControl(false, nil)
+	require.Error(t, err)
+}
+
+func TestSetRefreshRate(t *testing.T) {
+	c, panel := newTestControl(t, true)
+
+	err := c.SetRefreshRate(120)
+	require.True(t, errors.Is(err, display.ErrUnsupportedRate))
+	require.Equal(t, 0, panel.display.Sets)
+
+	require.NoError(t, c.SetRefreshRate(60))
+	require.Equal(t, 60, panel.display.Current)
+}
+
+func TestSetRefreshRateNoDisplay(t *testing.T) {
+	c, panel := newTestControl(t, false)
+
+	require.Equal(t, errNoDisplay, c.SetRefreshRate(60))
+
+	// the display is looked for again
+	panel.setPrimary(true)
+	require.NoError(t, c.SetRefreshRate(60))
+	require.Equal(t, 60, panel.display.Current)
+}
+
+func TestWSInfo(t *testing.T) {
+	c, _ := newTestControl(t, true)
+
+	info := c.GetWSInfo()
+	require.Equal(t, true, info["available"])
+	require.Equal(t, 144, info["currentRefreshRate"])
+	require.Equal(t, []int{60, 144}, info["refreshRates"])
+
+	c, _ = newTestControl(t, false)
+	info = c.GetWSInfo()
+	require.Equal(t, false, info["available"])
+	require.NotContains(t, info, "currentRefreshRate")
+	require.NotContains(t, info, "refreshRates")
+	require.Nil(t, c.RefreshRates())
+}
+
+var testPowerRates = display.PowerRates{
+	Enabled:   true,
+	PluggedIn: 144,
+	Unplugged: 60,
+}
+
+func TestPowerRates(t *testing.T) {
+	c, panel := newTestControl(t, true)
+	require.NoError(t, c.SetPowerRates(testPowerRates))
+
+	ret, changed, err := c.handle(plugin.Notification{Event: plugin.EvtChargerUnplugged})
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, 60, ret)
+
+	// already at the rate
+	_, changed, err = c.handle(plugin.Notification{Event: plugin.EvtChargerUnplugged})
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	ret, changed, err = c.handle(plugin.Notification{Event: plugin.EvtChargerPluggedIn})
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, 144, ret)
+	require.Equal(t, 2, panel.display.Sets)
+}
+
+func TestPowerRatesDeferred(t *testing.T) {
+	c, panel := newTestControl(t, false)
+	require.NoError(t, c.SetPowerRates(testPowerRates))
+
+	// the rate is kept until the internal display is primary
+	_, changed, err := c.handle(plugin.Notification{Event: plugin.EvtChargerUnplugged})
+	require.Equal(t, errNoDisplay, err)
+	require.False(t, changed)
+	require.Equal(t, 60, c.GetWSInfo()["pendingRate"])
+
+	panel.setPrimary(true)
+	c.mu.Lock()
+	ret, changed, err := c.applyPending()
+	c.mu.Unlock()
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, 60, ret)
+	require.Equal(t, 0, c.GetWSInfo()["pendingRate"])
+}
+
+func TestPowerRatesManualOverride(t *testing.T) {
+	c, panel := newTestControl(t, false)
+	require.NoError(t, c.SetPowerRates(testPowerRates))
+
+	_, _, err := c.handle(plugin.Notification{Event: plugin.EvtChargerUnplugged})
+	require.Equal(t, errNoDisplay, err)
+
+	// a manual change replaces the deferred one
+	panel.setPrimary(true)
+	require.NoError(t, c.SetRefreshRate(144))
+	require.Equal(t, 0, c.GetWSInfo()["pendingRate"])
+
+	c.mu.Lock()
+	_, changed, err := c.applyPending()
+	c.mu.Unlock()
+	require.NoError(t, err)
+	require.False(t, changed)
+	require.Equal(t, 144, panel.display.Current)
+}
+
+func TestPowerRatesRetry(t *testing.T) {
+	c, panel := newTestControl(t, false)
+	require.NoError(t, c.SetPowerRates(testPowerRates))
+	c.pendingRetry = time.Millisecond * 10
+
+	cb := run(t, c)
+	c.Notify(plugin.Notification{Event: plugin.EvtChargerUnplugged})
+
+	// tried again until the internal display is primary
+	time.Sleep(c.pendingRetry * 3)
+	panel.setPrimary(true)
+	waitForToast(t, cb, "Refresh Rate changed to 60 Hz")
+
+	panel.mu.Lock()
+	require.Greater(t, panel.opens, 2)
+	panel.mu.Unlock()
+	require.Equal(t, 60, c.GetWSInfo()["currentRefreshRate"])
+}
+
+func TestPowerRatesTopologyChanged(t *testing.T) {
+	c, panel := newTestControl(t, false)
+	require.NoError(t, c.SetPowerRates(testPowerRates))
+	c.pendingRetry = time.Hour
+
+	cb := run(t, c)
+	c.Notify(plugin.Notification{Event: plugin.EvtChargerUnplugged})
+
+	panel.setPrimary(true)
+	c.Notify(plugin.Notification{Event: plugin.EvtDisplayTopologyChanged})
+	waitForToast(t, cb, "Refresh Rate changed to 60 Hz")
+
+	info := c.GetWSInfo()
+	require.Equal(t, 60, info["currentRefreshRate"])
+	require.Equal(t, 0, info["pendingRate"])
+}
+
+func TestTopologyChangedReopens(t *testing.T) {
+	c, panel := newTestControl(t, true)
+	cb := run(t, c)
+
+	// the internal display is no longer primary
+	panel.setPrimary(false)
+	c.Notify(plugin.Notification{Event: plugin.EvtDisplayTopologyChanged})
+	waitFor(t, cb, plugin.CbNotifyClients)
+	require.Equal(t, gin.H{
+		"available":   false,
+		"powerRates":  display.PowerRates{},
+		"pendingRate": 0,
+	}, c.GetWSInfo())
+
+	panel.setPrimary(true)
+	c.Notify(plugin.Notification{Event: plugin.EvtDisplayTopologyChanged})
+	waitFor(t, cb, plugin.CbNotifyClients)
+	info := c.GetWSInfo()
+	require.Equal(t, true, info["available"])
+	require.Equal(t, []int{60, 144}, info["refreshRates"])
+
+	panel.mu.Lock()
+	require.Equal(t, 3, panel.opens)
+	panel.mu.Unlock()
+}
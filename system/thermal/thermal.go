@@ -20,15 +20,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"os/exec"
 	"strconv"
-	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	// "github.com/NeilSeligmann/G15Manager/rpc/announcement"
 	"github.com/NeilSeligmann/G15Manager/system/atkacpi"
+	"github.com/NeilSeligmann/G15Manager/system/model"
+	"github.com/NeilSeligmann/G15Manager/system/nvidia"
 	"github.com/NeilSeligmann/G15Manager/system/plugin"
 	"github.com/NeilSeligmann/G15Manager/system/power"
 	"github.com/NeilSeligmann/G15Manager/util"
@@ -59,13 +58,15 @@ type Control struct {
 	wmi                 atkacpi.WMI
 	currentProfileIndex int
 
-	errorCh chan error
-	queue   chan plugin.Notification
+	errorCh   chan error
+	queue     chan plugin.Notification
+	profileCh chan string
 }
 
 // Config defines the entry point for Windows Power Option and a list of thermal profiles
 type Config struct {
 	WMI               atkacpi.WMI
+	Model             *model.Model
 	PowerCfg          *power.Cfg
 	Profiles          []Profile
 	AutoThermal       bool
@@ -73,6 +74,8 @@ type Config struct {
 		PluggedIn string
 		Unplugged string
 	}
+	// GPU provides the dGPU telemetry, it is optional
+	GPU *nvidia.SMI
 }
 
 type PersistConfig struct {
@@ -83,6 +86,8 @@ type PersistConfig struct {
 type Temperatures struct {
 	GPU float32 `json:"gpu"`
 	CPU float32 `json:"cpu"`
+	// GPUTelemetry is nil if the dGPU cannot be queried, e.g. in Eco mode
+	GPUTelemetry *nvidia.Telemetry `json:"gpuTelemetry"`
 }
 
 var _ plugin.Plugin = &Control{}
@@ -92,6 +97,9 @@ func NewControl(conf Config) (*Control, error) {
 	if conf.WMI == nil {
 		return nil, errors.New("nil WMI is invalid")
 	}
+	if conf.Model == nil {
+		return nil, errors.New("nil Model is invalid")
+	}
 	if conf.PowerCfg == nil {
 		return nil, errors.New("nil PowerCfg is invalid")
 	}
@@ -111,6 +119,7 @@ func NewControl(conf Config) (*Control, error) {
 		currentProfileIndex: 0,
 		errorCh:             make(chan error),
 		queue:               make(chan plugin.Notification),
+		profileCh:           make(chan string, 1),
 	}
 
 	return ctrl, nil
@@ -150,10 +159,27 @@ func (c *Control) setProfile(index int) (string, error) {
 	}
 
 	c.currentProfileIndex = index
+	c.announceProfile(nextProfile.Name)
 
 	return nextProfile.Name, nil
 }
 
+// announceProfile queues the profile name to be sent to other plugins from the
+// run loop. Only the latest profile is kept if the loop is not running yet.
+func (c *Control) announceProfile(name string) {
+	for {
+		select {
+		case c.profileCh <- name:
+			return
+		default:
+		}
+		select {
+		case <-c.profileCh:
+		default:
+		}
+	}
+}
+
 // SwitchToProfile will switch the profile with the given name
 func (c *Control) SwitchToProfile(name string) (string, error) {
 	c.mu.Lock()
@@ -201,7 +227,7 @@ func (c *Control) NextProfile(howMany int) (string, error) {
 
 func (c *Control) setThrottlePlan(profile Profile) error {
 	args := make([]byte, 8)
-	binary.LittleEndian.PutUint32(args[0:], atkacpi.DevsThrottleCtrl)
+	binary.LittleEndian.PutUint32(args[0:], c.Config.Model.Devices.ThrottleCtrl)
 	binary.LittleEndian.PutUint32(args[4:], profile.ThrottlePlan)
 
 	_, err := c.wmi.Evaluate(atkacpi.DEVS, args)
@@ -215,6 +241,11 @@ func (c *Control) setThrottlePlan(profile Profile) error {
 }
 
 func (c *Control) setFanCurve(profile Profile) error {
+	if !c.Config.Model.FanCurve {
+		log.Printf("thermal: %s does not support custom fan curves\n", c.Config.Model)
+		return nil
+	}
+
 	if profile.CPUFanCurve != nil {
 		cpuFanCurve := profile.CPUFanCurve.Bytes()
 
@@ -224,7 +255,7 @@ func (c *Control) setFanCurve(profile Profile) error {
 		}
 
 		cpuArgs := make([]byte, 20)
-		binary.LittleEndian.PutUint32(cpuArgs[0:], atkacpi.DevsCPUFanCurve)
+		binary.LittleEndian.PutUint32(cpuArgs[0:], c.Config.Model.Devices.CPUFanCurve)
 		copy(cpuArgs[4:], cpuFanCurve)
 
 		if _, err := c.wmi.Evaluate(atkacpi.DEVS, cpuArgs); err != nil {
@@ -245,7 +276,7 @@ func (c *Control) setFanCurve(profile Profile) error {
 		}
 
 		gpuArgs := make([]byte, 20)
-		binary.LittleEndian.PutUint32(gpuArgs[0:], atkacpi.DevsGPUFanCurve)
+		binary.LittleEndian.PutUint32(gpuArgs[0:], c.Config.Model.Devices.GPUFanCurve)
 		copy(gpuArgs[4:], gpuFanCurve)
 
 		if _, err := c.wmi.Evaluate(atkacpi.DEVS, gpuArgs); err != nil {
@@ -273,6 +304,11 @@ func (c *Control) loop(haltCtx context.Context, cb chan<- plugin.Callback) {
 
 	for {
 		select {
+		case name := <-c.profileCh:
+			cb <- plugin.Callback{
+				Event: plugin.CbThermalProfileChanged,
+				Value: name,
+			}
 		case t := <-c.queue:
 			switch t.Event {
 			case plugin.EvtSentinelCycleThermalProfile:
@@ -520,25 +556,20 @@ func (c *Control) ResetProfiles() {
 func (c *Control) GetTemperatures() Temperatures {
 	output := Temperatures{}
 
-	cmd := exec.Command("cmd.exe", "/c", "nvidia-smi --query-gpu=temperature.gpu --format=csv,noheader")
-	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: 0x08000000}
-
-	// Get GPU Temp
-	out, err := cmd.Output()
-	if err != nil {
-		log.Println("Failed to run Nvidia SMI to get GPU temperatures.")
-		log.Print(err)
+	if c.Config.GPU == nil {
 		return output
 	}
 
-	parsedGPU, err := strconv.ParseFloat(strings.Replace(string(out), "\r\n", "", -1), 32)
+	// Get GPU Telemetry
+	telemetry, err := c.Config.GPU.Telemetry(context.Background())
 	if err != nil {
-		log.Println("Failed to parse Nvidia SMI's output.")
+		log.Println("Failed to run Nvidia SMI to get GPU telemetry.")
 		log.Print(err)
 		return output
 	}
 
-	output.GPU = float32(parsedGPU)
+	output.GPU = float32(telemetry.Temperature)
+	output.GPUTelemetry = &telemetry
 
 	return output
 }
@@ -0,0 +1,34 @@
+package vkey
+
+import (
+	"github.com/micmonay/keybd_event"
+)
+
+// keybd_event marks virtual-key codes (as opposed to scan codes) with this offset
+const keybdVirtualOffset = 0xFFF
+
+type keybdBackend struct{}
+
+var _ Backend = keybdBackend{}
+
+// NewKeybdBackend returns a Backend using keybd_event()
+func NewKeybdBackend() Backend {
+	return keybdBackend{}
+}
+
+func (keybdBackend) Send(c Combo) error {
+	kb, err := keybd_event.NewKeyBonding()
+	if err != nil {
+		return err
+	}
+
+	kb.HasCTRL(c.Ctrl)
+	kb.HasSHIFT(c.Shift)
+	kb.HasALT(c.Alt)
+	kb.HasSuper(c.Win)
+	for _, k := range c.Keys {
+		kb.AddKey(int(k) + keybdVirtualOffset)
+	}
+
+	return kb.Launching()
+}
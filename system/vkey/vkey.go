@@ -0,0 +1,217 @@
+// Package vkey emits Windows virtual keys, such as media and volume keys, or
+// custom key combinations.
+package vkey
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BindingPrefix is the prefix of a binding that emits a key combination, e.g. "$key:playPause"
+const BindingPrefix = "$key:"
+
+// Key is a Windows virtual-key code
+type Key uint16
+
+// Defines the named virtual keys.
+// See https://docs.microsoft.com/en-us/windows/win32/inputdev/virtual-key-codes
+const (
+	Backspace      Key = 0x08
+	Tab            Key = 0x09
+	Enter          Key = 0x0d
+	Pause          Key = 0x13
+	CapsLock       Key = 0x14
+	Escape         Key = 0x1b
+	Space          Key = 0x20
+	PageUp         Key = 0x21
+	PageDown       Key = 0x22
+	End            Key = 0x23
+	Home           Key = 0x24
+	Left           Key = 0x25
+	Up             Key = 0x26
+	Right          Key = 0x27
+	Down           Key = 0x28
+	PrintScreen    Key = 0x2c
+	Insert         Key = 0x2d
+	Delete         Key = 0x2e
+	F1             Key = 0x70
+	BrowserBack    Key = 0xa6
+	BrowserForward Key = 0xa7
+	BrowserRefresh Key = 0xa8
+	BrowserHome    Key = 0xac
+	VolumeMute     Key = 0xad
+	VolumeDown     Key = 0xae
+	VolumeUp       Key = 0xaf
+	NextTrack      Key = 0xb0
+	PrevTrack      Key = 0xb1
+	Stop           Key = 0xb2
+	PlayPause      Key = 0xb3
+	LaunchMail     Key = 0xb4
+	LaunchMedia    Key = 0xb5
+	LaunchApp1     Key = 0xb6
+	LaunchApp2     Key = 0xb7
+)
+
+var names = map[string]Key{
+	"backspace":      Backspace,
+	"tab":            Tab,
+	"enter":          Enter,
+	"pause":          Pause,
+	"capslock":       CapsLock,
+	"esc":            Escape,
+	"escape":         Escape,
+	"space":          Space,
+	"pageup":         PageUp,
+	"pagedown":       PageDown,
+	"end":            End,
+	"home":           Home,
+	"left":           Left,
+	"up":             Up,
+	"right":          Right,
+	"down":           Down,
+	"printscreen":    PrintScreen,
+	"insert":         Insert,
+	"delete":         Delete,
+	"browserback":    BrowserBack,
+	"browserforward": BrowserForward,
+	"browserrefresh": BrowserRefresh,
+	"browserhome":    BrowserHome,
+	"volumemute":     VolumeMute,
+	"mute":           VolumeMute,
+	"volumedown":     VolumeDown,
+	"volumeup":       VolumeUp,
+	"nexttrack":      NextTrack,
+	"prevtrack":      PrevTrack,
+	"stop":           Stop,
+	"playpause":      PlayPause,
+	"launchmail":     LaunchMail,
+	"launchmedia":    LaunchMedia,
+	"launchapp1":     LaunchApp1,
+	"launchapp2":     LaunchApp2,
+}
+
+// Combo is a key combination. Modifiers are held while Keys are pressed in order.
+type Combo struct {
+	Ctrl  bool
+	Shift bool
+	Alt   bool
+	Win   bool
+	Keys  []Key
+}
+
+func (c Combo) String() string {
+	parts := make([]string, 0, len(c.Keys)+4)
+	if c.Ctrl {
+		parts = append(parts, "ctrl")
+	}
+	if c.Shift {
+		parts = append(parts, "shift")
+	}
+	if c.Alt {
+		parts = append(parts, "alt")
+	}
+	if c.Win {
+		parts = append(parts, "win")
+	}
+	for _, k := range c.Keys {
+		parts = append(parts, fmt.Sprintf("0x%02x", uint16(k)))
+	}
+	return strings.Join(parts, "+")
+}
+
+// Parse parses a key combination such as "playPause", "ctrl+shift+esc",
+// "win+f1" or "alt+0x41". Names are case insensitive, and letters and digits
+// can be used as is.
+func Parse(s string) (Combo, error) {
+	var c Combo
+	for _, part := range strings.Split(s, "+") {
+		name := strings.ToLower(strings.TrimSpace(part))
+		switch name {
+		case "":
+			return Combo{}, fmt.Errorf("vkey: empty key in %q", s)
+		case "ctrl", "control":
+			c.Ctrl = true
+		case "shift":
+			c.Shift = true
+		case "alt":
+			c.Alt = true
+		case "win", "super":
+			c.Win = true
+		default:
+			k, err := parseKey(name)
+			if err != nil {
+				return Combo{}, err
+			}
+			c.Keys = append(c.Keys, k)
+		}
+	}
+	if len(c.Keys) == 0 {
+		return Combo{}, fmt.Errorf("vkey: %q has no key besides modifiers", s)
+	}
+	return c, nil
+}
+
+func parseKey(name string) (Key, error) {
+	if k, ok := names[name]; ok {
+		return k, nil
+	}
+	// letters and digits have the same code as their upper case ASCII
+	if len(name) == 1 && (name[0] >= 'a' && name[0] <= 'z' || name[0] >= '0' && name[0] <= '9') {
+		return Key(strings.ToUpper(name)[0]), nil
+	}
+	if strings.HasPrefix(name, "f") {
+		if n, err := strconv.Atoi(name[1:]); err == nil && n >= 1 && n <= 24 {
+			return F1 + Key(n-1), nil
+		}
+	}
+	if strings.HasPrefix(name, "0x") {
+		v, err := strconv.ParseUint(name[2:], 16, 8)
+		if err != nil || v == 0 {
+			return 0, fmt.Errorf("vkey: invalid key code %q", name)
+		}
+		return Key(v), nil
+	}
+	return 0, fmt.Errorf("vkey: unknown key %q", name)
+}
+
+// Binding returns the key combination if the binding emits one
+func Binding(cmd string) (string, bool) {
+	if !strings.HasPrefix(cmd, BindingPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(cmd, BindingPrefix), true
+}
+
+// Backend sends key combinations to the system
+type Backend interface {
+	Send(c Combo) error
+}
+
+// Emitter parses and emits key combinations through a Backend
+type Emitter struct {
+	backend Backend
+}
+
+// NewEmitter returns an Emitter sending to backend
+func NewEmitter(backend Backend) (*Emitter, error) {
+	if backend == nil {
+		return nil, errors.New("vkey: nil Backend is invalid")
+	}
+	return &Emitter{
+		backend: backend,
+	}, nil
+}
+
+// Emit parses the key combination and sends it
+func (e *Emitter) Emit(s string) error {
+	c, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	if err := e.backend.Send(c); err != nil {
+		return fmt.Errorf("vkey: unable to send %s: %w", s, err)
+	}
+	return nil
+}
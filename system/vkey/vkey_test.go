@@ -0,0 +1,80 @@
+package vkey
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeBackend struct {
+	sent []Combo
+	err  error
+}
+
+func (f *fakeBackend) Send(c Combo) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.sent = append(f.sent, c)
+	return nil
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in    string
+		combo Combo
+	}{
+		{in: "playPause", combo: Combo{Keys: []Key{PlayPause}}},
+		{in: "volumeUp", combo: Combo{Keys: []Key{VolumeUp}}},
+		{in: "ctrl+shift+esc", combo: Combo{Ctrl: true, Shift: true, Keys: []Key{Escape}}},
+		{in: "Win + D", combo: Combo{Win: true, Keys: []Key{'D'}}},
+		{in: "alt+f4", combo: Combo{Alt: true, Keys: []Key{0x73}}},
+		{in: "f24", combo: Combo{Keys: []Key{0x87}}},
+		{in: "ctrl+0x41", combo: Combo{Ctrl: true, Keys: []Key{0x41}}},
+		{in: "1+2", combo: Combo{Keys: []Key{'1', '2'}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			c, err := Parse(tc.in)
+			require.NoError(t, err)
+			require.Equal(t, tc.combo, c)
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, in := range []string{"", "ctrl", "ctrl++a", "f25", "0x", "0x100", "hyper"} {
+		_, err := Parse(in)
+		require.Error(t, err, in)
+	}
+}
+
+func TestBinding(t *testing.T) {
+	s, ok := Binding("$key:ctrl+c")
+	require.True(t, ok)
+	require.Equal(t, "ctrl+c", s)
+
+	_, ok = Binding("$macro:hello")
+	require.False(t, ok)
+}
+
+func TestEmit(t *testing.T) {
+	_, err := NewEmitter(nil)
+	require.Error(t, err)
+
+	b := &fakeBackend{}
+	e, err := NewEmitter(b)
+	require.NoError(t, err)
+
+	require.NoError(t, e.Emit("playPause"))
+	require.NoError(t, e.Emit("ctrl+alt+delete"))
+	require.Error(t, e.Emit("nope"))
+	require.Equal(t, []Combo{
+		{Keys: []Key{PlayPause}},
+		{Ctrl: true, Alt: true, Keys: []Key{Delete}},
+	}, b.sent)
+
+	b.err = errors.New("access denied")
+	require.Error(t, e.Emit("mute"))
+}
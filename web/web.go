@@ -1,7 +1,9 @@
 package web
 
 import (
+	"fmt"
 	"log"
+	"net/http"
 	"time"
 
 	"github.com/gin-gonic/contrib/static"
@@ -9,6 +11,7 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/NeilSeligmann/G15Manager/controller"
+	"github.com/NeilSeligmann/G15Manager/system/battery/health"
 )
 
 type WebServerInstance struct {
@@ -59,6 +62,34 @@ func NewWebServer(dep *controller.Dependencies) *WebServerInstance {
 			defer instance.handleSocket(c)
 			defer webServerInstance.StartLoop()
 		})
+
+		// Battery health report, as JSON or CSV (?format=csv). The CSV has
+		// the capacity history, or the time at each charge limit with
+		// ?section=limits.
+		v1.GET("/battery/health", func(c *gin.Context) {
+			report := dep.Battery.HealthReport()
+			if c.Query("format") != "csv" {
+				c.JSON(http.StatusOK, report)
+				return
+			}
+
+			write, filename := health.WriteCSV, "battery-health.csv"
+			switch c.Query("section") {
+			case "", "history":
+			case "limits":
+				write, filename = health.LimitShareCSV, "battery-limits.csv"
+			default:
+				c.String(http.StatusBadRequest, "unknown section %q", c.Query("section"))
+				return
+			}
+
+			c.Header("Content-Type", "text/csv")
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+			c.Status(http.StatusOK)
+			if err := write(c.Writer, report); err != nil {
+				log.Printf("Failed to write battery health report: %s\n", err)
+			}
+		})
 	}
 
 	go func() {
@@ -86,10 +117,14 @@ func (webInst *WebServerInstance) StartLoop() {
 
 			// Loop
 			temps := webInst.Dependencies.Thermal.GetTemperatures()
+			batteryStatus, batteryErr := webInst.Dependencies.Battery.Status()
 
-			// Send temps to all sockets
+			// Send temps and battery status to all sockets
 			for _, socket := range webInst.SocketInstances {
 				socket.SendTemperatures(temps)
+				if batteryErr == nil {
+					socket.SendBatteryStatus(batteryStatus)
+				}
 			}
 		}
 	}()
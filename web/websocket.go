@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/NeilSeligmann/G15Manager/controller"
+	"github.com/NeilSeligmann/G15Manager/system/battery/health"
+	"github.com/NeilSeligmann/G15Manager/system/battery/telemetry"
 	"github.com/NeilSeligmann/G15Manager/system/thermal"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -153,6 +155,12 @@ func (inst *SocketInstance) processMessage(messageType int, message []byte) {
 	// Denoise AI
 	case 6:
 		inst.Dependencies.AIDenoise.HandleWSMessage(inst.ws, decodedMessage.Action, decodedMessage.Value)
+	// GPU
+	case 7:
+		inst.Dependencies.GPU.HandleWSMessage(inst.ws, decodedMessage.Action, decodedMessage.Value)
+	// Panel
+	case 8:
+		inst.Dependencies.Panel.HandleWSMessage(inst.ws, decodedMessage.Action, decodedMessage.Value)
 	}
 
 	// Save config
@@ -182,6 +190,9 @@ func (inst *SocketInstance) handleSystemMessage(action int, value string) {
 			log.Printf("Failed to download client:")
 			log.Fatal(err)
 		}
+	// Battery Health Report
+	case 2:
+		inst.SendBatteryHealth(inst.Dependencies.Battery.HealthReport())
 	}
 }
 
@@ -195,6 +206,8 @@ func (inst *SocketInstance) SendInfo() {
 			"rr":       inst.Dependencies.RR.GetWSInfo(),
 			"battery":  inst.Dependencies.Battery.GetWSInfo(),
 			"denoise":  inst.Dependencies.AIDenoise.GetWSInfo(),
+			"gpu":      inst.Dependencies.GPU.GetWSInfo(),
+			"panel":    inst.Dependencies.Panel.GetWSInfo(),
 			"versions": inst.Dependencies.Version.GetWSInfo(),
 		},
 	})
@@ -207,6 +220,20 @@ func (inst *SocketInstance) SendTemperatures(temps thermal.Temperatures) {
 	})
 }
 
+func (inst *SocketInstance) SendBatteryStatus(status telemetry.Status) {
+	inst.SendJSON(gin.H{
+		"action": 3,
+		"data":   status,
+	})
+}
+
+func (inst *SocketInstance) SendBatteryHealth(report health.Report) {
+	inst.SendJSON(gin.H{
+		"action": 4,
+		"data":   report,
+	})
+}
+
 func (inst *SocketInstance) SendJSON(v interface{}) {
 	inst.mu.Lock()
 	defer inst.mu.Unlock()